@@ -19,15 +19,21 @@ type (
 	}
 
 	BucketConfig struct {
-		Path           string           `json:"path" yaml:"path"`                         // local path or ?
-		Driver         string           `json:"driver" yaml:"driver"`                     // native, custom-driver
-		Type           string           `json:"type" yaml:"type"`                         // normal, cold, hot, fastmemory
-		DBType         string           `json:"db_type" yaml:"db_type"`                   // boltdb, badgerdb, pebble
-		DBPath         string           `json:"db_path" yaml:"db_path"`                   // db path, defult: <bucket_path>/.indexdb
-		AsyncLoad      bool             `json:"async_load" yaml:"async_load"`             // load metadata async
-		SliceSize      uint64           `json:"slice_size" yaml:"slice_size"`             // slice size for each part
-		MaxObjectLimit int              `json:"max_object_limit" yaml:"max_object_limit"` // max object limit, upper Bound discard
-		Migration      *MigrationConfig `json:"migration" yaml:"migration"`               // migration config
-		DBConfig       map[string]any   `json:"db_config" yaml:"db_config"`               // custom db config
+		Path            string           `json:"path" yaml:"path"`                           // local path or ?
+		Driver          string           `json:"driver" yaml:"driver"`                       // native, custom-driver
+		Type            string           `json:"type" yaml:"type"`                           // normal, cold, hot, fastmemory
+		Weight          int              `json:"weight" yaml:"weight"`                       // selection weight, default 100
+		DBType          string           `json:"db_type" yaml:"db_type"`                     // boltdb, badgerdb, pebble
+		DBPath          string           `json:"db_path" yaml:"db_path"`                     // db path, defult: <bucket_path>/.indexdb
+		AsyncLoad       bool             `json:"async_load" yaml:"async_load"`               // load metadata async
+		SliceSize       uint64           `json:"slice_size" yaml:"slice_size"`               // slice size for each part
+		MaxObjectLimit  int              `json:"max_object_limit" yaml:"max_object_limit"`   // max object limit, upper Bound discard
+		MaxCacheSize    uint64           `json:"max_cache_size" yaml:"max_cache_size"`       // max bytes of cached objects, upper Bound discard
+		EvictionPolicy  string           `json:"eviction_policy" yaml:"eviction_policy"`     // fifo, lru, lfu, size
+		Migration       *MigrationConfig `json:"migration" yaml:"migration"`                 // migration config
+		DBConfig        map[string]any   `json:"db_config" yaml:"db_config"`                 // custom db config
+		MaxUsagePercent int              `json:"max_usage_percent" yaml:"max_usage_percent"` // disk space/inode usage upper bound, default 95
+		MaxIOErrors     int              `json:"max_io_errors" yaml:"max_io_errors"`         // I/O errors within 1m mark bucket bad, default 10
+		TagHeader       string           `json:"tag_header" yaml:"tag_header"`               // response header of cache tags, empty disables tag index
 	}
 )
@@ -11,6 +11,9 @@ import (
 
 var ErrKeyNotFound = errors.New("key not found")
 
+// ErrIndexDBClosed is returned by the operations issued after IndexDB.Close.
+var ErrIndexDBClosed = errors.New("indexdb closed")
+
 const (
 	TypeInMemory = "memory"
 	TypeNormal   = "normal" // normal, warm 同一个
@@ -46,6 +49,11 @@ type IndexDB interface {
 	// Returns error if the iteration fails
 	Iterate(ctx context.Context, prefix []byte, f IterateFunc) error
 
+	// Scan walks through the metadata entries in key order, starting from
+	// the first key greater than or equal to from (nil from the first entry)
+	// Stops when f returns false, returns error if the iteration fails
+	Scan(ctx context.Context, from []byte, f IterateFunc) error
+
 	// Expired iterates through expired metadata entries
 	// Calls the provided function f for each expired entry
 	// Returns error if the iteration fails
@@ -38,6 +38,9 @@ type Operation interface {
 	DiscardWithMetadata(ctx context.Context, meta *object.Metadata) error
 	// Iterate iterates the objects.
 	Iterate(ctx context.Context, fn func(*object.Metadata) error) error
+	// Scan iterates the objects in hash order from the hash greater than or equal to from,
+	// nil from starts from the first object, fn returns false to stop.
+	Scan(ctx context.Context, from []byte, fn func(*object.Metadata) bool) error
 	// Expired if the object is expired callback.
 	Expired(ctx context.Context, id *object.ID, md *object.Metadata) bool
 	// WriteChunkFile open chunk file and returns io.WriteCloser
@@ -70,11 +73,16 @@ type Migration interface {
 	Promote(ctx context.Context, id *object.ID, src Bucket) error
 }
 
+// LayerSelector is implemented by the storages selecting the Bucket of a given layer,
+// e.g. TypeInMemory for the objects forced into memory.
+type LayerSelector interface {
+	// SelectLayer selects the Bucket by the object ID and layer, nil if the layer is not configured.
+	SelectLayer(ctx context.Context, id *object.ID, layer string) Bucket
+}
+
 type Migrator interface {
 	Storage
-
-	// SelectLayer selects the Bucket by the object ID and layer.
-	SelectLayer(ctx context.Context, id *object.ID, layer string) Bucket
+	LayerSelector
 }
 
 type Bucket interface {
@@ -106,6 +114,7 @@ type Bucket interface {
 type PurgeControl struct {
 	Hard        bool `json:"hard"`         // 是否硬删除, default: false 与 MarkExpired 冲突
 	Dir         bool `json:"dir"`          // 是否清理目录, default: false
+	Tag         bool `json:"tag"`          // 是否按缓存标签清理, storeUrl 即为 tag, default: false
 	MarkExpired bool `json:"mark_expired"` // 是否标记为过期, default: false 与 Hard 冲突
 }
 
@@ -114,6 +123,9 @@ func (r PurgeControl) String() string {
 	if r.Dir {
 		mode = "dir"
 	}
+	if r.Tag {
+		mode = "tag"
+	}
 	expOrHard := "mark_expired"
 	if r.Hard {
 		expOrHard = "hard_del"
@@ -202,6 +214,8 @@ const (
 	CacheHotHit
 	// BYPASS indicates the request bypassed the cache entirely.
 	BYPASS
+	// CacheStale indicates an expired resource served while the origin is failing or being revalidated.
+	CacheStale
 )
 
 var cacheStatusMap = map[CacheStatus]string{
@@ -214,6 +228,7 @@ var cacheStatusMap = map[CacheStatus]string{
 	CachePartMiss:       "PART_MISS",
 	CacheHotHit:         "HOT_HIT",
 	BYPASS:              "BYPASS",
+	CacheStale:          "STALE",
 }
 
 func (r CacheStatus) String() string {
@@ -2,12 +2,23 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
 	"strings"
+
+	"github.com/omalloc/tavern/pkg/logcrypto"
+)
+
+var (
+	flagDecrypt = flag.Bool("decrypt", false, "decrypt access log lines written with access_log.encrypt enabled")
+	flagKey     = flag.String("key", "", "access_log.encrypt.secret, defaults to env TAVERN_ACCESS_LOG_KEY")
+	flagRaw     = flag.Bool("raw", false, "print the decrypted line as-is instead of the field list")
 )
 
 var marker = map[int]string{
@@ -31,6 +42,22 @@ var marker = map[int]string{
 }
 
 func main() {
+	flag.Parse()
+
+	var c *logcrypto.Cipher
+	if *flagDecrypt {
+		key := *flagKey
+		if key == "" {
+			key = os.Getenv("TAVERN_ACCESS_LOG_KEY")
+		}
+
+		var err error
+		if c, err = logcrypto.New(key); err != nil {
+			fmt.Fprintf(os.Stderr, "tq: %s, use -key or env TAVERN_ACCESS_LOG_KEY\n", err)
+			os.Exit(2)
+		}
+	}
+
 	in := bufio.NewReader(os.Stdin)
 	for {
 		line, err := in.ReadBytes('\n')
@@ -38,28 +65,92 @@ func main() {
 			return
 		}
 
-		sb := strings.Builder{}
-		fields := strings.Split(string(line), " ")
-		for i, field := range fields {
-			mark := marker[i]
-			if mark == "" {
+		// plain lines are passed through, the file may be mixed after toggling encrypt.
+		if c != nil && logcrypto.IsEncrypted(line) {
+			plain, err := c.Decrypt(line)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "tq: %s\n", err)
 				continue
 			}
+			line = plain
+		}
 
-			sb.WriteString("(")
-			sb.WriteString(strconv.Itoa(i))
-			sb.WriteString(")")
-			sb.WriteString(mark)
-			sb.WriteString(": ")
-			sb.WriteString(field)
+		if *flagRaw {
+			fmt.Println(strings.TrimRight(string(line), "\n"))
+			continue
+		}
 
-			if i+1 < len(marker) && marker[i+1] == "" {
-				sb.WriteString(fields[i+1])
+		// auto-detect json-lines encoder
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && trimmed[0] == '{' {
+			out, err := formatJSON(trimmed)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "tq: %s\n", err)
+				continue
 			}
+			fmt.Println(out)
+			continue
+		}
 
-			sb.WriteString("\n")
+		fmt.Println(formatText(line))
+	}
+}
+
+// formatText decodes the positional text format by column index.
+func formatText(line []byte) string {
+	sb := strings.Builder{}
+	fields := strings.Split(strings.TrimRight(string(line), "\n"), " ")
+	for i, field := range fields {
+		mark := marker[i]
+		if mark == "" {
+			continue
+		}
+
+		sb.WriteString("(")
+		sb.WriteString(strconv.Itoa(i))
+		sb.WriteString(")")
+		sb.WriteString(mark)
+		sb.WriteString(": ")
+		sb.WriteString(field)
+
+		if i+1 < len(marker) && marker[i+1] == "" && i+1 < len(fields) {
+			sb.WriteString(fields[i+1])
+		}
+
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// formatJSON prints the keys of a json line in written order.
+func formatJSON(line []byte) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return "", fmt.Errorf("invalid json line: %s", line)
+	}
+
+	sb := strings.Builder{}
+	for i := 0; dec.More(); i++ {
+		key, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		var val any
+		if err := dec.Decode(&val); err != nil {
+			return "", err
+		}
+		if val == nil || val == "" {
+			val = "-"
 		}
 
-		fmt.Println(sb.String())
+		sb.WriteString("(")
+		sb.WriteString(strconv.Itoa(i))
+		sb.WriteString(")")
+		sb.WriteString(fmt.Sprint(key))
+		sb.WriteString(": ")
+		sb.WriteString(fmt.Sprint(val))
+		sb.WriteString("\n")
 	}
+	return sb.String(), nil
 }
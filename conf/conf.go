@@ -1,6 +1,7 @@
 package conf
 
 import (
+	"fmt"
 	"time"
 
 	middlewarev1 "github.com/omalloc/tavern/api/defined/v1/middleware"
@@ -41,6 +42,103 @@ type Server struct {
 	PProf              *ServerPProf               `json:"pprof" yaml:"pprof"`
 	AccessLog          *ServerAccessLog           `json:"access_log" yaml:"access_log"`
 	LocalApiAllowHosts []string                   `json:"local_api_allow_hosts" yaml:"local_api_allow_hosts"`
+	TLS                *ServerTLS                 `json:"tls" yaml:"tls"`
+	Listeners          []*ServerListener          `json:"listeners" yaml:"listeners"`
+	Admin              *ServerAdmin               `json:"admin" yaml:"admin"`
+}
+
+// ServerListener is a listening address with its own middleware chain and timeouts,
+// the zero values are inherited from `server`.
+type ServerListener struct {
+	Name              string                     `json:"name" yaml:"name"`
+	Addr              string                     `json:"addr" yaml:"addr"` // tcp address or unix socket `*.sock`
+	ReadTimeout       time.Duration              `json:"read_timeout" yaml:"read_timeout"`
+	WriteTimeout      time.Duration              `json:"write_timeout" yaml:"write_timeout"`
+	IdleTimeout       time.Duration              `json:"idle_timeout" yaml:"idle_timeout"`
+	ReadHeaderTimeout time.Duration              `json:"read_header_timeout" yaml:"read_header_timeout"`
+	MaxHeaderBytes    int                        `json:"max_header_bytes" yaml:"max_header_bytes"`
+	Middleware        []*middlewarev1.Middleware `json:"middleware" yaml:"middleware"`
+	LocalAPI          bool                       `json:"local_api" yaml:"local_api"` // internal routes & plugin APIs are reachable
+	TLS               *ServerTLS                 `json:"tls" yaml:"tls"`             // `addr` of tls is ignored
+}
+
+// GetListeners returns the listeners with the inherited values filled.
+// Without `listeners`, the legacy `addr` (and `tls.addr`) are used and
+// both of them serve the local APIs.
+func (r *Server) GetListeners() []*ServerListener {
+	listeners := r.Listeners
+	if len(listeners) == 0 {
+		listeners = []*ServerListener{{Name: "default", Addr: r.Addr, LocalAPI: true}}
+		if r.TLS != nil && r.TLS.Enabled {
+			listeners = append(listeners, &ServerListener{Name: "tls", Addr: r.TLS.Addr, LocalAPI: true, TLS: r.TLS})
+		}
+	}
+
+	filled := make([]*ServerListener, 0, len(listeners))
+	for i, l := range listeners {
+		c := *l
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("listener-%d", i)
+		}
+		if c.ReadTimeout == 0 {
+			c.ReadTimeout = r.ReadTimeout
+		}
+		if c.WriteTimeout == 0 {
+			c.WriteTimeout = r.WriteTimeout
+		}
+		if c.IdleTimeout == 0 {
+			c.IdleTimeout = r.IdleTimeout
+		}
+		if c.ReadHeaderTimeout == 0 {
+			c.ReadHeaderTimeout = r.ReadHeaderTimeout
+		}
+		if c.MaxHeaderBytes == 0 {
+			c.MaxHeaderBytes = r.MaxHeaderBytes
+		}
+		if c.Middleware == nil {
+			c.Middleware = r.Middleware
+		}
+		filled = append(filled, &c)
+	}
+	return filled
+}
+
+// ServerAdmin protects the internal routes & plugin APIs, with `addr` set they are
+// only served by a dedicated admin listener and `local_api` of the others is ignored.
+type ServerAdmin struct {
+	Addr       string     `json:"addr" yaml:"addr"`               // tcp address or unix socket `*.sock`
+	Token      string     `json:"token" yaml:"token"`             // `Authorization: Bearer <token>`
+	AllowCIDRs []string   `json:"allow_cidrs" yaml:"allow_cidrs"` // source IP / CIDR allowlist, IPv4 and IPv6
+	TLS        *ServerTLS `json:"tls" yaml:"tls"`                 // verified client certificates (`tls.client_ca`) are authenticated
+}
+
+// GetAdminListener returns the dedicated admin listener, nil if `admin.addr` is not set.
+func (r *Server) GetAdminListener() *ServerListener {
+	if r.Admin == nil || r.Admin.Addr == "" {
+		return nil
+	}
+
+	return &ServerListener{
+		Name:              "admin",
+		Addr:              r.Admin.Addr,
+		ReadTimeout:       r.ReadTimeout,
+		WriteTimeout:      r.WriteTimeout,
+		IdleTimeout:       r.IdleTimeout,
+		ReadHeaderTimeout: r.ReadHeaderTimeout,
+		MaxHeaderBytes:    r.MaxHeaderBytes,
+		LocalAPI:          true,
+		TLS:               r.Admin.TLS,
+	}
+}
+
+type ServerTLS struct {
+	Enabled      bool   `json:"enabled" yaml:"enabled"`
+	Addr         string `json:"addr" yaml:"addr"`                   // https listen address, e.g. `:443`
+	CertDir      string `json:"cert_dir" yaml:"cert_dir"`           // <name>.crt + <name>.key, selected by SNI
+	DefaultCert  string `json:"default_cert" yaml:"default_cert"`   // <name> used when SNI not matched
+	MinVersion   string `json:"min_version" yaml:"min_version"`     // 1.2, 1.3; default 1.2
+	OCSPStapling bool   `json:"ocsp_stapling" yaml:"ocsp_stapling"` // staple <name>.ocsp
+	ClientCA     string `json:"client_ca" yaml:"client_ca"`         // PEM bundle, client certificates are verified if given (mTLS)
 }
 
 type ServerPProf struct {
@@ -52,6 +150,8 @@ type ServerPProf struct {
 type ServerAccessLog struct {
 	Enabled bool   `json:"enabled" yaml:"enabled"`
 	Path    string `json:"path" yaml:"path"`
+	Format  string `json:"format" yaml:"format"`   // nginx-style variables, e.g. `$remote_addr $cache_status`
+	Encoder string `json:"encoder" yaml:"encoder"` // text, json
 	Encrypt struct {
 		Enabled bool   `json:"enabled" yaml:"enabled"`
 		Secret  string `json:"secret" yaml:"secret"`
@@ -67,6 +167,30 @@ type Upstream struct {
 	InsecureSkipVerify  bool           `json:"insecure_skip_verify" yaml:"insecure_skip_verify"`
 	ResolveAddresses    bool           `json:"resolve_addresses" yaml:"resolve_addresses"`
 	Features            map[string]any `json:"features" yaml:"features"`
+	HealthCheck         *HealthCheck   `json:"health_check" yaml:"health_check"`
+}
+
+type HealthCheck struct {
+	Passive PassiveHealthCheck `json:"passive" yaml:"passive"` // 被动检查, 根据回源结果摘除节点
+	Active  ActiveHealthCheck  `json:"active" yaml:"active"`   // 主动探测
+}
+
+type PassiveHealthCheck struct {
+	Enabled    bool          `json:"enabled" yaml:"enabled"`
+	MaxFails   int           `json:"max_fails" yaml:"max_fails"`     // 窗口内连续失败(超时/5xx)次数达到 N 则摘除
+	FailWindow time.Duration `json:"fail_window" yaml:"fail_window"` // 失败计数窗口
+	CoolDown   time.Duration `json:"cool_down" yaml:"cool_down"`     // 摘除时长, 到期后自动恢复
+}
+
+type ActiveHealthCheck struct {
+	Enabled            bool          `json:"enabled" yaml:"enabled"`
+	Path               string        `json:"path" yaml:"path"`                               // 探测路径
+	Host               string        `json:"host" yaml:"host"`                               // 探测请求 Host 头, 默认为节点地址
+	Interval           time.Duration `json:"interval" yaml:"interval"`                       // 探测周期
+	Timeout            time.Duration `json:"timeout" yaml:"timeout"`                         // 探测超时
+	ExpectStatus       []int         `json:"expect_status" yaml:"expect_status"`             // 期望状态码, 默认 2xx/3xx
+	HealthyThreshold   int           `json:"healthy_threshold" yaml:"healthy_threshold"`     // 连续成功 N 次恢复
+	UnhealthyThreshold int           `json:"unhealthy_threshold" yaml:"unhealthy_threshold"` // 连续失败 N 次摘除
 }
 
 type Storage struct {
@@ -77,6 +201,7 @@ type Storage struct {
 	EvictionPolicy  string     `json:"eviction_policy" yaml:"eviction_policy"`
 	SelectionPolicy string     `json:"selection_policy" yaml:"selection_policy"`
 	SliceSize       uint64     `json:"slice_size" yaml:"slice_size"`
+	TagHeader       string     `json:"tag_header" yaml:"tag_header"` // 缓存标签响应头, e.g. Surrogate-Key / Cache-Tag, 为空则不建立标签索引
 	DirAware        *DirAware  `json:"diraware" yaml:"diraware"`
 	Migration       *Migration `json:"migration" yaml:"migration"`
 	Buckets         []*Bucket  `json:"buckets" yaml:"buckets"`
@@ -93,15 +218,20 @@ func (r *Storage) FillDefault() {
 }
 
 type Bucket struct {
-	Path           string         `json:"path" yaml:"path"`                         // local path or ?
-	Driver         string         `json:"driver" yaml:"driver"`                     // native, custom-driver
-	Type           string         `json:"type" yaml:"type"`                         // normal, cold, hot, fastmemory
-	DBType         string         `json:"db_type" yaml:"db_type"`                   // boltdb, badgerdb, pebble
-	DBPath         string         `json:"db_path" yaml:"db_path"`                   // db path, defult: <bucket_path>/.indexdb
-	AsyncLoad      bool           `json:"async_load" yaml:"async_load"`             // load metadata async
-	SliceSize      uint64         `json:"slice_size" yaml:"slice_size"`             // slice size for each part
-	MaxObjectLimit int            `json:"max_object_limit" yaml:"max_object_limit"` // max object limit, upper Bound discard
-	DBConfig       map[string]any `json:"db_config" yaml:"db_config"`               // custom db config
+	Path            string         `json:"path" yaml:"path"`                           // local path or ?
+	Driver          string         `json:"driver" yaml:"driver"`                       // native, custom-driver
+	Type            string         `json:"type" yaml:"type"`                           // normal, cold, hot, fastmemory
+	Weight          int            `json:"weight" yaml:"weight"`                       // selection weight, default 100
+	DBType          string         `json:"db_type" yaml:"db_type"`                     // boltdb, badgerdb, pebble
+	DBPath          string         `json:"db_path" yaml:"db_path"`                     // db path, defult: <bucket_path>/.indexdb
+	AsyncLoad       bool           `json:"async_load" yaml:"async_load"`               // load metadata async
+	SliceSize       uint64         `json:"slice_size" yaml:"slice_size"`               // slice size for each part
+	MaxObjectLimit  int            `json:"max_object_limit" yaml:"max_object_limit"`   // max object limit, upper Bound discard
+	MaxCacheSize    uint64         `json:"max_cache_size" yaml:"max_cache_size"`       // max bytes of cached objects, upper Bound discard
+	EvictionPolicy  string         `json:"eviction_policy" yaml:"eviction_policy"`     // fifo, lru, lfu, size. default: storage.eviction_policy
+	DBConfig        map[string]any `json:"db_config" yaml:"db_config"`                 // custom db config
+	MaxUsagePercent int            `json:"max_usage_percent" yaml:"max_usage_percent"` // disk space/inode usage upper bound, default 95
+	MaxIOErrors     int            `json:"max_io_errors" yaml:"max_io_errors"`         // I/O errors within 1m mark bucket bad, default 10
 }
 
 type DirAware struct {
@@ -0,0 +1,65 @@
+package conf_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	middlewarev1 "github.com/omalloc/tavern/api/defined/v1/middleware"
+	"github.com/omalloc/tavern/conf"
+)
+
+func TestGetListeners(t *testing.T) {
+	chain := []*middlewarev1.Middleware{{Name: "recovery"}, {Name: "caching"}}
+
+	// legacy addr & tls
+	s := &conf.Server{
+		Addr:        ":8080",
+		ReadTimeout: time.Minute,
+		Middleware:  chain,
+		TLS:         &conf.ServerTLS{Enabled: true, Addr: ":8443", CertDir: "/etc/tavern/certs"},
+	}
+	listeners := s.GetListeners()
+	assert.Len(t, listeners, 2)
+	assert.Equal(t, ":8080", listeners[0].Addr)
+	assert.True(t, listeners[0].LocalAPI)
+	assert.Nil(t, listeners[0].TLS)
+	assert.Equal(t, ":8443", listeners[1].Addr)
+	assert.Equal(t, s.TLS, listeners[1].TLS)
+	assert.Equal(t, chain, listeners[1].Middleware)
+
+	// listeners inherit zero values
+	s.Listeners = []*conf.ServerListener{
+		{Name: "public", Addr: ":80"},
+		{Addr: "/run/tavern.sock", ReadTimeout: time.Second, Middleware: chain[:1], LocalAPI: true},
+	}
+	listeners = s.GetListeners()
+	assert.Len(t, listeners, 2)
+	assert.Equal(t, "public", listeners[0].Name)
+	assert.Equal(t, time.Minute, listeners[0].ReadTimeout)
+	assert.Equal(t, chain, listeners[0].Middleware)
+	assert.False(t, listeners[0].LocalAPI)
+	assert.Equal(t, "listener-1", listeners[1].Name)
+	assert.Equal(t, time.Second, listeners[1].ReadTimeout)
+	assert.Len(t, listeners[1].Middleware, 1)
+
+	// the config is not modified
+	assert.Equal(t, "", s.Listeners[1].Name)
+}
+
+func TestGetAdminListener(t *testing.T) {
+	s := &conf.Server{Addr: ":8080", ReadTimeout: time.Minute}
+	assert.Nil(t, s.GetAdminListener())
+
+	s.Admin = &conf.ServerAdmin{Token: "secret"}
+	assert.Nil(t, s.GetAdminListener())
+
+	s.Admin.Addr = "127.0.0.1:9090"
+	l := s.GetAdminListener()
+	assert.Equal(t, "admin", l.Name)
+	assert.Equal(t, "127.0.0.1:9090", l.Addr)
+	assert.Equal(t, time.Minute, l.ReadTimeout)
+	assert.True(t, l.LocalAPI)
+	assert.Empty(t, l.Middleware)
+}
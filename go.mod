@@ -16,6 +16,7 @@ require (
 	github.com/goccy/go-json v0.10.5
 	github.com/google/uuid v1.6.0
 	github.com/kelindar/bitmap v1.5.3
+	github.com/klauspost/compress v1.18.4
 	github.com/maniartech/signals v1.3.1
 	github.com/nutsdb/nutsdb v1.1.0
 	github.com/omalloc/proxy v0.0.0-20251201151440-9054f8002a97
@@ -54,7 +55,6 @@ require (
 	github.com/gogo/protobuf v1.3.2 // indirect
 	github.com/golang/snappy v1.0.0 // indirect
 	github.com/kelindar/simd v1.1.2 // indirect
-	github.com/klauspost/compress v1.18.4 // indirect
 	github.com/klauspost/cpuid/v2 v2.2.4 // indirect
 	github.com/kr/pretty v0.3.1 // indirect
 	github.com/kr/text v0.2.0 // indirect
@@ -5,7 +5,6 @@ import (
 	"flag"
 	"fmt"
 	stdlog "log"
-	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -15,10 +14,10 @@ import (
 
 	"github.com/cloudflare/tableflip"
 	"github.com/omalloc/proxy/selector"
-	"github.com/omalloc/proxy/selector/once"
 	"gopkg.in/natefinch/lumberjack.v2"
 
 	pluginv1 "github.com/omalloc/tavern/api/defined/v1/plugin"
+	storagev1 "github.com/omalloc/tavern/api/defined/v1/storage"
 	"github.com/omalloc/tavern/conf"
 	"github.com/omalloc/tavern/contrib/config"
 	"github.com/omalloc/tavern/contrib/config/provider/file"
@@ -34,6 +33,7 @@ import (
 	_ "github.com/omalloc/tavern/plugin/qs"
 	_ "github.com/omalloc/tavern/plugin/verifier"
 	"github.com/omalloc/tavern/proxy"
+	"github.com/omalloc/tavern/proxy/balancer"
 	"github.com/omalloc/tavern/server"
 	"github.com/omalloc/tavern/storage"
 )
@@ -93,6 +93,8 @@ func main() {
 
 func newApp(bc *conf.Bootstrap, logger log.Logger) (*kratos.App, error) {
 	stopTimeout := 120 * time.Second
+	// max wait for in-flight requests release storage on SIGUSR2
+	handoffTimeout := 30 * time.Second
 
 	// graceful upgrade
 	flip, err := tableflip.New(tableflip.Options{
@@ -106,13 +108,15 @@ func newApp(bc *conf.Bootstrap, logger log.Logger) (*kratos.App, error) {
 	// graceful upgrade if we have not parent process
 	// remove unix socket file.
 	if !flip.HasParent() {
-		if strings.HasSuffix(bc.Server.Addr, ".sock") {
-			_ = os.Remove(bc.Server.Addr) // remove unix socket
+		for _, l := range bc.Server.GetListeners() {
+			if strings.HasSuffix(l.Addr, ".sock") {
+				_ = os.Remove(l.Addr) // remove unix socket
+			}
 		}
 	}
 
 	// init storage
-	store, err := storage.New(bc.Storage, logger)
+	store, err := openStorage(flip.HasParent(), bc.Storage, logger, stopTimeout)
 	if err != nil {
 		log.Fatalf("failed to initialize storage: %v", err)
 	}
@@ -121,18 +125,44 @@ func newApp(bc *conf.Bootstrap, logger log.Logger) (*kratos.App, error) {
 	// init upstream
 	nodes := make([]selector.Node, 0, len(bc.Upstream.Address))
 	for _, addr := range bc.Upstream.Address {
-		u, err := url.Parse(addr)
+		node, err := proxy.ParseNode(addr)
 		if err != nil {
 			log.Errorf("parsed upstream.address failed %v", err)
 			continue
 		}
-		log.Infof("add upstream scheme: %s, host: %s", u.Scheme, u.Host)
-		nodes = append(nodes, selector.NewNode(u.Scheme, u.Host, selector.RawMetadata("weight", "1")))
+		log.Infof("add upstream scheme: %s, host: %s, weight: %d", node.Scheme(), node.Address(), *node.InitialWeight())
+		nodes = append(nodes, node)
+	}
+	upstreamSelector, err := balancer.New(bc.Upstream.Balancing)
+	if err != nil {
+		log.Fatalf("failed to initialize upstream balancing: %v", err)
 	}
-	proxy.SetDefault(proxy.New(
-		proxy.WithSelector(once.New()),
+	proxyOpts := []proxy.Option{
+		proxy.WithSelector(upstreamSelector),
 		proxy.WithInitialNodes(nodes),
-	))
+	}
+	if hc := bc.Upstream.HealthCheck; hc != nil {
+		if hc.Passive.Enabled {
+			proxyOpts = append(proxyOpts, proxy.WithPassiveCheck(proxy.PassiveCheck{
+				MaxFails:   hc.Passive.MaxFails,
+				FailWindow: hc.Passive.FailWindow,
+				CoolDown:   hc.Passive.CoolDown,
+			}))
+		}
+		if hc.Active.Enabled {
+			proxyOpts = append(proxyOpts, proxy.WithActiveCheck(proxy.ActiveCheck{
+				Path:               hc.Active.Path,
+				Host:               hc.Active.Host,
+				Interval:           hc.Active.Interval,
+				Timeout:            hc.Active.Timeout,
+				ExpectStatus:       hc.Active.ExpectStatus,
+				HealthyThreshold:   hc.Active.HealthyThreshold,
+				UnhealthyThreshold: hc.Active.UnhealthyThreshold,
+			}))
+		}
+	}
+	upstream := proxy.New(proxyOpts...)
+	proxy.SetDefault(upstream)
 
 	// load plugin
 	plugins := loadPlugin(log.GetLogger(), bc)
@@ -169,6 +199,7 @@ func newApp(bc *conf.Bootstrap, logger log.Logger) (*kratos.App, error) {
 			return nil
 		}),
 		kratos.AfterStop(func(ctx context.Context) error {
+			_ = upstream.Close()
 			log.Infof("tavern stopped with pid %d", os.Getpid())
 			return nil
 		}),
@@ -182,13 +213,24 @@ func newApp(bc *conf.Bootstrap, logger log.Logger) (*kratos.App, error) {
 			now := time.Now()
 			log.Info("tavern sig event SIGUSR2")
 
-			// close all db
-			if err := store.Close(); err != nil {
-				log.Errorf("failed to close storage: %s", err)
+			// BYPASS caching, drain in-flight requests and release indexdb for the new process.
+			ctx, cancel := context.WithTimeout(context.Background(), handoffTimeout)
+			if err := storage.Handoff(ctx); err != nil {
+				log.Errorf("failed to handoff storage: %s", err)
 			}
+			cancel()
 
 			if err := flip.Upgrade(); err != nil {
 				log.Errorf("failed to grace upgrade: %s", err)
+
+				// take back the storage and keep serving from cache.
+				reopened, err := storage.New(bc.Storage, logger)
+				if err != nil {
+					log.Errorf("failed to reopen storage, keep BYPASS caching: %s", err)
+					continue
+				}
+				storage.Resume(reopened)
+				continue
 			}
 
 			log.Infof("tavern upgrade success. cost %s", time.Since(now))
@@ -207,6 +249,21 @@ func newApp(bc *conf.Bootstrap, logger log.Logger) (*kratos.App, error) {
 	return app, nil
 }
 
+// openStorage opens the storage, the child process of hot upgrade retries
+// until the parent process releases the pebble LOCK of indexdb.
+func openStorage(hasParent bool, c *conf.Storage, logger log.Logger, timeout time.Duration) (storagev1.Storage, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		store, err := storage.New(c, logger)
+		if err == nil || !hasParent || time.Now().After(deadline) {
+			return store, err
+		}
+
+		log.Warnf("waiting for parent process to release storage: %s", err)
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
 func newLogger(cl *conf.Logger) log.Logger {
 	w := log.NewStdLogger(stdlog.Writer())
 
@@ -0,0 +1,226 @@
+// Package cachekey builds the cache key of a request from the per-host/per-path rules.
+package cachekey
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Query modes of Rule.
+const (
+	QueryAll     = "all"     // keep the whole query string
+	QueryNone    = "none"    // drop the query string
+	QueryInclude = "include" // keep the params of `query_params` only
+	QueryExclude = "exclude" // drop the params of `query_params`
+)
+
+// Rule is a cache key template, the first rule matching the host and path wins.
+type Rule struct {
+	Hosts         []string `json:"hosts" yaml:"hosts"`                   // `www.example.com`, `*.example.com`; empty matches all
+	PathPrefix    string   `json:"path_prefix" yaml:"path_prefix"`       // empty matches all
+	Query         string   `json:"query" yaml:"query"`                   // all, none, include, exclude; default all
+	QueryParams   []string `json:"query_params" yaml:"query_params"`     // param names of include / exclude, glob e.g. `utm_*`
+	SortQuery     bool     `json:"sort_query" yaml:"sort_query"`         // sort the params by name
+	Headers       []string `json:"headers" yaml:"headers"`               // request headers appended to the key
+	Cookies       []string `json:"cookies" yaml:"cookies"`               // cookies appended to the key
+	LowercasePath bool     `json:"lowercase_path" yaml:"lowercase_path"` // lowercase the path
+	StripPrefix   string   `json:"strip_prefix" yaml:"strip_prefix"`     // path prefix removed from the key
+}
+
+func (r *Rule) match(host, urlPath string) bool {
+	if r.PathPrefix != "" && !strings.HasPrefix(urlPath, r.PathPrefix) {
+		return false
+	}
+
+	return MatchHost(r.Hosts, host)
+}
+
+// MatchHost reports whether host (without port) matches one of the patterns,
+// `www.example.com` or the wildcard `*.example.com`; empty patterns match all.
+func MatchHost(patterns []string, host string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, h := range patterns {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+		// wildcard `*.example.com` does not match `example.com`
+		if suffix, ok := strings.CutPrefix(h, "*"); ok && len(host) > len(suffix) &&
+			strings.HasSuffix(strings.ToLower(host), strings.ToLower(suffix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// keepParam reports whether the query param of name is a part of the key.
+func (r *Rule) keepParam(name string) bool {
+	matched := slices.ContainsFunc(r.QueryParams, func(pattern string) bool {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	})
+
+	if r.Query == QueryInclude {
+		return matched
+	}
+	return !matched
+}
+
+// Builder builds the cache key, the requests matching no rule use
+// the legacy `include_query_in_cache_key` behavior.
+type Builder struct {
+	rules        []Rule
+	includeQuery bool
+}
+
+// New validates the rules and returns the Builder.
+func New(rules []Rule, includeQuery bool) (*Builder, error) {
+	b := &Builder{
+		rules:        make([]Rule, 0, len(rules)),
+		includeQuery: includeQuery,
+	}
+
+	for i, r := range rules {
+		switch r.Query {
+		case "":
+			r.Query = QueryAll
+		case QueryAll, QueryNone, QueryInclude, QueryExclude:
+		default:
+			return nil, fmt.Errorf("cache_key[%d]: unknown query mode %q", i, r.Query)
+		}
+
+		for _, pattern := range r.QueryParams {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("cache_key[%d]: invalid query param pattern %q: %w", i, pattern, err)
+			}
+		}
+
+		r.Headers = slices.Clone(r.Headers)
+		for j, h := range r.Headers {
+			r.Headers[j] = http.CanonicalHeaderKey(h)
+		}
+		b.rules = append(b.rules, r)
+	}
+	return b, nil
+}
+
+// Key returns the cache key of req.
+//
+// e.g. `http://www.example.com/a.js?v=1#accept-encoding=gzip&lang=en`, the request
+// headers and cookies follow the `#` which never appears in the request URL.
+func (b *Builder) Key(req *http.Request) string {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	rule := b.match(host, req.URL.Path)
+	if rule == nil {
+		if b.includeQuery {
+			return req.URL.String()
+		}
+		return fmt.Sprintf("%s://%s%s", req.URL.Scheme, req.Host, req.URL.Path)
+	}
+
+	urlPath := req.URL.Path
+	if rule.StripPrefix != "" {
+		urlPath = strings.TrimPrefix(urlPath, rule.StripPrefix)
+		if !strings.HasPrefix(urlPath, "/") {
+			urlPath = "/" + urlPath
+		}
+	}
+	if rule.LowercasePath {
+		urlPath = strings.ToLower(urlPath)
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString(req.URL.Scheme)
+	sb.WriteString("://")
+	sb.WriteString(req.Host)
+	sb.WriteString(urlPath)
+
+	if query := buildQuery(rule, req.URL.RawQuery); query != "" {
+		sb.WriteByte('?')
+		sb.WriteString(query)
+	}
+
+	if extra := buildExtra(rule, req); extra != "" {
+		sb.WriteByte('#')
+		sb.WriteString(extra)
+	}
+	return sb.String()
+}
+
+func (b *Builder) match(host, urlPath string) *Rule {
+	for i := range b.rules {
+		if b.rules[i].match(host, urlPath) {
+			return &b.rules[i]
+		}
+	}
+	return nil
+}
+
+// buildQuery filters the params of raw query, the encoding of kept params is untouched.
+func buildQuery(rule *Rule, rawQuery string) string {
+	if rawQuery == "" || rule.Query == QueryNone {
+		return ""
+	}
+	if rule.Query == QueryAll && !rule.SortQuery {
+		return rawQuery
+	}
+
+	params := strings.Split(rawQuery, "&")
+	kept := params[:0]
+	for _, param := range params {
+		if param == "" {
+			continue
+		}
+
+		if rule.Query != QueryAll {
+			name, _, _ := strings.Cut(param, "=")
+			if unescaped, err := url.QueryUnescape(name); err == nil {
+				name = unescaped
+			}
+			if !rule.keepParam(name) {
+				continue
+			}
+		}
+		kept = append(kept, param)
+	}
+
+	if rule.SortQuery {
+		slices.SortStableFunc(kept, func(a, b string) int {
+			an, _, _ := strings.Cut(a, "=")
+			bn, _, _ := strings.Cut(b, "=")
+			return strings.Compare(an, bn)
+		})
+	}
+	return strings.Join(kept, "&")
+}
+
+// buildExtra joins the configured request headers and cookies present in req.
+func buildExtra(rule *Rule, req *http.Request) string {
+	if len(rule.Headers) == 0 && len(rule.Cookies) == 0 {
+		return ""
+	}
+
+	values := url.Values{}
+	for _, name := range rule.Headers {
+		if v := req.Header.Values(name); len(v) > 0 {
+			values.Set(strings.ToLower(name), strings.Join(v, ","))
+		}
+	}
+	for _, name := range rule.Cookies {
+		if c, err := req.Cookie(name); err == nil {
+			values.Set("cookie."+name, c.Value)
+		}
+	}
+	// sorted by key
+	return values.Encode()
+}
@@ -0,0 +1,117 @@
+package cachekey_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/tavern/pkg/cachekey"
+)
+
+func newRequest(rawURL string) *http.Request {
+	return httptest.NewRequest(http.MethodGet, rawURL, nil)
+}
+
+func TestLegacy(t *testing.T) {
+	req := newRequest("http://www.example.com/a.js?v=1&utm_source=x")
+
+	b, err := cachekey.New(nil, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://www.example.com/a.js?v=1&utm_source=x", b.Key(req))
+
+	b, err = cachekey.New(nil, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://www.example.com/a.js", b.Key(req))
+}
+
+func TestQuery(t *testing.T) {
+	b, err := cachekey.New([]cachekey.Rule{
+		{Hosts: []string{"img.example.com"}, Query: cachekey.QueryInclude, QueryParams: []string{"w", "h"}, SortQuery: true},
+		{PathPrefix: "/api/", Query: cachekey.QueryNone},
+		{Query: cachekey.QueryExclude, QueryParams: []string{"utm_*", "spm"}},
+	}, true)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://img.example.com/a.png?h=20&w=10&q=80", "http://img.example.com/a.png?h=20&w=10"},
+		{"http://img.example.com/a.png?w=10&h=20", "http://img.example.com/a.png?h=20&w=10"},
+		{"http://img.example.com/a.png?q=80", "http://img.example.com/a.png"},
+		{"http://www.example.com/api/list?page=1", "http://www.example.com/api/list"},
+		{"http://www.example.com/a.js?v=1&utm_source=x&utm_medium=y&spm=z", "http://www.example.com/a.js?v=1"},
+		{"http://www.example.com/a.js?utm%5Fsource=x&v=%2F1", "http://www.example.com/a.js?v=%2F1"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, b.Key(newRequest(tt.url)), tt.url)
+	}
+
+	_, err = cachekey.New([]cachekey.Rule{{Query: "whitelist"}}, true)
+	assert.Error(t, err)
+	_, err = cachekey.New([]cachekey.Rule{{Query: cachekey.QueryExclude, QueryParams: []string{"[utm"}}}, true)
+	assert.Error(t, err)
+}
+
+func TestHostAndPath(t *testing.T) {
+	b, err := cachekey.New([]cachekey.Rule{
+		{Hosts: []string{"*.example.com"}, PathPrefix: "/v2/", StripPrefix: "/v2", LowercasePath: true, Query: cachekey.QueryNone},
+	}, true)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "http://cdn.example.com/static/a.js", b.Key(newRequest("http://cdn.example.com/v2/Static/A.js?v=1")))
+	assert.Equal(t, "http://cdn.example.com:8080/static/a.js", b.Key(newRequest("http://cdn.example.com:8080/v2/Static/A.js")))
+
+	// not matched, legacy key
+	assert.Equal(t, "http://example.com/v2/Static/A.js?v=1", b.Key(newRequest("http://example.com/v2/Static/A.js?v=1")))
+	assert.Equal(t, "http://cdn.example.com/v1/A.js?v=1", b.Key(newRequest("http://cdn.example.com/v1/A.js?v=1")))
+}
+
+func TestHeadersAndCookies(t *testing.T) {
+	rules := []cachekey.Rule{{Headers: []string{"x-device", "Accept-Language"}, Cookies: []string{"lang"}}}
+	b, err := cachekey.New(rules, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "x-device", rules[0].Headers[0])
+
+	req := newRequest("http://www.example.com/index.html?v=1")
+	assert.Equal(t, "http://www.example.com/index.html?v=1", b.Key(req))
+
+	req.Header.Set("X-Device", "mobile")
+	req.AddCookie(&http.Cookie{Name: "lang", Value: "en"})
+	req.AddCookie(&http.Cookie{Name: "session", Value: "secret"})
+	assert.Equal(t, "http://www.example.com/index.html?v=1#cookie.lang=en&x-device=mobile", b.Key(req))
+}
+
+func TestMatchHost(t *testing.T) {
+	assert.True(t, cachekey.MatchHost(nil, "www.example.com"))
+	assert.True(t, cachekey.MatchHost([]string{"WWW.example.com"}, "www.example.com"))
+	assert.True(t, cachekey.MatchHost([]string{"*.example.com"}, "img.Example.com"))
+	assert.False(t, cachekey.MatchHost([]string{"*.example.com"}, "example.com"))
+	assert.False(t, cachekey.MatchHost([]string{"www.example.com"}, "img.example.com"))
+}
+
+func TestKeys(t *testing.T) {
+	const rawURL = "http://www.example.com/a.js?v=1&utm_source=x"
+
+	// no caching middleware registered
+	assert.Equal(t, []string{rawURL}, cachekey.Keys(rawURL))
+
+	b1, err := cachekey.New(nil, false)
+	assert.NoError(t, err)
+	b2, err := cachekey.New([]cachekey.Rule{{Query: cachekey.QueryExclude, QueryParams: []string{"utm_*"}}}, true)
+	assert.NoError(t, err)
+
+	unregister1 := cachekey.Register("public", b1)
+	unregister2 := cachekey.Register("internal", b2)
+	defer unregister2()
+
+	assert.Equal(t, []string{"http://www.example.com/a.js", "http://www.example.com/a.js?v=1"}, cachekey.Keys(rawURL))
+
+	unregister1()
+	assert.Equal(t, []string{"http://www.example.com/a.js?v=1"}, cachekey.Keys(rawURL))
+
+	// not a URL
+	assert.Equal(t, []string{"/a.js"}, cachekey.Keys("/a.js"))
+}
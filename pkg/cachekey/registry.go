@@ -0,0 +1,45 @@
+package cachekey
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// registry holds the Builder of each caching middleware keyed by listener, the purge
+// and query of a storeUrl have no request context to pick the listener.
+var registry sync.Map // map[string]*Builder
+
+// Register registers the Builder of the caching middleware on listener,
+// the returned func unregisters it.
+func Register(listener string, b *Builder) func() {
+	registry.Store(listener, b)
+	return func() {
+		registry.CompareAndDelete(listener, b)
+	}
+}
+
+// Keys returns the distinct cache keys of rawURL built by the registered builders,
+// rawURL itself is the key when no builder is registered or it is not a valid URL.
+//
+// the rules of `headers` / `cookies` see no request headers here.
+func Keys(rawURL string) []string {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil || req.URL.Host == "" {
+		return []string{rawURL}
+	}
+
+	keys := make([]string, 0, 1)
+	registry.Range(func(_, v any) bool {
+		if key := v.(*Builder).Key(req); !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+
+	if len(keys) == 0 {
+		return []string{rawURL}
+	}
+	slices.Sort(keys)
+	return keys
+}
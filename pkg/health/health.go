@@ -0,0 +1,104 @@
+// Package health is a registry shared by subsystems to report
+// whether the node is ready to receive traffic.
+//
+// A subsystem (recovery middleware, storage buckets ...) marks itself
+// unhealthy with a reason, the readiness probe fails until every
+// component recovered, so the load balancer drains the node.
+package health
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status is the unhealthy state of a component.
+type Status struct {
+	Component string    `json:"component"`
+	Reason    string    `json:"reason"`
+	Since     time.Time `json:"since"`
+	Until     time.Time `json:"until,omitzero"` // zero means until MarkHealthy
+}
+
+func (s *Status) expired(now time.Time) bool {
+	return !s.Until.IsZero() && !now.Before(s.Until)
+}
+
+type Registry struct {
+	mu    sync.Mutex
+	items map[string]*Status
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		items: make(map[string]*Status),
+	}
+}
+
+// MarkUnhealthy marks the component unhealthy, it recovers automatically after ttl.
+// ttl <= 0 keeps it unhealthy until MarkHealthy is called.
+// Marking an unhealthy component again refreshes reason and ttl.
+func (r *Registry) MarkUnhealthy(component, reason string, ttl time.Duration) {
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	st, ok := r.items[component]
+	if !ok || st.expired(now) {
+		st = &Status{Component: component, Since: now}
+		r.items[component] = st
+	}
+	st.Reason = reason
+	st.Until = time.Time{}
+	if ttl > 0 {
+		st.Until = now.Add(ttl)
+	}
+}
+
+// MarkHealthy clears the unhealthy state of component.
+func (r *Registry) MarkHealthy(component string) {
+	r.mu.Lock()
+	delete(r.items, component)
+	r.mu.Unlock()
+}
+
+// Ready reports whether all components are healthy,
+// and the unhealthy components sorted by name.
+func (r *Registry) Ready() (bool, []Status) {
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	unhealthy := make([]Status, 0, len(r.items))
+	for name, st := range r.items {
+		if st.expired(now) {
+			delete(r.items, name)
+			continue
+		}
+		unhealthy = append(unhealthy, *st)
+	}
+
+	sort.Slice(unhealthy, func(i, j int) bool {
+		return unhealthy[i].Component < unhealthy[j].Component
+	})
+	return len(unhealthy) == 0, unhealthy
+}
+
+var defaultRegistry = NewRegistry()
+
+// MarkUnhealthy marks the component unhealthy in the default registry.
+func MarkUnhealthy(component, reason string, ttl time.Duration) {
+	defaultRegistry.MarkUnhealthy(component, reason, ttl)
+}
+
+// MarkHealthy clears the component in the default registry.
+func MarkHealthy(component string) {
+	defaultRegistry.MarkHealthy(component)
+}
+
+// Ready reports the readiness of the default registry.
+func Ready() (bool, []Status) {
+	return defaultRegistry.Ready()
+}
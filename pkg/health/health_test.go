@@ -0,0 +1,55 @@
+package health_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/tavern/pkg/health"
+)
+
+func TestRegistry(t *testing.T) {
+	r := health.NewRegistry()
+
+	ready, items := r.Ready()
+	assert.True(t, ready)
+	assert.Empty(t, items)
+
+	r.MarkUnhealthy("storage", "disk /cache1 is bad", 0)
+	r.MarkUnhealthy("recovery", "too many panics", time.Hour)
+
+	ready, items = r.Ready()
+	assert.False(t, ready)
+	assert.Len(t, items, 2)
+	assert.Equal(t, "recovery", items[0].Component)
+	assert.Equal(t, "storage", items[1].Component)
+	assert.True(t, items[1].Until.IsZero())
+
+	r.MarkHealthy("storage")
+	r.MarkHealthy("recovery")
+
+	ready, _ = r.Ready()
+	assert.True(t, ready)
+}
+
+func TestRegistryExpire(t *testing.T) {
+	r := health.NewRegistry()
+
+	r.MarkUnhealthy("recovery", "panic", 50*time.Millisecond)
+	first, _ := r.Ready()
+	assert.False(t, first)
+
+	// refresh keeps Since, extends Until
+	_, items := r.Ready()
+	since := items[0].Since
+	r.MarkUnhealthy("recovery", "panic again", 50*time.Millisecond)
+	_, items = r.Ready()
+	assert.Equal(t, since, items[0].Since)
+	assert.Equal(t, "panic again", items[0].Reason)
+
+	assert.Eventually(t, func() bool {
+		ready, _ := r.Ready()
+		return ready
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,146 @@
+// Package ipallow implements the source address allowlist of IPs and CIDRs, IPv4 and IPv6.
+package ipallow
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+var errNotIP = errors.New("not an ip address")
+
+// loopback is the expansion of `localhost`.
+var loopback = []netip.Prefix{
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("::1/128"),
+}
+
+// List is an immutable allowlist, the zero value allows nothing.
+type List struct {
+	prefixes []netip.Prefix
+}
+
+// Parse parses the entries of IP (`10.0.0.1`, `::1`, `127.1`), CIDR (`10.0.0.0/8`, `fd00::/8`)
+// or `localhost`.
+func Parse(entries []string) (*List, error) {
+	l := &List{prefixes: make([]netip.Prefix, 0, len(entries))}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.EqualFold(entry, "localhost") {
+			l.prefixes = append(l.prefixes, loopback...)
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cidr %q: %w", entry, err)
+			}
+			l.prefixes = append(l.prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := parseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ip %q: %w", entry, err)
+		}
+		l.prefixes = append(l.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return l, nil
+}
+
+// Len returns the number of prefixes.
+func (l *List) Len() int {
+	return len(l.prefixes)
+}
+
+// Contains reports whether addr is allowed, IPv4-mapped IPv6 addresses match the IPv4 entries.
+func (l *List) Contains(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+
+	addr = addr.Unmap()
+	for _, prefix := range l.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowRemote reports whether the `http.Request.RemoteAddr` is allowed.
+func (l *List) AllowRemote(remoteAddr string) bool {
+	addr, ok := RemoteIP(remoteAddr)
+	return ok && l.Contains(addr)
+}
+
+// RemoteIP parses the IP of `host:port`, `[v6]:port` or a bare IP.
+// Peers of unix sockets have no IP.
+func RemoteIP(remoteAddr string) (netip.Addr, bool) {
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+
+	// drop the zone of link-local address
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+func parseAddr(s string) (netip.Addr, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr.Unmap(), nil
+	}
+
+	// shorthand IPv4 such as `127.1`
+	return parseShortIPv4(s)
+}
+
+// parseShortIPv4 parses the `a.b`, `a.b.c` forms of inet_aton, the last part fills the remaining bytes.
+func parseShortIPv4(s string) (netip.Addr, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return netip.Addr{}, errNotIP
+	}
+
+	var b [4]byte
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return netip.Addr{}, errNotIP
+		}
+
+		if i < len(parts)-1 {
+			if n > 0xff {
+				return netip.Addr{}, errNotIP
+			}
+			b[i] = byte(n)
+			continue
+		}
+
+		// the last part fills the remaining bytes
+		if n >= 1<<(8*(4-i)) {
+			return netip.Addr{}, errNotIP
+		}
+		for j := 3; j >= i; j-- {
+			b[j] = byte(n)
+			n >>= 8
+		}
+	}
+	return netip.AddrFrom4(b), nil
+}
@@ -0,0 +1,48 @@
+package ipallow_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/tavern/pkg/ipallow"
+)
+
+func TestParse(t *testing.T) {
+	l, err := ipallow.Parse([]string{"127.1", "localhost", "10.0.0.0/8", "fd00::/8", "192.168.1.10", " "})
+	assert.NoError(t, err)
+	assert.Equal(t, 6, l.Len())
+
+	for _, addr := range []string{"127.0.0.1", "127.0.0.2", "::1", "10.1.2.3", "fd00::1", "192.168.1.10", "::ffff:10.0.0.1"} {
+		assert.True(t, l.Contains(netip.MustParseAddr(addr)), addr)
+	}
+	for _, addr := range []string{"11.0.0.1", "fe80::1", "192.168.1.11"} {
+		assert.False(t, l.Contains(netip.MustParseAddr(addr)), addr)
+	}
+
+	for _, entry := range []string{"example.com", "10.0.0.0/33", "256.1", "1.2.3.4.5"} {
+		_, err = ipallow.Parse([]string{entry})
+		assert.Error(t, err, entry)
+	}
+}
+
+func TestAllowRemote(t *testing.T) {
+	l, err := ipallow.Parse([]string{"127.0.0.1", "::1", "2001:db8::/32"})
+	assert.NoError(t, err)
+
+	assert.True(t, l.AllowRemote("127.0.0.1:52100"))
+	assert.True(t, l.AllowRemote("[::1]:52100"))
+	assert.True(t, l.AllowRemote("[2001:db8::1]:80"))
+	assert.True(t, l.AllowRemote("[::ffff:127.0.0.1]:80"))
+	assert.True(t, l.AllowRemote("::1"))
+	assert.False(t, l.AllowRemote("[2001:db9::1]:80"))
+	// the zone is dropped
+	assert.False(t, l.AllowRemote("[fe80::1%eth0]:80"))
+	// unix socket peer
+	assert.False(t, l.AllowRemote("@"))
+	assert.False(t, l.AllowRemote(""))
+
+	var empty ipallow.List
+	assert.False(t, empty.AllowRemote("127.0.0.1:80"))
+}
@@ -0,0 +1,49 @@
+package logcrypto_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/tavern/pkg/logcrypto"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	c, err := logcrypto.New("123")
+	assert.NoError(t, err)
+
+	line := []byte(`127.0.0.1 www.example.com - [04/Dec/2025:05:33:28 +0000] "GET http://www.example.com/1K.bin HTTP/1.1" 200`)
+
+	enc1, err := c.Encrypt(line)
+	assert.NoError(t, err)
+	enc2, err := c.Encrypt(line)
+	assert.NoError(t, err)
+
+	assert.True(t, logcrypto.IsEncrypted(enc1))
+	assert.NotEqual(t, enc1, enc2, "nonce must be random")
+	assert.NotContains(t, string(enc1), "www.example.com")
+
+	plain, err := c.Decrypt(append(enc1, '\n'))
+	assert.NoError(t, err)
+	assert.Equal(t, line, plain)
+}
+
+func TestDecryptFailure(t *testing.T) {
+	_, err := logcrypto.New("")
+	assert.ErrorIs(t, err, logcrypto.ErrEmptySecret)
+
+	c, _ := logcrypto.New("123")
+	other, _ := logcrypto.New("456")
+
+	enc, err := c.Encrypt([]byte("hello"))
+	assert.NoError(t, err)
+
+	_, err = other.Decrypt(enc)
+	assert.Error(t, err)
+
+	_, err = c.Decrypt([]byte("127.0.0.1 www.example.com"))
+	assert.ErrorIs(t, err, logcrypto.ErrUnknownFormat)
+
+	_, err = c.Decrypt([]byte("v1:AAAA"))
+	assert.Error(t, err)
+}
@@ -22,6 +22,10 @@ type Trace struct {
 	CacheStatus       string
 	RemoteAddr        string
 	FirstResponseTime time.Time
+	UpstreamAddr      string
+	Bucket            string
+	ChunksHit         int
+	ChunksTotal       int
 }
 
 func (t *Trace) Clone() *Trace {
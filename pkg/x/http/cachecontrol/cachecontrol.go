@@ -88,6 +88,16 @@ func (c CacheControl) MaxStale() time.Duration {
 	return time.Duration(i) * time.Second
 }
 
+// StaleIfError returns the RFC 5861 stale-if-error window, -1 if not present.
+func (c CacheControl) StaleIfError() time.Duration {
+	return c.timedDirective("stale-if-error")
+}
+
+// StaleWhileRevalidate returns the RFC 5861 stale-while-revalidate window, -1 if not present.
+func (c CacheControl) StaleWhileRevalidate() time.Duration {
+	return c.timedDirective("stale-while-revalidate")
+}
+
 func (c CacheControl) timedDirective(key string) time.Duration {
 	t, ok := c[key]
 	if !ok {
@@ -0,0 +1,195 @@
+package encodingcontrol
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	Gzip     = "gzip"
+	Brotli   = "br"
+	Zstd     = "zstd"
+	Identity = "identity"
+)
+
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// Supported reports whether enc can be encoded / decoded.
+func Supported(enc string) bool {
+	switch enc {
+	case Gzip, Brotli, Zstd:
+		return true
+	}
+	return false
+}
+
+// Of returns the Content-Encoding of h, empty is `identity`.
+func Of(h http.Header) string {
+	enc := strings.ToLower(strings.TrimSpace(h.Get("Content-Encoding")))
+	if enc == "" {
+		return Identity
+	}
+	return enc
+}
+
+// parse returns the q-values of the Accept-Encoding header, e.g. `br;q=1.0, gzip;q=0.8, *;q=0.1`.
+func parse(acceptEncoding string) map[string]float64 {
+	qs := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || !strings.EqualFold(k, "q") {
+				continue
+			}
+			if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 && f <= 1 {
+				q = f
+			}
+		}
+		qs[coding] = q
+	}
+	return qs
+}
+
+// qvalue returns the q-value of coding, the unlisted `identity` is always acceptable (RFC 9110 12.5.3).
+func qvalue(qs map[string]float64, coding string) float64 {
+	if q, ok := qs[coding]; ok {
+		return q
+	}
+	if q, ok := qs["*"]; ok {
+		return q
+	}
+	if coding == Identity {
+		return 1
+	}
+	return 0
+}
+
+// Negotiate returns the coding of supported preferred by the client, supported is in the server preference
+// order which breaks the ties of q-values. `identity` is returned when no coding is acceptable.
+func Negotiate(acceptEncoding string, supported []string) string {
+	if acceptEncoding == "" {
+		return Identity
+	}
+
+	qs := parse(acceptEncoding)
+	best, bestQ := Identity, 0.0
+	for _, coding := range supported {
+		if q := qvalue(qs, coding); q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// Accepts reports whether the client accepts the content coding enc.
+func Accepts(acceptEncoding, enc string) bool {
+	if enc == Identity {
+		return acceptEncoding == "" || qvalue(parse(acceptEncoding), Identity) > 0
+	}
+	return qvalue(parse(acceptEncoding), enc) > 0
+}
+
+// NewWriter returns the encoder of enc writing to w.
+func NewWriter(enc string, w io.Writer) (io.WriteCloser, error) {
+	switch enc {
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Brotli:
+		return brotli.NewWriter(w), nil
+	case Zstd:
+		return zstd.NewWriter(w)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, enc)
+}
+
+// NewReader returns the decoder of enc reading from r.
+func NewReader(enc string, r io.Reader) (io.ReadCloser, error) {
+	switch enc {
+	case Gzip:
+		return gzip.NewReader(r)
+	case Brotli:
+		return io.NopCloser(brotli.NewReader(r)), nil
+	case Zstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, enc)
+}
+
+// Encode returns the body compressed by enc, the encoder runs in a goroutine feeding the pipe.
+func Encode(enc string, body io.ReadCloser) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	w, err := NewWriter(enc, pw)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		_, err := io.Copy(w, body)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		_ = body.Close()
+		_ = pw.CloseWithError(err)
+	}()
+
+	return &readCloser{Reader: pr, close: pr.Close}, nil
+}
+
+// Decode returns the body decompressed by enc.
+func Decode(enc string, body io.ReadCloser) (io.ReadCloser, error) {
+	r, err := NewReader(enc, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &readCloser{Reader: r, close: func() error {
+		_ = r.Close()
+		return body.Close()
+	}}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r *readCloser) Close() error {
+	return r.close()
+}
+
+// Transform rewrites the upstream response before it is saved to cache, e.g. compress the
+// `Accept-Encoding` variant, see middleware compress.
+type Transform func(req *http.Request, resp *http.Response)
+
+type transformKey struct{}
+
+// WithTransform returns the context carrying the Transform of the upstream response.
+func WithTransform(ctx context.Context, t Transform) context.Context {
+	return context.WithValue(ctx, transformKey{}, t)
+}
+
+// TransformFromContext returns the Transform carried by ctx.
+func TransformFromContext(ctx context.Context) (Transform, bool) {
+	t, ok := ctx.Value(transformKey{}).(Transform)
+	return t, ok && t != nil
+}
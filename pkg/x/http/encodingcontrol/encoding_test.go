@@ -0,0 +1,74 @@
+package encodingcontrol
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiate(t *testing.T) {
+	supported := []string{Brotli, Zstd, Gzip}
+
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"empty", "", Identity},
+		{"single", "gzip", Gzip},
+		{"server preference", "gzip, deflate, br, zstd", Brotli},
+		{"q-values", "br;q=0.5, gzip;q=0.8", Gzip},
+		{"case and spaces", " GZIP ; Q=0.9 , br;q=0", Gzip},
+		{"wildcard", "*", Brotli},
+		{"wildcard excluded", "*;q=0.5, br;q=0", Zstd},
+		{"unsupported", "deflate, compress", Identity},
+		{"identity only", "identity", Identity},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, Negotiate(tc.header, supported))
+		})
+	}
+}
+
+func TestAccepts(t *testing.T) {
+	assert.True(t, Accepts("", Identity))
+	assert.False(t, Accepts("", Gzip))
+	assert.True(t, Accepts("gzip, br", Brotli))
+	assert.False(t, Accepts("gzip, br;q=0", Brotli))
+	assert.True(t, Accepts("gzip", Identity))
+	assert.False(t, Accepts("gzip, identity;q=0", Identity))
+	assert.False(t, Accepts("gzip, *;q=0", Identity))
+	assert.True(t, Accepts("*", Zstd))
+}
+
+func TestEncodeDecode(t *testing.T) {
+	raw := strings.Repeat(`{"name":"tavern","cache":true}`, 128)
+
+	for _, enc := range []string{Gzip, Brotli, Zstd} {
+		t.Run(enc, func(t *testing.T) {
+			encoded, err := Encode(enc, io.NopCloser(strings.NewReader(raw)))
+			assert.NoError(t, err)
+			buf, err := io.ReadAll(encoded)
+			assert.NoError(t, err)
+			assert.NoError(t, encoded.Close())
+			assert.Less(t, len(buf), len(raw))
+
+			decoded, err := Decode(enc, io.NopCloser(bytes.NewReader(buf)))
+			assert.NoError(t, err)
+			buf, err = io.ReadAll(decoded)
+			assert.NoError(t, err)
+			assert.NoError(t, decoded.Close())
+			assert.Equal(t, raw, string(buf))
+		})
+	}
+
+	_, err := Encode("deflate", io.NopCloser(strings.NewReader(raw)))
+	assert.ErrorIs(t, err, ErrUnsupportedEncoding)
+	_, err = Decode("deflate", io.NopCloser(strings.NewReader(raw)))
+	assert.ErrorIs(t, err, ErrUnsupportedEncoding)
+}
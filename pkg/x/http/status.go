@@ -0,0 +1,29 @@
+package http
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ValidStatusPattern accepts the status code `404` or the class `5xx`.
+func ValidStatusPattern(status string) bool {
+	if len(status) != 3 || status[0] < '1' || status[0] > '5' {
+		return false
+	}
+	if strings.EqualFold(status[1:], "xx") {
+		return true
+	}
+	_, err := strconv.Atoi(status)
+	return err == nil
+}
+
+// MatchStatus reports whether statusCode matches any of the patterns checked by ValidStatusPattern.
+func MatchStatus(patterns []string, statusCode int) bool {
+	code := strconv.Itoa(statusCode)
+	for _, status := range patterns {
+		if status == code || (strings.EqualFold(status[1:], "xx") && status[0] == code[0]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package http_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	xhttp "github.com/omalloc/tavern/pkg/x/http"
+)
+
+func TestStatusPattern(t *testing.T) {
+	for _, status := range []string{"200", "404", "5xx", "4XX"} {
+		assert.True(t, xhttp.ValidStatusPattern(status), status)
+	}
+	for _, status := range []string{"", "20", "2000", "600", "0xx", "4x4", "abc"} {
+		assert.False(t, xhttp.ValidStatusPattern(status), status)
+	}
+
+	patterns := []string{"404", "5xx"}
+	assert.True(t, xhttp.MatchStatus(patterns, 404))
+	assert.True(t, xhttp.MatchStatus(patterns, 502))
+	assert.False(t, xhttp.MatchStatus(patterns, 403))
+	assert.False(t, xhttp.MatchStatus(nil, 200))
+}
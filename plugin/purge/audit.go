@@ -0,0 +1,68 @@
+package purge
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// auditEntry is a line of purge audit log.
+type auditEntry struct {
+	Time       string `json:"time"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	TaskID     string `json:"task_id,omitempty"`
+	Mode       string `json:"mode"`
+	Target     string `json:"target"`
+	Code       int    `json:"code"`
+	Error      string `json:"error,omitempty"`
+}
+
+// auditLog records every purge as a JSON line.
+type auditLog struct {
+	mu  sync.Mutex
+	out *lumberjack.Logger
+}
+
+func newAuditLog(path string) *auditLog {
+	if path == "" {
+		return nil
+	}
+
+	_ = os.MkdirAll(filepath.Dir(path), 0o755)
+	return &auditLog{
+		out: &lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    100,
+			MaxBackups: 10,
+			LocalTime:  true,
+		},
+	}
+}
+
+func (a *auditLog) record(entry auditEntry) {
+	if a == nil {
+		return
+	}
+
+	entry.Time = time.Now().Format(time.RFC3339)
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, _ = a.out.Write(line)
+}
+
+func (a *auditLog) close() error {
+	if a == nil {
+		return nil
+	}
+	return a.out.Close()
+}
@@ -20,7 +20,9 @@ func init() {
 
 	// docs/purge.md references these labels
 	_metricPurgeRequestsTotal.WithLabelValues("200")
+	_metricPurgeRequestsTotal.WithLabelValues("400")
 	_metricPurgeRequestsTotal.WithLabelValues("403")
 	_metricPurgeRequestsTotal.WithLabelValues("404")
 	_metricPurgeRequestsTotal.WithLabelValues("500")
+	_metricPurgeRequestsTotal.WithLabelValues("503")
 }
@@ -3,8 +3,10 @@ package purge
 import (
 	"context"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -15,26 +17,38 @@ import (
 	"github.com/omalloc/tavern/contrib/log"
 	"github.com/omalloc/tavern/internal/protocol"
 	"github.com/omalloc/tavern/pkg/encoding"
+	"github.com/omalloc/tavern/pkg/ipallow"
 	"github.com/omalloc/tavern/plugin"
 	"github.com/omalloc/tavern/storage"
+	"github.com/omalloc/tavern/storage/tagindex"
 )
 
 const Method = "PURGE"
 const PurgeKeyPrefix = "purge/"
 
+// maxTaskBodySize limits the body of batch purge request.
+const maxTaskBodySize = 4 << 20
+
 var _ configv1.Plugin = (*PurgePlugin)(nil)
 
+var errStorageHandoff = errors.New("storage handed off to the new process")
+
 type option struct {
-	Threshold  int      `json:"threshold" yaml:"threshold"`
-	AllowHosts []string `json:"allow_hosts" yaml:"allow_hosts"`
-	HeaderName string   `json:"header_name" yaml:"header_name"` // default `Purge-Type`
-	LogPath    string   `json:"log_path" yaml:"log_path"`
+	Threshold    int      `json:"threshold" yaml:"threshold"`
+	AllowHosts   []string `json:"allow_hosts" yaml:"allow_hosts"`       // IP / CIDR, IPv4 and IPv6
+	HeaderName   string   `json:"header_name" yaml:"header_name"`       // default `Purge-Type`
+	TagHeader    string   `json:"tag_header" yaml:"tag_header"`         // default `Purge-Tag`
+	LogPath      string   `json:"log_path" yaml:"log_path"`             // audit log of every purge
+	MaxQueueSize int      `json:"max_queue_size" yaml:"max_queue_size"` // batch purge queue size, default 1000
+	Workers      int      `json:"workers" yaml:"workers"`               // batch purge workers, default 4
 }
 
 type PurgePlugin struct {
 	log       *log.Helper
 	opt       *option
-	allowAddr map[string]struct{}
+	allowAddr *ipallow.List
+	queue     *taskQueue
+	audit     *auditLog
 }
 
 func init() {
@@ -42,24 +56,40 @@ func init() {
 }
 
 func (r *PurgePlugin) Start(ctx context.Context) error {
+	r.queue.start()
 	return nil
 }
 
 func (r *PurgePlugin) Stop(ctx context.Context) error {
-	return nil
+	r.queue.close()
+	return r.audit.close()
 }
 
 func (r *PurgePlugin) AddRouter(router *http.ServeMux) {
 
 	codec := encoding.GetDefaultCodec()
-	sharedkv := storage.Current().SharedKV()
 
 	router.Handle("/plugin/purge/tasks", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// submit batch purge task
+		if req.Method == http.MethodPost {
+			r.submitTask(w, req)
+			return
+		}
+
+		if !r.allowed(req) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
 		// query sharedkv purge task list
+		if storage.Bypassed() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
 
 		purgeTaskMap := make(map[string]uint64)
 
-		sharedkv.IteratePrefix(req.Context(), []byte("dir/"), func(key, val []byte) error {
+		storage.Current().SharedKV().IteratePrefix(req.Context(), []byte("dir/"), func(key, val []byte) error {
 			purgeTaskMap[string(key)[4:]] = binary.LittleEndian.Uint64(val)
 			return nil
 		})
@@ -77,6 +107,21 @@ func (r *PurgePlugin) AddRouter(router *http.ServeMux) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write(payload)
 	}))
+
+	// query batch purge task progress
+	router.Handle("GET /plugin/purge/tasks/{id}", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !r.allowed(req) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		task, ok := r.queue.get(req.PathValue("id"))
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, task)
+	}))
 }
 
 func (r *PurgePlugin) HandleFunc(next http.HandlerFunc) http.HandlerFunc {
@@ -87,8 +132,7 @@ func (r *PurgePlugin) HandleFunc(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		ipPort := strings.Split(req.RemoteAddr, ":")
-		if _, ok := r.allowAddr[ipPort[0]]; !ok {
+		if !r.allowed(req) {
 			w.WriteHeader(http.StatusForbidden)
 			_metricPurgeRequestsTotal.WithLabelValues("403").Inc()
 			return
@@ -99,98 +143,201 @@ func (r *PurgePlugin) HandleFunc(next http.HandlerFunc) http.HandlerFunc {
 			storeUrl = req.URL.String()
 		}
 
-		u, err1 := url.Parse(storeUrl)
-		if err1 != nil {
-			r.log.Errorf("failed to parse storeUrl %s: %s", storeUrl, err1)
-			_metricPurgeRequestsTotal.WithLabelValues("500").Inc()
-			return
-		}
-
 		ctrl := parsePurgeControl(req.Header.Get(r.opt.HeaderName))
 
-		r.log.Debugf("purge request %s received: %s %s", ipPort[0], storeUrl, ctrl.String())
+		r.log.Debugf("purge request %s received: %s %s", req.RemoteAddr, storeUrl, ctrl.String())
 
-		current := storage.Current()
-
-		// purge dir
-		if ctrl.Dir {
-			// check if/domain exist
-			if _, err := current.SharedKV().Get(context.Background(),
-				[]byte(fmt.Sprintf("if/domain/%s", u.Host))); err != nil && errors.Is(err, storagev1.ErrKeyNotFound) {
-				r.log.Infof("purge dir %s but is not caching in the service", u.Host)
-				_metricPurgeRequestsTotal.WithLabelValues("404").Inc()
+		// tag purge, every tag of `Purge-Tag` is purged
+		targets := []string{storeUrl}
+		if ctrl.Tag {
+			targets = tagindex.Tags(req.Header, r.opt.TagHeader)
+			if len(targets) == 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				_metricPurgeRequestsTotal.WithLabelValues("400").Inc()
 				return
 			}
+		}
 
-			if err := current.PURGE(storeUrl, ctrl); err != nil {
-				if errors.Is(err, storagev1.ErrKeyNotFound) {
-					w.Header().Set("Content-Length", "0")
-					w.Header().Set("Content-Type", "application/json; charset=utf-8")
-					w.WriteHeader(http.StatusNotFound)
-					_metricPurgeRequestsTotal.WithLabelValues("404").Inc()
-					return
-				}
-
-				r.log.Errorf("purge dir %s failed: %v", storeUrl, err)
-				w.WriteHeader(http.StatusInternalServerError)
-				_metricPurgeRequestsTotal.WithLabelValues("500").Inc()
-				return
+		code := http.StatusNotFound
+		for _, target := range targets {
+			c, err := r.doPurge(req.Context(), target, ctrl)
+			r.audit.record(auditEntry{
+				RemoteAddr: req.RemoteAddr,
+				Mode:       ctrl.String(),
+				Target:     target,
+				Code:       c,
+				Error:      errString(err),
+			})
+
+			if c >= http.StatusInternalServerError {
+				r.log.Errorf("purge %s failed: %v", target, err)
+				code = c
+				break
+			}
+			if c == http.StatusOK {
+				code = c
 			}
+		}
 
-			payload := []byte(`{"message":"success"}`)
-			w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
-			w.Header().Set("Content-Type", "application/json; charset=utf-8")
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write(payload)
-			_metricPurgeRequestsTotal.WithLabelValues("200").Inc()
-			return
+		writePurgeResult(w, code)
+	}
+}
+
+// doPurge executes a single purge, target is the storeUrl or the tag.
+func (r *PurgePlugin) doPurge(_ context.Context, target string, ctrl storagev1.PurgeControl) (int, error) {
+	// storage is handed off to the new process during hot upgrade, the client should retry.
+	if storage.Bypassed() {
+		return http.StatusServiceUnavailable, errStorageHandoff
+	}
+
+	current := storage.Current()
+
+	// purge dir
+	if ctrl.Dir {
+		u, err := url.Parse(target)
+		if err != nil {
+			return http.StatusInternalServerError, err
 		}
 
-		// purge single file.
-		if err := current.PURGE(storeUrl, ctrl); err != nil {
-			// key not found.
-			if errors.Is(err, storagev1.ErrKeyNotFound) {
-				w.Header().Set("Content-Length", "0")
-				w.Header().Set("Content-Type", "application/json; charset=utf-8")
-				w.WriteHeader(http.StatusNotFound)
-				_metricPurgeRequestsTotal.WithLabelValues("404").Inc()
-				return
-			}
+		// check if/domain exist
+		if _, err := current.SharedKV().Get(context.Background(),
+			[]byte(fmt.Sprintf("if/domain/%s", u.Host))); err != nil && errors.Is(err, storagev1.ErrKeyNotFound) {
+			r.log.Infof("purge dir %s but is not caching in the service", u.Host)
+			return http.StatusNotFound, nil
+		}
+	}
 
-			// others error
-			r.log.Errorf("purge %s failed: %v", storeUrl, err)
-			w.WriteHeader(http.StatusInternalServerError)
-			_metricPurgeRequestsTotal.WithLabelValues("500").Inc()
+	if err := current.PURGE(target, ctrl); err != nil {
+		// key not found.
+		if errors.Is(err, storagev1.ErrKeyNotFound) {
+			return http.StatusNotFound, nil
+		}
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// submitTask accepts a batch of purge items and executes them asynchronously, e.g.
+//
+//	curl -X POST http://127.0.0.1:8080/plugin/purge/tasks -d '{"items":[{"url":"http://www.example.com/static/","type":"dir"},{"tag":"product-1","type":"tag,hard"}]}'
+func (r *PurgePlugin) submitTask(w http.ResponseWriter, req *http.Request) {
+	if !r.allowed(req) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var body struct {
+		Items []Item `json:"items"`
+	}
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxTaskBodySize)).Decode(&body); err != nil || len(body.Items) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, item := range body.Items {
+		if item.Target() == "" {
+			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
+	}
 
-		payload := []byte(`{"message":"success"}`)
-		w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write(payload)
-		_metricPurgeRequestsTotal.WithLabelValues("200").Inc()
+	task, err := r.queue.submit(body.Items)
+	if errors.Is(err, ErrQueueClosed) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
 	}
+	if err != nil {
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	r.log.Infof("purge task %s submitted by %s with %d items", task.ID, req.RemoteAddr, task.Total)
+	writeJSON(w, http.StatusAccepted, task)
+}
+
+// execItem is the executor of batch purge queue.
+func (r *PurgePlugin) execItem(ctx context.Context, taskID string, item Item) (int, error) {
+	ctrl := parsePurgeControl(item.Type)
+	if item.Tag != "" {
+		ctrl.Tag, ctrl.Dir = true, false
+	}
+
+	code, err := r.doPurge(ctx, item.Target(), ctrl)
+	_metricPurgeRequestsTotal.WithLabelValues(strconv.Itoa(code)).Inc()
+	r.audit.record(auditEntry{
+		TaskID: taskID,
+		Mode:   ctrl.String(),
+		Target: item.Target(),
+		Code:   code,
+		Error:  errString(err),
+	})
+	return code, err
+}
+
+func (r *PurgePlugin) allowed(req *http.Request) bool {
+	return r.allowAddr.AllowRemote(req.RemoteAddr)
 }
 
 func NewPurgePlugin(opts configv1.Option, log *log.Helper) (configv1.Plugin, error) {
 	opt := &option{
-		HeaderName: "Purge-Type",
+		HeaderName:   "Purge-Type",
+		TagHeader:    "Purge-Tag",
+		MaxQueueSize: 1000,
+		Workers:      4,
 	}
 	if err := opts.Unmarshal(opt); err != nil {
 		return nil, err
 	}
 
-	allowAddr := make(map[string]struct{}, len(opt.AllowHosts))
-	for _, addr := range opt.AllowHosts {
-		allowAddr[addr] = struct{}{}
+	allowAddr, err := ipallow.Parse(opt.AllowHosts)
+	if err != nil {
+		return nil, fmt.Errorf("purge allow_hosts: %w", err)
 	}
 
-	return &PurgePlugin{
+	r := &PurgePlugin{
 		log:       log,
 		opt:       opt,
 		allowAddr: allowAddr,
-	}, nil
+		audit:     newAuditLog(opt.LogPath),
+	}
+	r.queue = newTaskQueue(opt.MaxQueueSize, opt.Workers, r.execItem)
+	return r, nil
+}
+
+func writePurgeResult(w http.ResponseWriter, code int) {
+	switch code {
+	case http.StatusOK:
+		payload := []byte(`{"message":"success"}`)
+		w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(payload)
+	case http.StatusNotFound:
+		w.Header().Set("Content-Length", "0")
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(code)
+	}
+	_metricPurgeRequestsTotal.WithLabelValues(strconv.Itoa(code)).Inc()
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_, _ = w.Write(payload)
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
 }
 
 func parsePurgeControl(headValue string) storagev1.PurgeControl {
@@ -202,6 +349,7 @@ func parsePurgeControl(headValue string) storagev1.PurgeControl {
 
 	if len(param) >= 1 {
 		ctrl.Dir = param[0] == "dir"
+		ctrl.Tag = param[0] == "tag"
 	}
 
 	// 配置推送模式 hard / mark_expired
@@ -0,0 +1,39 @@
+package purge
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/tavern/conf"
+	"github.com/omalloc/tavern/contrib/log"
+)
+
+func TestAllowed(t *testing.T) {
+	p, err := NewPurgePlugin(&conf.Plugin{
+		Name:    "purge",
+		Options: map[string]any{"allow_hosts": []any{"127.1", "::1", "10.0.0.0/8"}},
+	}, log.NewHelper(log.GetLogger()))
+	assert.NoError(t, err)
+
+	r := p.(*PurgePlugin)
+	for addr, ok := range map[string]bool{
+		"127.0.0.1:52100":     true,
+		"[::1]:52100":         true,
+		"10.2.3.4:52100":      true,
+		"[::2]:52100":         false,
+		"192.168.1.1:52100":   false,
+		"[2001:db8::1]:52100": false,
+	} {
+		req := httptest.NewRequest(Method, "http://www.example.com/", nil)
+		req.RemoteAddr = addr
+		assert.Equal(t, ok, r.allowed(req), addr)
+	}
+
+	_, err = NewPurgePlugin(&conf.Plugin{
+		Name:    "purge",
+		Options: map[string]any{"allow_hosts": []any{"example.com"}},
+	}, log.NewHelper(log.GetLogger()))
+	assert.Error(t, err)
+}
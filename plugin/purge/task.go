@@ -0,0 +1,248 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	TaskPending = "pending"
+	TaskRunning = "running"
+	TaskDone    = "done"
+	// TaskCanceled 插件关闭时队列中未执行的条目被取消
+	TaskCanceled = "canceled"
+)
+
+// maxTasks 内存中最多保留的任务数, 超出后淘汰最早完成的任务
+const maxTasks = 1024
+
+var (
+	ErrQueueFull   = errors.New("purge queue is full")
+	ErrQueueClosed = errors.New("purge queue is closed")
+)
+
+// Item is a single purge target of batch task.
+type Item struct {
+	URL  string `json:"url,omitempty"`  // file or dir url
+	Tag  string `json:"tag,omitempty"`  // cache tag, used by `tag` type
+	Type string `json:"type,omitempty"` // same as `Purge-Type` header, e.g. file / dir,hard / tag
+}
+
+// Target returns the storeUrl or the tag to purge.
+func (i Item) Target() string {
+	if i.Tag != "" {
+		return i.Tag
+	}
+	return i.URL
+}
+
+type ItemResult struct {
+	Item
+	Code  int    `json:"code"`
+	Error string `json:"error,omitempty"`
+}
+
+// Task is a batch of purge items executed asynchronously.
+type Task struct {
+	ID         string       `json:"id"`
+	Status     string       `json:"status"`
+	Total      int          `json:"total"`
+	Done       int          `json:"done"`
+	Succeeded  int          `json:"succeeded"`
+	Failed     int          `json:"failed"`
+	CreatedAt  time.Time    `json:"created_at"`
+	FinishedAt time.Time    `json:"finished_at,omitzero"`
+	Results    []ItemResult `json:"results"`
+}
+
+type job struct {
+	task  *Task
+	index int
+}
+
+type executor func(ctx context.Context, taskID string, item Item) (int, error)
+
+// taskQueue executes the purge items with bounded workers.
+type taskQueue struct {
+	mu      sync.RWMutex
+	tasks   map[string]*Task
+	order   []string
+	jobs    chan job
+	exec    executor
+	workers int
+	stop    chan struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+func newTaskQueue(size, workers int, exec executor) *taskQueue {
+	if size <= 0 {
+		size = 1000
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+	return &taskQueue{
+		tasks:   make(map[string]*Task),
+		jobs:    make(chan job, size),
+		exec:    exec,
+		workers: workers,
+		stop:    make(chan struct{}),
+	}
+}
+
+func (q *taskQueue) start() {
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+}
+
+// close stops the workers, the jobs left in queue are canceled so their tasks do not stay pending.
+func (q *taskQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+
+	close(q.stop)
+	q.wg.Wait()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		select {
+		case j := <-q.jobs:
+			q.cancel(j)
+		default:
+			return
+		}
+	}
+}
+
+func (q *taskQueue) worker() {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-q.stop:
+			return
+		case j := <-q.jobs:
+			q.run(j)
+		}
+	}
+}
+
+func (q *taskQueue) run(j job) {
+	q.mu.Lock()
+	j.task.Status = TaskRunning
+	item := j.task.Results[j.index].Item
+	q.mu.Unlock()
+
+	code, err := q.exec(context.Background(), j.task.ID, item)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	res := &j.task.Results[j.index]
+	res.Code = code
+	if err != nil {
+		res.Error = err.Error()
+	}
+	if code == http.StatusOK {
+		j.task.Succeeded++
+	} else {
+		j.task.Failed++
+	}
+	q.finish(j.task)
+}
+
+// cancel marks the job failed without executing it, must be called with mu held.
+func (q *taskQueue) cancel(j job) {
+	res := &j.task.Results[j.index]
+	res.Code = http.StatusServiceUnavailable
+	res.Error = ErrQueueClosed.Error()
+	j.task.Failed++
+	j.task.Status = TaskCanceled
+	q.finish(j.task)
+}
+
+// finish counts the job done, must be called with mu held.
+func (q *taskQueue) finish(task *Task) {
+	task.Done++
+	if task.Done < task.Total {
+		return
+	}
+	if task.Status != TaskCanceled {
+		task.Status = TaskDone
+	}
+	task.FinishedAt = time.Now()
+}
+
+// submit enqueues all items as a task, the whole batch is rejected when the queue has no room.
+func (q *taskQueue) submit(items []Item) (*Task, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil, ErrQueueClosed
+	}
+	if cap(q.jobs)-len(q.jobs) < len(items) {
+		return nil, ErrQueueFull
+	}
+
+	task := &Task{
+		ID:        uuid.NewString(),
+		Status:    TaskPending,
+		Total:     len(items),
+		CreatedAt: time.Now(),
+		Results:   make([]ItemResult, len(items)),
+	}
+	for i, item := range items {
+		task.Results[i].Item = item
+	}
+
+	q.tasks[task.ID] = task
+	q.order = append(q.order, task.ID)
+	q.evict()
+
+	// producers are serialized by mu, the room checked above is guaranteed.
+	for i := range items {
+		q.jobs <- job{task: task, index: i}
+	}
+	return task.clone(), nil
+}
+
+// get returns a snapshot of the task.
+func (q *taskQueue) get(id string) (*Task, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	task, ok := q.tasks[id]
+	if !ok {
+		return nil, false
+	}
+	return task.clone(), true
+}
+
+// evict drops the oldest finished tasks over maxTasks, must be called with mu held.
+func (q *taskQueue) evict() {
+	for i := 0; len(q.tasks) > maxTasks && i < len(q.order); {
+		id := q.order[i]
+		if status := q.tasks[id].Status; status != TaskDone && status != TaskCanceled {
+			i++
+			continue
+		}
+		delete(q.tasks, id)
+		q.order = append(q.order[:i], q.order[i+1:]...)
+	}
+}
+
+func (t *Task) clone() *Task {
+	c := *t
+	c.Results = append([]ItemResult(nil), t.Results...)
+	return &c
+}
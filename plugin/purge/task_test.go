@@ -0,0 +1,96 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskQueue(t *testing.T) {
+	q := newTaskQueue(4, 2, func(_ context.Context, _ string, item Item) (int, error) {
+		switch item.Target() {
+		case "http://www.example.com/miss":
+			return http.StatusNotFound, nil
+		case "broken":
+			return http.StatusInternalServerError, errors.New("broken")
+		}
+		return http.StatusOK, nil
+	})
+	q.start()
+	defer q.close()
+
+	task, err := q.submit([]Item{
+		{URL: "http://www.example.com/hit"},
+		{URL: "http://www.example.com/miss"},
+		{Tag: "broken", Type: "tag"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, task.Total)
+
+	assert.Eventually(t, func() bool {
+		task, _ = q.get(task.ID)
+		return task.Status == TaskDone
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, 3, task.Done)
+	assert.Equal(t, 1, task.Succeeded)
+	assert.Equal(t, 2, task.Failed)
+	assert.Equal(t, http.StatusOK, task.Results[0].Code)
+	assert.Equal(t, http.StatusNotFound, task.Results[1].Code)
+	assert.Equal(t, "broken", task.Results[2].Error)
+	assert.False(t, task.FinishedAt.IsZero())
+
+	_, ok := q.get("unknown")
+	assert.False(t, ok)
+}
+
+func TestTaskQueueFull(t *testing.T) {
+	// workers not started, jobs stay in queue
+	q := newTaskQueue(2, 1, func(context.Context, string, Item) (int, error) { return http.StatusOK, nil })
+
+	_, err := q.submit([]Item{{URL: "a"}, {URL: "b"}, {URL: "c"}})
+	assert.ErrorIs(t, err, ErrQueueFull)
+
+	_, err = q.submit([]Item{{URL: "a"}, {URL: "b"}})
+	assert.NoError(t, err)
+
+	_, err = q.submit([]Item{{URL: "c"}})
+	assert.ErrorIs(t, err, ErrQueueFull)
+}
+
+func TestParsePurgeControl(t *testing.T) {
+	ctrl := parsePurgeControl("")
+	assert.True(t, ctrl.MarkExpired)
+	assert.False(t, ctrl.Dir || ctrl.Tag || ctrl.Hard)
+
+	ctrl = parsePurgeControl("dir,hard")
+	assert.True(t, ctrl.Dir && ctrl.Hard)
+	assert.False(t, ctrl.MarkExpired)
+
+	ctrl = parsePurgeControl("tag")
+	assert.True(t, ctrl.Tag && ctrl.MarkExpired)
+}
+
+func TestTaskQueueClose(t *testing.T) {
+	// workers not started, jobs stay in queue until close
+	q := newTaskQueue(4, 1, func(context.Context, string, Item) (int, error) { return http.StatusOK, nil })
+
+	task, err := q.submit([]Item{{URL: "a"}, {URL: "b"}})
+	assert.NoError(t, err)
+
+	q.close()
+
+	task, _ = q.get(task.ID)
+	assert.Equal(t, TaskCanceled, task.Status)
+	assert.Equal(t, 2, task.Done)
+	assert.Equal(t, 2, task.Failed)
+	assert.Equal(t, http.StatusServiceUnavailable, task.Results[0].Code)
+	assert.False(t, task.FinishedAt.IsZero())
+
+	_, err = q.submit([]Item{{URL: "c"}})
+	assert.ErrorIs(t, err, ErrQueueClosed)
+}
@@ -0,0 +1,415 @@
+package qs
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	storagev1 "github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+	"github.com/omalloc/tavern/pkg/cachekey"
+	"github.com/omalloc/tavern/storage"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+	// maxListScan bounds the objects scanned by one page, the sparse filters
+	// return fewer objects with the cursor of the last scanned one.
+	maxListScan = 100000
+)
+
+// Object flags of the `flags` filter.
+const (
+	flagVary      = "vary" // vary index or vary cache
+	flagVaryIndex = "vary_index"
+	flagVaryCache = "vary_cache"
+	flagChunked   = "chunked"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// ObjectList is a page of the object listing.
+type ObjectList struct {
+	Objects    []*SimpleMetadata `json:"objects"`
+	NextCursor string            `json:"next_cursor,omitempty"` // empty on the last page
+	Scanned    int               `json:"scanned"`
+}
+
+// ObjectDetail is the full metadata of an object and the bucket holding it.
+type ObjectDetail struct {
+	Bucket    string           `json:"bucket"`
+	StoreType string           `json:"store_type"`
+	Hash      string           `json:"hash"`
+	Expired   bool             `json:"expired"`
+	Chunks    string           `json:"chunks"` // chunk ranges, e.g. `0-3,5`
+	Metadata  *object.Metadata `json:"metadata"`
+	Variants  []*ObjectDetail  `json:"variants,omitempty"` // vary versions of the vary index
+}
+
+// objectQuery is the filters of the object listing.
+type objectQuery struct {
+	prefix    string
+	host      string
+	flags     []string
+	expired   bool
+	minSize   uint64
+	maxSize   uint64
+	bucket    string
+	storeType string
+	limit     int
+	hash      bool
+
+	// cursor, the scan starts after the hash of the bucket index
+	cursorBucket int
+	cursorHash   []byte
+}
+
+func parseObjectQuery(values url.Values) (*objectQuery, error) {
+	q := &objectQuery{
+		prefix:    values.Get("prefix"),
+		host:      values.Get("host"),
+		expired:   values.Get("expired") == "1" || values.Get("expired") == "true",
+		bucket:    values.Get("bucket"),
+		storeType: values.Get("store_type"),
+		limit:     defaultListLimit,
+		hash:      values.Get("hash") != "",
+	}
+
+	if flags := values.Get("flags"); flags != "" {
+		for _, flag := range strings.Split(flags, ",") {
+			flag = strings.TrimSpace(flag)
+			switch flag {
+			case flagVary, flagVaryIndex, flagVaryCache, flagChunked:
+				q.flags = append(q.flags, flag)
+			default:
+				return nil, fmt.Errorf("unknown flag %q", flag)
+			}
+		}
+	}
+
+	var err error
+	if s := values.Get("min_size"); s != "" {
+		if q.minSize, err = strconv.ParseUint(s, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid min_size %q", s)
+		}
+	}
+	if s := values.Get("max_size"); s != "" {
+		if q.maxSize, err = strconv.ParseUint(s, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid max_size %q", s)
+		}
+	}
+
+	if s := values.Get("limit"); s != "" {
+		if q.limit, err = strconv.Atoi(s); err != nil || q.limit <= 0 {
+			return nil, fmt.Errorf("invalid limit %q", s)
+		}
+		q.limit = min(q.limit, maxListLimit)
+	}
+
+	if s := values.Get("cursor"); s != "" {
+		if q.cursorBucket, q.cursorHash, err = decodeCursor(s); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// encodeCursor encodes the bucket index and the last scanned hash.
+func encodeCursor(bucket int, hash []byte) string {
+	return base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, "%d:%x", bucket, hash))
+}
+
+func decodeCursor(s string) (int, []byte, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, nil, errInvalidCursor
+	}
+
+	idx, hashStr, ok := strings.Cut(string(buf), ":")
+	if !ok {
+		return 0, nil, errInvalidCursor
+	}
+
+	bucket, err := strconv.Atoi(idx)
+	if err != nil || bucket < 0 {
+		return 0, nil, errInvalidCursor
+	}
+
+	hash, err := hex.DecodeString(hashStr)
+	if err != nil || len(hash) != object.IdHashSize {
+		return 0, nil, errInvalidCursor
+	}
+	return bucket, hash, nil
+}
+
+func (q *objectQuery) matchBucket(bucket storagev1.Bucket) bool {
+	if q.bucket != "" && bucket.ID() != q.bucket {
+		return false
+	}
+	return q.storeType == "" || bucket.StoreType() == q.storeType
+}
+
+func (q *objectQuery) match(md *object.Metadata, now int64) bool {
+	if md == nil || md.ID == nil {
+		return false
+	}
+
+	key := md.ID.Path()
+	if q.prefix != "" && !strings.HasPrefix(key, q.prefix) {
+		return false
+	}
+	if q.host != "" && !strings.EqualFold(hostOf(key), q.host) {
+		return false
+	}
+
+	for _, flag := range q.flags {
+		if !hasFlag(md, flag) {
+			return false
+		}
+	}
+
+	if q.expired && (md.ExpiresAt <= 0 || md.ExpiresAt > now) {
+		return false
+	}
+	if q.minSize > 0 && md.Size < q.minSize {
+		return false
+	}
+	if q.maxSize > 0 && md.Size > q.maxSize {
+		return false
+	}
+	return true
+}
+
+func hasFlag(md *object.Metadata, flag string) bool {
+	switch flag {
+	case flagVary:
+		return md.IsVary() || md.IsVaryCache()
+	case flagVaryIndex:
+		return md.IsVary()
+	case flagVaryCache:
+		return md.IsVaryCache()
+	case flagChunked:
+		return md.IsChunked()
+	}
+	return false
+}
+
+// hostOf returns the host without port of the cache key `scheme://host/path`.
+func hostOf(key string) string {
+	if _, rest, ok := strings.Cut(key, "://"); ok {
+		key = rest
+	}
+	if i := strings.IndexAny(key, "/?#"); i >= 0 {
+		key = key[:i]
+	}
+	if i := strings.LastIndexByte(key, ':'); i >= 0 && !strings.Contains(key[i:], "]") {
+		key = key[:i]
+	}
+	return key
+}
+
+func newSimpleMetadata(bucket storagev1.Bucket, obj *object.Metadata, hash bool) *SimpleMetadata {
+	var vd []string
+	if obj.IsVary() {
+		vd = obj.VirtualKey
+	}
+
+	md := &SimpleMetadata{
+		ID:       obj.ID.Key(),
+		Hash:     obj.ID.HashStr(),
+		Bucket:   bucket.ID(),
+		Chunks:   convRange(obj.Chunks),
+		Code:     obj.Code,
+		Size:     obj.Size,
+		RespUnix: time.Unix(obj.RespUnix, 0),
+		Expired:  time.Unix(obj.ExpiresAt, 0),
+		CacheRef: obj.Refs,
+		Flags:    obj.Flags.String(),
+		Vd:       vd,
+	}
+
+	if hash {
+		md.ID = md.Hash
+	}
+	return md
+}
+
+// listObjects scans the buckets in order from the cursor, and returns a page of the objects matching q.
+func listObjects(ctx context.Context, buckets []storagev1.Bucket, q *objectQuery) (*ObjectList, error) {
+	if q.cursorHash != nil && q.cursorBucket >= len(buckets) {
+		return nil, errInvalidCursor
+	}
+
+	list := &ObjectList{
+		Objects: make([]*SimpleMetadata, 0, q.limit),
+	}
+	now := time.Now().Unix()
+
+	for i := q.cursorBucket; i < len(buckets); i++ {
+		bucket := buckets[i]
+		if !q.matchBucket(bucket) {
+			continue
+		}
+
+		var from []byte
+		if i == q.cursorBucket {
+			from = q.cursorHash
+		}
+
+		var last []byte
+		err := bucket.Scan(ctx, from, func(md *object.Metadata) bool {
+			if md == nil || md.ID == nil {
+				return true
+			}
+
+			hash := md.ID.Bytes()
+			// the cursor is the last scanned object of the previous page
+			if from != nil && bytes.Equal(hash, from) {
+				return true
+			}
+
+			last = hash
+			list.Scanned++
+			if q.match(md, now) {
+				list.Objects = append(list.Objects, newSimpleMetadata(bucket, md, q.hash))
+			}
+			return len(list.Objects) < q.limit && list.Scanned < maxListScan
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scan bucket %s: %w", bucket.ID(), err)
+		}
+
+		if len(list.Objects) >= q.limit || list.Scanned >= maxListScan {
+			list.NextCursor = encodeCursor(i, last)
+			return list, nil
+		}
+	}
+	return list, nil
+}
+
+// lookupObject finds the object of hash in all the buckets, with the vary versions of the vary index.
+func lookupObject(ctx context.Context, buckets []storagev1.Bucket, hash []byte) []*ObjectDetail {
+	details := make([]*ObjectDetail, 0, 1)
+	for _, bucket := range buckets {
+		detail := lookupBucket(ctx, bucket, hash)
+		if detail == nil {
+			continue
+		}
+
+		if detail.Metadata.IsVary() {
+			for _, vk := range detail.Metadata.VirtualKey {
+				vid := object.NewVirtualID(detail.Metadata.ID.Path(), vk)
+				if variant := lookupBucket(ctx, bucket, vid.Bytes()); variant != nil {
+					detail.Variants = append(detail.Variants, variant)
+				}
+			}
+		}
+		details = append(details, detail)
+	}
+	return details
+}
+
+// lookupURL returns the objects of rawURL, the cache keys are built by the `cache_key` rules
+// of the caching middlewares.
+func lookupURL(ctx context.Context, buckets []storagev1.Bucket, rawURL string) []*ObjectDetail {
+	details := make([]*ObjectDetail, 0, 1)
+	for _, key := range cachekey.Keys(rawURL) {
+		details = append(details, lookupObject(ctx, buckets, object.NewID(key).Bytes())...)
+	}
+	return details
+}
+
+// lookupBucket finds the object of hash by Scan, which does not touch the object like Lookup.
+func lookupBucket(ctx context.Context, bucket storagev1.Bucket, hash []byte) *ObjectDetail {
+	var found *object.Metadata
+	_ = bucket.Scan(ctx, hash, func(md *object.Metadata) bool {
+		if md != nil && md.ID != nil && bytes.Equal(md.ID.Bytes(), hash) {
+			found = md
+		}
+		return false
+	})
+	if found == nil {
+		return nil
+	}
+
+	return &ObjectDetail{
+		Bucket:    bucket.ID(),
+		StoreType: bucket.StoreType(),
+		Hash:      found.ID.HashStr(),
+		Expired:   found.ExpiresAt > 0 && found.ExpiresAt <= time.Now().Unix(),
+		Chunks:    convRange(found.Chunks),
+		Metadata:  found,
+	}
+}
+
+// handleObjects lists the objects page by page.
+//
+// GET /plugin/qs/objects?prefix=&host=&flags=vary,chunked&expired=1&min_size=&max_size=&bucket=&store_type=&limit=100&cursor=
+func (qs *QsPlugin) handleObjects(w http.ResponseWriter, r *http.Request) {
+	q, err := parseObjectQuery(r.URL.Query())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	list, err := listObjects(r.Context(), storage.Current().Buckets(), q)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, errInvalidCursor) {
+			code = http.StatusBadRequest
+		}
+		writeJSON(w, code, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleObject returns the full metadata of an object by the request URL or hash.
+//
+// GET /plugin/qs/object?url=http://www.example.com/a.js or ?hash=<sha1 hex>
+func (qs *QsPlugin) handleObject(w http.ResponseWriter, r *http.Request) {
+	var details []*ObjectDetail
+	if rawURL := r.URL.Query().Get("url"); rawURL != "" {
+		details = lookupURL(r.Context(), storage.Current().Buckets(), rawURL)
+	} else if hashStr := r.URL.Query().Get("hash"); hashStr != "" {
+		buf, err := hex.DecodeString(hashStr)
+		if err != nil || len(buf) != object.IdHashSize {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid hash"})
+			return
+		}
+		details = lookupObject(r.Context(), storage.Current().Buckets(), buf)
+	} else {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "url or hash is required"})
+		return
+	}
+
+	if len(details) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "object not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, details)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+	w.WriteHeader(code)
+	_, _ = w.Write(payload)
+}
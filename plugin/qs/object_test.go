@@ -0,0 +1,157 @@
+package qs
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	storagev1 "github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+	"github.com/omalloc/tavern/pkg/cachekey"
+	"github.com/omalloc/tavern/storage/bucket/memory"
+	"github.com/omalloc/tavern/storage/sharedkv"
+)
+
+func newTestBucket(t *testing.T, storeType string) storagev1.Bucket {
+	t.Helper()
+
+	bucket, err := memory.New(&storagev1.BucketConfig{
+		Driver: "memory",
+		Type:   storeType,
+	}, sharedkv.NewEmpty())
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = bucket.Close()
+	})
+	return bucket
+}
+
+func storeObject(t *testing.T, bucket storagev1.Bucket, md *object.Metadata) {
+	t.Helper()
+	assert.NoError(t, bucket.Store(context.Background(), md))
+}
+
+func TestListObjects(t *testing.T) {
+	ctx := context.Background()
+	hot, warm := newTestBucket(t, "hot"), newTestBucket(t, "warm")
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		storeObject(t, hot, &object.Metadata{
+			ID:        object.NewID(fmt.Sprintf("http://a.example.com/%d.js", i)),
+			Code:      200,
+			Size:      uint64(i * 100),
+			ExpiresAt: now.Add(time.Hour).Unix(),
+		})
+	}
+	storeObject(t, warm, &object.Metadata{
+		ID:        object.NewID("http://b.example.com:8080/live/index.m3u8"),
+		Flags:     object.FlagChunkedCache,
+		Code:      200,
+		ExpiresAt: now.Add(-time.Hour).Unix(),
+	})
+	storeObject(t, warm, &object.Metadata{
+		ID:         object.NewID("http://b.example.com/vary.html"),
+		Flags:      object.FlagVaryIndex,
+		VirtualKey: []string{"Accept-Encoding=gzip"},
+	})
+	buckets := []storagev1.Bucket{hot, warm}
+
+	list := func(query string) *ObjectList {
+		values, _ := url.ParseQuery(query)
+		q, err := parseObjectQuery(values)
+		assert.NoError(t, err)
+		page, err := listObjects(ctx, buckets, q)
+		assert.NoError(t, err)
+		return page
+	}
+
+	// paginate all the objects across the buckets
+	seen := make(map[string]struct{})
+	cursor := ""
+	for pages := 0; ; pages++ {
+		assert.Less(t, pages, 10)
+		page := list("limit=2&cursor=" + cursor)
+		for _, md := range page.Objects {
+			seen[md.ID] = struct{}{}
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	assert.Len(t, seen, 7)
+
+	assert.Len(t, list("host=b.example.com").Objects, 2)
+	assert.Len(t, list("prefix=http://a.example.com/1").Objects, 1)
+	assert.Len(t, list("store_type=warm").Objects, 2)
+	assert.Len(t, list("min_size=200&max_size=300").Objects, 2)
+
+	page := list("flags=chunked&expired=1")
+	assert.Len(t, page.Objects, 1)
+	assert.Equal(t, "http://b.example.com:8080/live/index.m3u8", page.Objects[0].ID)
+
+	page = list("flags=vary&hash=1")
+	assert.Len(t, page.Objects, 1)
+	assert.Equal(t, object.NewID("http://b.example.com/vary.html").HashStr(), page.Objects[0].ID)
+	assert.Equal(t, []string{"Accept-Encoding=gzip"}, page.Objects[0].Vd)
+
+	for _, query := range []string{"flags=gzip", "limit=0", "min_size=-1", "cursor=xx"} {
+		values, _ := url.ParseQuery(query)
+		_, err := parseObjectQuery(values)
+		assert.Error(t, err, query)
+	}
+
+	q := &objectQuery{limit: 1, cursorBucket: 2, cursorHash: make([]byte, object.IdHashSize)}
+	_, err := listObjects(ctx, buckets, q)
+	assert.ErrorIs(t, err, errInvalidCursor)
+}
+
+func TestLookupObject(t *testing.T) {
+	ctx := context.Background()
+	bucket := newTestBucket(t, "warm")
+
+	id := object.NewID("http://www.example.com/vary.html")
+	vid := object.NewVirtualID(id.Path(), "Accept-Encoding=gzip")
+	storeObject(t, bucket, &object.Metadata{ID: id, Flags: object.FlagVaryIndex, VirtualKey: []string{"Accept-Encoding=gzip"}})
+	storeObject(t, bucket, &object.Metadata{ID: vid, Flags: object.FlagVaryCache, Code: 200, Size: 10})
+
+	details := lookupObject(ctx, []storagev1.Bucket{bucket}, id.Bytes())
+	assert.Len(t, details, 1)
+	assert.Equal(t, id.HashStr(), details[0].Hash)
+	assert.Equal(t, "warm", details[0].StoreType)
+	assert.Len(t, details[0].Variants, 1)
+	assert.Equal(t, vid.HashStr(), details[0].Variants[0].Hash)
+
+	assert.Empty(t, lookupObject(ctx, []storagev1.Bucket{bucket}, object.NewID("http://www.example.com/none").Bytes()))
+}
+
+func TestLookupURL(t *testing.T) {
+	ctx := context.Background()
+	bucket := newTestBucket(t, "warm")
+
+	// stored by the caching middleware which drops the `utm_*` params
+	b, err := cachekey.New([]cachekey.Rule{{Query: cachekey.QueryExclude, QueryParams: []string{"utm_*"}}}, true)
+	assert.NoError(t, err)
+	defer cachekey.Register("default", b)()
+
+	id := object.NewID("http://www.example.com/a.js?v=1")
+	storeObject(t, bucket, &object.Metadata{ID: id, Code: 200, Size: 10})
+
+	details := lookupURL(ctx, []storagev1.Bucket{bucket}, "http://www.example.com/a.js?utm_source=x&v=1")
+	assert.Len(t, details, 1)
+	assert.Equal(t, id.HashStr(), details[0].Hash)
+
+	assert.Empty(t, lookupURL(ctx, []storagev1.Bucket{bucket}, "http://www.example.com/a.js?v=2"))
+}
+
+func TestHostOf(t *testing.T) {
+	assert.Equal(t, "www.example.com", hostOf("http://www.example.com/a.js"))
+	assert.Equal(t, "www.example.com", hostOf("https://www.example.com:8443/a.js?v=1"))
+	assert.Equal(t, "[::1]", hostOf("http://[::1]:8080/a.js"))
+	assert.Equal(t, "[::1]", hostOf("http://[::1]#x"))
+}
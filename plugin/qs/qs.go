@@ -15,7 +15,6 @@ import (
 	"github.com/kelindar/bitmap"
 	configv1 "github.com/omalloc/tavern/api/defined/v1/plugin"
 	storagev1 "github.com/omalloc/tavern/api/defined/v1/storage"
-	"github.com/omalloc/tavern/api/defined/v1/storage/object"
 	"github.com/omalloc/tavern/contrib/log"
 	"github.com/omalloc/tavern/pkg/x/runtime"
 	"github.com/omalloc/tavern/plugin"
@@ -37,6 +36,8 @@ type Graph struct {
 
 type SimpleMetadata struct {
 	ID       string    `json:"id"`
+	Hash     string    `json:"hash"`
+	Bucket   string    `json:"bucket"`
 	Chunks   string    `json:"chunks,omitempty"`
 	Code     int       `json:"code"`
 	Size     uint64    `json:"size"`
@@ -115,46 +116,8 @@ func (qs *QsPlugin) AddRouter(router *http.ServeMux) {
 		_, _ = w.Write(payload)
 	}))
 
-	router.Handle("/plugin/qs/object/simple", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		getHash := r.URL.Query().Get("hash") != ""
-
-		buckets := storage.Current().Buckets()
-		objects := make([]*SimpleMetadata, 0)
-		for _, bucket := range buckets {
-			_ = bucket.Iterate(context.Background(), func(obj *object.Metadata) error {
-				var vd []string
-				if obj.IsVary() {
-					vd = obj.VirtualKey
-				}
-
-				md := &SimpleMetadata{
-					ID:       obj.ID.Key(),
-					Chunks:   convRange(obj.Chunks),
-					Code:     obj.Code,
-					Size:     obj.Size,
-					RespUnix: time.Unix(obj.RespUnix, 0),
-					Expired:  time.Unix(obj.ExpiresAt, 0),
-					CacheRef: obj.Refs,
-					Flags:    obj.Flags.String(),
-					Vd:       vd,
-				}
-
-				if getHash {
-					md.ID = obj.ID.HashStr()
-				}
-
-				objects = append(objects, md)
-
-				return nil
-			})
-		}
-
-		payload, _ := json.Marshal(objects)
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(payload)))
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write(payload)
-	}))
+	router.Handle("/plugin/qs/objects", http.HandlerFunc(qs.handleObjects))
+	router.Handle("/plugin/qs/object", http.HandlerFunc(qs.handleObject))
 
 	// get this device's service domains
 	router.Handle("/plugin/qs/service-domains", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
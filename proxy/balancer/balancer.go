@@ -0,0 +1,70 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/once"
+	"github.com/omalloc/proxy/selector/random"
+	"github.com/omalloc/proxy/selector/wrr"
+)
+
+const (
+	// NameWRR weighted round-robin (nginx smooth wrr), default.
+	NameWRR = wrr.Name
+	// NameRandom random pick.
+	NameRandom = random.Name
+	// NameOnce always pick the first address.
+	NameOnce = once.Name
+	// NameLeastConn pick the node with the fewest in-flight requests relative to its weight.
+	NameLeastConn = "least_conn"
+	// NameConsistentHash consistent hash on cache key, each origin sees a stable subset of URLs.
+	NameConsistentHash = "chash"
+)
+
+var builders = map[string]func() selector.Selector{
+	NameWRR:            func() selector.Selector { return wrr.New() },
+	NameRandom:         func() selector.Selector { return random.New() },
+	NameOnce:           func() selector.Selector { return once.New() },
+	NameLeastConn:      func() selector.Selector { return NewLeastConn() },
+	NameConsistentHash: func() selector.Selector { return NewConsistentHash() },
+}
+
+// aliases of balancer name.
+var aliases = map[string]string{
+	"":                NameWRR,
+	"round_robin":     NameWRR,
+	"leastconn":       NameLeastConn,
+	"least_conns":     NameLeastConn,
+	"consistent_hash": NameConsistentHash,
+	"hash":            NameConsistentHash,
+}
+
+// New returns the selector by `upstream.balancing` name.
+func New(name string) (selector.Selector, error) {
+	name = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), "-", "_")
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+
+	builder, ok := builders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown upstream balancing %q", name)
+	}
+	return builder(), nil
+}
+
+type hashKey struct{}
+
+// WithHashKey returns a new context carrying the hash key used by consistent-hash balancer.
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKey{}, key)
+}
+
+// HashKeyFromContext returns the hash key in ctx if it exists.
+func HashKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(hashKey{}).(string)
+	return key, ok
+}
@@ -0,0 +1,75 @@
+package balancer_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/tavern/proxy/balancer"
+)
+
+func testNodes(weights ...int) []selector.Node {
+	nodes := make([]selector.Node, 0, len(weights))
+	for i, w := range weights {
+		nodes = append(nodes, selector.NewNode("http", fmt.Sprintf("10.0.0.%d:80", i+1), selector.RawMetadata("weight", fmt.Sprint(w))))
+	}
+	return nodes
+}
+
+func TestNew(t *testing.T) {
+	for _, name := range []string{"", "wrr", "random", "once", "least_conn", "least-conn", "chash", "consistent_hash"} {
+		s, err := balancer.New(name)
+		assert.NoError(t, err, name)
+		assert.NotNil(t, s, name)
+	}
+
+	_, err := balancer.New("unknown")
+	assert.Error(t, err)
+}
+
+func TestLeastConn(t *testing.T) {
+	s := balancer.NewLeastConn()
+	s.Apply(testNodes(1, 1))
+
+	ctx := context.Background()
+	first, done1, err := s.Select(ctx)
+	assert.NoError(t, err)
+
+	// the busy node is skipped until its request done.
+	second, done2, err := s.Select(ctx)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.Address(), second.Address())
+
+	done1(ctx, selector.DoneInfo{})
+	third, _, err := s.Select(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, first.Address(), third.Address())
+
+	done2(ctx, selector.DoneInfo{})
+}
+
+func TestConsistentHash(t *testing.T) {
+	s := balancer.NewConsistentHash()
+	s.Apply(testNodes(1, 1, 2))
+
+	picked := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		ctx := balancer.WithHashKey(context.Background(), fmt.Sprintf("http://www.example.com/%d.jpg", i))
+
+		n1, _, err := s.Select(ctx)
+		assert.NoError(t, err)
+		n2, _, err := s.Select(ctx)
+		assert.NoError(t, err)
+
+		// stable
+		assert.Equal(t, n1.Address(), n2.Address())
+		picked[n1.Address()]++
+	}
+
+	assert.Len(t, picked, 3)
+	// weight 2 node owns more keys.
+	assert.Greater(t, picked["10.0.0.3:80"], picked["10.0.0.1:80"])
+}
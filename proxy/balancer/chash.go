@@ -0,0 +1,119 @@
+package balancer
+
+import (
+	"context"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/omalloc/proxy/selector/node/direct"
+)
+
+// replicas 每个权重单位对应的虚拟节点数
+const replicas = 40
+
+var _ selector.Balancer = (*chashBalancer)(nil)
+
+// NewConsistentHash returns a consistent-hash selector.
+//
+// hash key 来自 WithHashKey 注入的上下文 (缓存 key), 节点权重决定其在环上的虚拟节点数.
+func NewConsistentHash() selector.Selector {
+	return (&selector.DefaultBuilder{
+		Balancer: &chashBuilder{},
+		Node:     &direct.Builder{},
+	}).Build()
+}
+
+type chashBuilder struct{}
+
+// Build creates Balancer
+func (b *chashBuilder) Build() selector.Balancer {
+	return &chashBalancer{}
+}
+
+type chashBalancer struct {
+	mu   sync.RWMutex
+	sign string
+	ring *ring
+}
+
+// Pick implements selector.Balancer.
+func (b *chashBalancer) Pick(ctx context.Context, nodes []selector.WeightedNode) (selector.WeightedNode, selector.DoneFunc, error) {
+	if len(nodes) == 0 {
+		return nil, nil, selector.ErrNoAvailable
+	}
+
+	key, _ := HashKeyFromContext(ctx)
+	selected := b.load(nodes).get(key)
+	return selected, selected.Pick(), nil
+}
+
+// load returns the ring of nodes, rebuild when nodes changed.
+func (b *chashBalancer) load(nodes []selector.WeightedNode) *ring {
+	sign := signature(nodes)
+
+	b.mu.RLock()
+	if b.ring != nil && b.sign == sign {
+		r := b.ring
+		b.mu.RUnlock()
+		return r
+	}
+	b.mu.RUnlock()
+
+	r := newRing(nodes)
+
+	b.mu.Lock()
+	b.sign, b.ring = sign, r
+	b.mu.Unlock()
+	return r
+}
+
+func signature(nodes []selector.WeightedNode) string {
+	sb := strings.Builder{}
+	for _, n := range nodes {
+		sb.WriteString(n.Address())
+		sb.WriteByte('#')
+		sb.WriteString(strconv.FormatFloat(n.Weight(), 'f', -1, 64))
+		sb.WriteByte(';')
+	}
+	return sb.String()
+}
+
+type ring struct {
+	hashes []uint32
+	nodes  map[uint32]selector.WeightedNode
+}
+
+func newRing(nodes []selector.WeightedNode) *ring {
+	r := &ring{
+		hashes: make([]uint32, 0, len(nodes)*replicas),
+		nodes:  make(map[uint32]selector.WeightedNode, len(nodes)*replicas),
+	}
+
+	for _, n := range nodes {
+		weight := int(nodeWeight(n))
+		for i := 0; i < weight*replicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + "|" + n.Address()))
+			if _, exist := r.nodes[h]; exist {
+				continue
+			}
+			r.nodes[h] = n
+			r.hashes = append(r.hashes, h)
+		}
+	}
+
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+func (r *ring) get(key string) selector.WeightedNode {
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.hashes), func(x int) bool { return r.hashes[x] >= h })
+	if i >= len(r.hashes) {
+		i = 0
+	}
+	return r.nodes[r.hashes[i]]
+}
@@ -0,0 +1,122 @@
+package balancer
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/omalloc/proxy/selector"
+)
+
+const defaultWeight = 1
+
+var (
+	_ selector.Balancer            = (*leastConnBalancer)(nil)
+	_ selector.WeightedNode        = (*connNode)(nil)
+	_ selector.WeightedNodeBuilder = (*connNodeBuilder)(nil)
+)
+
+// NewLeastConn returns a least-connections selector.
+//
+// 选择 inflight/weight 最小的节点, 相同负载时随机打散避免总是命中第一个节点.
+func NewLeastConn() selector.Selector {
+	return (&selector.DefaultBuilder{
+		Balancer: &leastConnBuilder{},
+		Node:     &connNodeBuilder{},
+	}).Build()
+}
+
+type leastConnBuilder struct{}
+
+// Build creates Balancer
+func (b *leastConnBuilder) Build() selector.Balancer {
+	return &leastConnBalancer{}
+}
+
+type leastConnBalancer struct{}
+
+// Pick implements selector.Balancer.
+func (b *leastConnBalancer) Pick(_ context.Context, nodes []selector.WeightedNode) (selector.WeightedNode, selector.DoneFunc, error) {
+	if len(nodes) == 0 {
+		return nil, nil, selector.ErrNoAvailable
+	}
+
+	var (
+		selected selector.WeightedNode
+		minLoad  float64
+		ties     int
+	)
+	for _, node := range nodes {
+		load := float64(inflight(node)+1) / node.Weight()
+		switch {
+		case selected == nil || load < minLoad:
+			selected, minLoad, ties = node, load, 1
+		case load == minLoad:
+			// reservoir sampling on ties
+			ties++
+			if rand.IntN(ties) == 0 {
+				selected = node
+			}
+		}
+	}
+
+	return selected, selected.Pick(), nil
+}
+
+func inflight(node selector.WeightedNode) int64 {
+	if n, ok := node.(*connNode); ok {
+		return atomic.LoadInt64(&n.inflight)
+	}
+	return 0
+}
+
+type connNodeBuilder struct{}
+
+// Build create node
+func (*connNodeBuilder) Build(n selector.Node) selector.WeightedNode {
+	return &connNode{Node: n}
+}
+
+// connNode tracks in-flight requests of the upstream node.
+type connNode struct {
+	selector.Node
+
+	inflight int64
+	lastPick int64
+}
+
+// Pick implements selector.WeightedNode.
+func (n *connNode) Pick() selector.DoneFunc {
+	atomic.StoreInt64(&n.lastPick, time.Now().UnixNano())
+	atomic.AddInt64(&n.inflight, 1)
+
+	var once atomic.Bool
+	return func(ctx context.Context, di selector.DoneInfo) {
+		if once.CompareAndSwap(false, true) {
+			atomic.AddInt64(&n.inflight, -1)
+		}
+	}
+}
+
+// Weight implements selector.WeightedNode.
+func (n *connNode) Weight() float64 {
+	return nodeWeight(n.Node)
+}
+
+// PickElapsed implements selector.WeightedNode.
+func (n *connNode) PickElapsed() time.Duration {
+	return time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&n.lastPick))
+}
+
+// Raw implements selector.WeightedNode.
+func (n *connNode) Raw() selector.Node {
+	return n.Node
+}
+
+func nodeWeight(n selector.Node) float64 {
+	if w := n.InitialWeight(); w != nil && *w > 0 {
+		return float64(*w)
+	}
+	return defaultWeight
+}
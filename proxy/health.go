@@ -0,0 +1,358 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/omalloc/proxy/selector"
+
+	"github.com/omalloc/tavern/contrib/log"
+)
+
+const (
+	ejectReasonPassive = "passive"
+	ejectReasonActive  = "active"
+)
+
+// PassiveCheck ejects a node after MaxFails consecutive failures
+// (timeout, network error or 5xx) within FailWindow, for CoolDown.
+type PassiveCheck struct {
+	MaxFails   int
+	FailWindow time.Duration
+	CoolDown   time.Duration
+}
+
+// ActiveCheck probes every node with an HTTP GET on Path each Interval.
+type ActiveCheck struct {
+	Path               string
+	Host               string
+	Interval           time.Duration
+	Timeout            time.Duration
+	ExpectStatus       []int
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+// NodeStatus is the health state of an upstream node.
+type NodeStatus struct {
+	Scheme       string    `json:"scheme"`
+	Addr         string    `json:"addr"`
+	Weight       int64     `json:"weight"`
+	Healthy      bool      `json:"healthy"`
+	Reason       string    `json:"reason,omitempty"` // passive, active
+	Fails        int       `json:"fails"`
+	EjectedUntil time.Time `json:"ejected_until,omitzero"`
+	LastError    string    `json:"last_error,omitempty"`
+	LastProbe    time.Time `json:"last_probe,omitzero"`
+}
+
+type nodeHealth struct {
+	node selector.Node
+
+	// passive
+	fails        int
+	firstFail    time.Time
+	ejectedUntil time.Time
+
+	// active
+	probeOK    int
+	probeFails int
+	down       bool
+	lastProbe  time.Time
+
+	lastErr string
+}
+
+func (h *nodeHealth) ejected(now time.Time) (bool, string) {
+	if h.down {
+		return true, ejectReasonActive
+	}
+	if now.Before(h.ejectedUntil) {
+		return true, ejectReasonPassive
+	}
+	return false, ""
+}
+
+// healthChecker tracks ejection state of upstream nodes and
+// feeds healthy nodes back into the selector.
+type healthChecker struct {
+	mu      sync.Mutex
+	nodes   []selector.Node
+	states  map[string]*nodeHealth
+	passive *PassiveCheck
+	active  *ActiveCheck
+	apply   func(nodes []selector.Node)
+	stop    chan struct{}
+	once    sync.Once
+}
+
+func newHealthChecker(apply func(nodes []selector.Node)) *healthChecker {
+	return &healthChecker{
+		states: make(map[string]*nodeHealth),
+		apply:  apply,
+		stop:   make(chan struct{}),
+	}
+}
+
+// update replaces all nodes, keeps the state of unchanged addresses.
+func (hc *healthChecker) update(nodes []selector.Node) {
+	hc.mu.Lock()
+	states := make(map[string]*nodeHealth, len(nodes))
+	for _, n := range nodes {
+		if st, ok := hc.states[n.Address()]; ok {
+			st.node = n
+			states[n.Address()] = st
+			continue
+		}
+		states[n.Address()] = &nodeHealth{node: n}
+	}
+	for addr := range hc.states {
+		if _, ok := states[addr]; !ok {
+			upstreamNodeEjected.DeleteLabelValues(addr)
+		}
+	}
+	hc.nodes = nodes
+	hc.states = states
+	hc.mu.Unlock()
+
+	hc.rebalance()
+}
+
+// rebalance applies the healthy nodes to selector.
+// 全部节点不可用时放行所有节点 (fail-open), 避免直接返回 no available node.
+func (hc *healthChecker) rebalance() {
+	now := time.Now()
+
+	hc.mu.Lock()
+	healthy := make([]selector.Node, 0, len(hc.nodes))
+	for _, n := range hc.nodes {
+		st := hc.states[n.Address()]
+		if ejected, _ := st.ejected(now); ejected {
+			upstreamNodeEjected.WithLabelValues(n.Address()).Set(1)
+			continue
+		}
+		upstreamNodeEjected.WithLabelValues(n.Address()).Set(0)
+		healthy = append(healthy, n)
+	}
+	if len(healthy) == 0 && len(hc.nodes) > 0 {
+		log.Warnf("all upstream nodes are ejected, fail-open to %d nodes", len(hc.nodes))
+		healthy = hc.nodes
+	}
+	// apply under lock, keep the order of concurrent rebalance.
+	hc.apply(healthy)
+	hc.mu.Unlock()
+}
+
+// report records a passive result of the request sent to addr.
+func (hc *healthChecker) report(addr string, err error) {
+	if hc.passive == nil {
+		return
+	}
+
+	now := time.Now()
+
+	hc.mu.Lock()
+	st, ok := hc.states[addr]
+	// custom upstream addr (not in upstream.address)
+	if !ok {
+		hc.mu.Unlock()
+		return
+	}
+
+	if err == nil {
+		st.fails = 0
+		hc.mu.Unlock()
+		return
+	}
+
+	if st.fails == 0 || now.Sub(st.firstFail) > hc.passive.FailWindow {
+		st.fails = 0
+		st.firstFail = now
+	}
+	st.fails++
+	st.lastErr = err.Error()
+
+	if st.fails < hc.passive.MaxFails || now.Before(st.ejectedUntil) {
+		hc.mu.Unlock()
+		return
+	}
+
+	st.fails = 0
+	st.ejectedUntil = now.Add(hc.passive.CoolDown)
+	hc.mu.Unlock()
+
+	log.Warnf("upstream %s ejected for %s: %s", addr, hc.passive.CoolDown, err)
+	upstreamEjectionsTotal.WithLabelValues(addr, ejectReasonPassive).Inc()
+	hc.rebalance()
+
+	// cool-down expired, put it back.
+	time.AfterFunc(hc.passive.CoolDown, func() {
+		select {
+		case <-hc.stop:
+		default:
+			hc.rebalance()
+		}
+	})
+}
+
+// probe runs active health check until closed.
+func (hc *healthChecker) probe(find func(addr string) *http.Client) {
+	ticker := time.NewTicker(hc.active.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hc.stop:
+			return
+		case <-ticker.C:
+			hc.mu.Lock()
+			nodes := slices.Clone(hc.nodes)
+			hc.mu.Unlock()
+
+			var wg sync.WaitGroup
+			for _, n := range nodes {
+				wg.Add(1)
+				go func(n selector.Node) {
+					defer wg.Done()
+					hc.probeResult(n.Address(), hc.probeNode(find(n.Address()), n))
+				}(n)
+			}
+			wg.Wait()
+		}
+	}
+}
+
+func (hc *healthChecker) probeNode(client *http.Client, n selector.Node) error {
+	ctx, cancel := context.WithTimeout(context.Background(), hc.active.Timeout)
+	defer cancel()
+
+	scheme := n.Scheme()
+	if scheme != "https" {
+		scheme = "http"
+	}
+
+	host := hc.active.Host
+	if host == "" {
+		host = n.Address()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s%s", scheme, host, hc.active.Path), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "tavern-health-check")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+
+	if len(hc.active.ExpectStatus) > 0 {
+		if !slices.Contains(hc.active.ExpectStatus, resp.StatusCode) {
+			return fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		return nil
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (hc *healthChecker) probeResult(addr string, err error) {
+	hc.mu.Lock()
+	st, ok := hc.states[addr]
+	if !ok {
+		hc.mu.Unlock()
+		return
+	}
+
+	st.lastProbe = time.Now()
+	changed := false
+	if err != nil {
+		st.lastErr = err.Error()
+		st.probeOK = 0
+		st.probeFails++
+		if !st.down && st.probeFails >= hc.active.UnhealthyThreshold {
+			st.down, changed = true, true
+		}
+	} else {
+		st.probeFails = 0
+		st.probeOK++
+		if st.down && st.probeOK >= hc.active.HealthyThreshold {
+			st.down, changed = false, true
+		}
+	}
+	down := st.down
+	hc.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if down {
+		log.Warnf("upstream %s active health check failed: %s", addr, err)
+		upstreamEjectionsTotal.WithLabelValues(addr, ejectReasonActive).Inc()
+	} else {
+		log.Infof("upstream %s active health check recovered", addr)
+	}
+	hc.rebalance()
+}
+
+// status returns the health state of all nodes.
+func (hc *healthChecker) status() []NodeStatus {
+	now := time.Now()
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	ret := make([]NodeStatus, 0, len(hc.nodes))
+	for _, n := range hc.nodes {
+		st := hc.states[n.Address()]
+		ejected, reason := st.ejected(now)
+		ns := NodeStatus{
+			Scheme:    n.Scheme(),
+			Addr:      n.Address(),
+			Healthy:   !ejected,
+			Reason:    reason,
+			Fails:     st.fails,
+			LastError: st.lastErr,
+			LastProbe: st.lastProbe,
+		}
+		if w := n.InitialWeight(); w != nil {
+			ns.Weight = *w
+		}
+		if reason == ejectReasonPassive {
+			ns.EjectedUntil = st.ejectedUntil
+		}
+		ret = append(ret, ns)
+	}
+	return ret
+}
+
+func (hc *healthChecker) close() {
+	hc.once.Do(func() {
+		close(hc.stop)
+	})
+}
+
+// isUpstreamFailure reports whether the upstream result counts as a passive failure.
+func isUpstreamFailure(resp *http.Response, err error) error {
+	if err != nil {
+		// canceled by client, not the upstream fault.
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return err
+	}
+	if resp != nil && resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("upstream status %d", resp.StatusCode)
+	}
+	return nil
+}
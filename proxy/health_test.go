@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPassiveEjection(t *testing.T) {
+	var applied []selector.Node
+	hc := newHealthChecker(func(nodes []selector.Node) {
+		applied = nodes
+	})
+	hc.passive = &PassiveCheck{MaxFails: 2, FailWindow: time.Second, CoolDown: 50 * time.Millisecond}
+	defer hc.close()
+
+	hc.update([]selector.Node{
+		selector.NewNode("http", "10.0.0.1:80", nil),
+		selector.NewNode("http", "10.0.0.2:80", nil),
+	})
+	assert.Len(t, applied, 2)
+
+	errTimeout := errors.New("timeout")
+	hc.report("10.0.0.1:80", errTimeout)
+	hc.report("10.0.0.1:80", nil) // success resets consecutive fails
+	hc.report("10.0.0.1:80", errTimeout)
+	assert.Len(t, applied, 2)
+
+	hc.report("10.0.0.1:80", errTimeout)
+	hc.mu.Lock()
+	assert.Len(t, applied, 1)
+	assert.Equal(t, "10.0.0.2:80", applied[0].Address())
+	hc.mu.Unlock()
+	assert.False(t, hc.status()[0].Healthy)
+
+	// cool-down expired
+	assert.Eventually(t, func() bool {
+		hc.mu.Lock()
+		defer hc.mu.Unlock()
+		return len(applied) == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestPassiveFailOpen(t *testing.T) {
+	var applied []selector.Node
+	hc := newHealthChecker(func(nodes []selector.Node) {
+		applied = nodes
+	})
+	hc.passive = &PassiveCheck{MaxFails: 1, FailWindow: time.Second, CoolDown: time.Minute}
+	defer hc.close()
+
+	hc.update([]selector.Node{selector.NewNode("http", "10.0.0.1:80", nil)})
+	hc.report("10.0.0.1:80", errors.New("502"))
+
+	// all nodes ejected, keep serving.
+	assert.Len(t, applied, 1)
+	assert.False(t, hc.status()[0].Healthy)
+}
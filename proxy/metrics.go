@@ -30,6 +30,22 @@ var (
 		Name:      "collapse_requests_total",
 		Help:      "The total number of singleflight-collapsed upstream requests",
 	}, []string{"result"})
+
+	// upstreamNodeEjected reports whether the upstream node is ejected by health check (1 ejected, 0 healthy).
+	// Labels: addr
+	upstreamNodeEjected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: pkgmetrics.Namespace,
+		Name:      "upstream_node_ejected",
+		Help:      "Whether the upstream node is ejected by health check",
+	}, []string{"addr"})
+
+	// upstreamEjectionsTotal counts upstream node ejections by reason.
+	// Labels: addr, reason (passive/active)
+	upstreamEjectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: pkgmetrics.Namespace,
+		Name:      "upstream_ejections_total",
+		Help:      "The total number of upstream node ejections by health check",
+	}, []string{"addr", "reason"})
 )
 
 func init() {
@@ -37,5 +53,7 @@ func init() {
 		upstreamRequestDuration,
 		upstreamErrorsTotal,
 		collapseRequestsTotal,
+		upstreamNodeEjected,
+		upstreamEjectionsTotal,
 	)
 }
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/omalloc/proxy/selector"
+)
+
+// ParseNode parses an `upstream.address` entry into a selector node.
+//
+// nginx-style parameters may follow the address, separated by spaces:
+//
+//	http://10.0.0.1:8000 weight=5
+//	unix:///tmp/gw.sock
+func ParseNode(addr string) (selector.Node, error) {
+	fields := strings.Fields(addr)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty upstream address")
+	}
+
+	u, err := url.Parse(fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	host := u.Host
+	if u.Scheme == "unix" {
+		host = "unix://" + u.Path
+	}
+	if host == "" {
+		return nil, fmt.Errorf("invalid upstream address %q", fields[0])
+	}
+
+	weight := 1
+	for _, param := range fields[1:] {
+		k, v, _ := strings.Cut(param, "=")
+		switch k {
+		case "weight":
+			weight, err = strconv.Atoi(v)
+			if err != nil || weight <= 0 {
+				return nil, fmt.Errorf("invalid weight %q of upstream address %q", v, fields[0])
+			}
+		default:
+			return nil, fmt.Errorf("unknown parameter %q of upstream address %q", param, fields[0])
+		}
+	}
+
+	return selector.NewNode(u.Scheme, host, selector.RawMetadata("weight", strconv.Itoa(weight))), nil
+}
@@ -1,20 +1,21 @@
 package proxy
 
 import (
-	"compress/gzip"
 	"context"
 	"io"
 	"net"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/andybalholm/brotli"
 	"github.com/omalloc/proxy/selector"
 	"github.com/omalloc/proxy/selector/node/direct"
 	"github.com/omalloc/proxy/selector/random"
 
+	"github.com/omalloc/tavern/pkg/traces"
+	"github.com/omalloc/tavern/proxy/balancer"
 	"github.com/omalloc/tavern/proxy/singleflight"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -29,6 +30,7 @@ type Proxy interface {
 	Do(req *http.Request, collapsed bool, waitTimeout time.Duration) (*http.Response, error)
 	DoLoopback(req *http.Request) (*http.Response, error)
 	Apply(nodes []selector.Node)
+	Health() []NodeStatus
 }
 
 type ReverseProxy struct {
@@ -43,6 +45,9 @@ type ReverseProxy struct {
 	clientMap map[string]*http.Client // addr -> http.Client
 	dialer    *net.Dialer
 	flight    *singleflight.Group
+
+	initialNodes []selector.Node
+	health       *healthChecker
 }
 
 type Option func(*ReverseProxy)
@@ -59,47 +64,72 @@ func New(opts ...Option) *ReverseProxy {
 		selector: random.NewBuilder().Build(), // default algorithm is random
 		flight:   &singleflight.Group{},
 	}
+	r.health = newHealthChecker(func(nodes []selector.Node) {
+		r.selector.Apply(nodes)
+	})
 
 	for _, opt := range opts {
 		opt(r)
 	}
+
+	if len(r.initialNodes) > 0 {
+		r.health.update(r.initialNodes)
+	}
+	if r.health.active != nil {
+		go r.health.probe(r.find)
+	}
 	return r
 }
 
 func (r *ReverseProxy) Do(req *http.Request, collapsed bool, waitTimeout time.Duration) (*http.Response, error) {
-	current, done, err := r.selector.Select(req.Context())
+	ctx := req.Context()
+	// consistent-hash 默认以请求 URL 作为 hash key
+	if _, ok := balancer.HashKeyFromContext(ctx); !ok {
+		ctx = balancer.WithHashKey(ctx, req.URL.String())
+	}
+
+	current, done, err := r.selector.Select(ctx)
 	if err != nil {
 		return nil, selector.ErrNoAvailable
 	}
 
 	upAddr := current.Address()
-
-	defer done(req.Context(), selector.DoneInfo{
-		Err:           err,
-		BytesSent:     true,
-		BytesReceived: true,
-	})
-
 	client := r.find(upAddr)
+	traces.FromContext(ctx).UpstreamAddr = upAddr
 
 	trackedDo := func() (*http.Response, error) {
 		start := time.Now()
 		resp, doErr := client.Do(req)
 		upstreamRequestDuration.With(prometheus.Labels{"addr": upAddr}).Observe(time.Since(start).Seconds())
+		r.health.report(upAddr, isUpstreamFailure(resp, doErr))
 		if doErr != nil {
 			upstreamErrorsTotal.With(prometheus.Labels{"addr": upAddr, "error_type": classifyError(doErr)}).Inc()
+			done(ctx, selector.DoneInfo{Err: doErr})
+			return resp, doErr
 		}
-		return resp, doErr
+
+		// 连接占用直到 body 读取完成, least-conn 依赖该计数
+		resp.Body = &doneBody{ReadCloser: resp.Body, done: func() {
+			done(ctx, selector.DoneInfo{BytesSent: true, BytesReceived: true})
+		}}
+		return resp, nil
 	}
 
 	if !collapsed {
 		return trackedDo()
 	}
 
+	var executed atomic.Bool
 	ret := <-r.flight.DoChan(onceKey(req), waitTimeout, func() (*http.Response, error) {
+		executed.Store(true)
 		return trackedDo()
 	})
 
+	// 合并请求未实际回源, 释放本次选中的节点
+	if !executed.Load() {
+		done(ctx, selector.DoneInfo{Err: ret.Err})
+	}
+
 	if ret.Err != nil {
 		return ret.Val, ret.Err
 	}
@@ -162,32 +192,17 @@ func (r *ReverseProxy) find(addr string) *http.Client {
 	return client
 }
 
-func (r *ReverseProxy) uncompress(resp *http.Response, err error) (*http.Response, error) {
-	if err != nil {
-		return resp, err
-	}
+// doneBody reports the selector done callback once the body has been closed.
+type doneBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
 
-	encoding := resp.Header.Get("Content-Encoding")
-	switch encoding {
-	case "gzip":
-		reader, err := gzip.NewReader(resp.Body)
-		if err != nil {
-			return resp, err
-		}
-		resp.ContentLength = -1
-		resp.Body = reader
-	case "br":
-		reader := brotli.NewReader(resp.Body)
-		resp.ContentLength = -1
-		resp.Body = &struct {
-			io.Closer
-			io.Reader
-		}{
-			Closer: resp.Body,
-			Reader: reader,
-		}
-	}
-	return resp, err
+func (b *doneBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
 }
 
 func onceKey(req *http.Request) string {
@@ -198,15 +213,65 @@ func onceKey(req *http.Request) string {
 	return sb.String()
 }
 
-// Apply is apply all nodes when any changes happen
+// Apply is apply all nodes when any changes happen,
+// ejected nodes are filtered out before reaching the selector.
 func (r *ReverseProxy) Apply(nodes []selector.Node) {
-	r.selector.Apply(nodes)
+	r.health.update(nodes)
+}
+
+// Health returns the health state of all upstream nodes.
+func (r *ReverseProxy) Health() []NodeStatus {
+	return r.health.status()
+}
+
+// Close stops the active health check.
+func (r *ReverseProxy) Close() error {
+	r.health.close()
+	return nil
 }
 
 // WithInitialNodes is set initial nodes
 func WithInitialNodes(nodes []selector.Node) Option {
 	return func(r *ReverseProxy) {
-		r.selector.Apply(nodes)
+		r.initialNodes = nodes
+	}
+}
+
+// WithPassiveCheck enables passive health check.
+func WithPassiveCheck(c PassiveCheck) Option {
+	return func(r *ReverseProxy) {
+		if c.MaxFails <= 0 {
+			c.MaxFails = 3
+		}
+		if c.FailWindow <= 0 {
+			c.FailWindow = 10 * time.Second
+		}
+		if c.CoolDown <= 0 {
+			c.CoolDown = 30 * time.Second
+		}
+		r.health.passive = &c
+	}
+}
+
+// WithActiveCheck enables active health check.
+func WithActiveCheck(c ActiveCheck) Option {
+	return func(r *ReverseProxy) {
+		if c.Path == "" {
+			c.Path = "/"
+		}
+		if c.Interval <= 0 {
+			c.Interval = 5 * time.Second
+		}
+		if c.Timeout <= 0 {
+			c.Timeout = 2 * time.Second
+		}
+		if c.HealthyThreshold <= 0 {
+			c.HealthyThreshold = 1
+		}
+		if c.UnhealthyThreshold <= 0 {
+			c.UnhealthyThreshold = 2
+		}
+		r.health.active = &c
 	}
 }
 
@@ -0,0 +1,94 @@
+// Package admin authenticates the requests of internal routes & plugin APIs.
+package admin
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/omalloc/tavern/conf"
+	"github.com/omalloc/tavern/pkg/ipallow"
+)
+
+// probePrefix is reachable without credentials, kubelet & LB probes come from anywhere.
+const probePrefix = "/healthz/"
+
+// loopback is the default allowlist of the dedicated admin listener configured without any credential.
+var loopback = []string{"127.0.0.1", "::1"}
+
+type Authenticator struct {
+	allow *ipallow.List
+	token [32]byte
+	// credentials required, bearer token or verified client certificate
+	required bool
+	hasToken bool
+}
+
+// New creates the Authenticator of `server.admin`.
+func New(c *conf.ServerAdmin) (*Authenticator, error) {
+	allow, err := ipallow.Parse(c.AllowCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	mtls := c.TLS != nil && c.TLS.Enabled && c.TLS.ClientCA != ""
+	required := c.Token != "" || mtls
+
+	// 独立管理端口未配置任何鉴权时只允许本机访问, unix socket 由文件权限保护
+	if c.Addr != "" && !strings.HasSuffix(c.Addr, ".sock") && allow.Len() == 0 && !required {
+		allow, _ = ipallow.Parse(loopback)
+	}
+
+	return &Authenticator{
+		allow:    allow,
+		token:    sha256.Sum256([]byte(c.Token)),
+		required: required,
+		hasToken: c.Token != "",
+	}, nil
+}
+
+// Wrap enforces the CIDR allowlist and then the credentials on every route of next.
+func (a *Authenticator) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, probePrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if a.allow.Len() > 0 && !a.allow.AllowRemote(r.RemoteAddr) {
+			_metricAdminDeniedTotal.WithLabelValues("cidr").Inc()
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		if a.required && !a.verified(r) {
+			_metricAdminDeniedTotal.WithLabelValues("credential").Inc()
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tavern-admin"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *Authenticator) verified(r *http.Request) bool {
+	// mTLS, the chains are only set when verified by `tls.client_ca`
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+
+	if !a.hasToken {
+		return false
+	}
+
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return false
+	}
+
+	// compare the hashes in constant time, the length of token is not leaked.
+	hash := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	return subtle.ConstantTimeCompare(hash[:], a.token[:]) == 1
+}
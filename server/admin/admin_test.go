@@ -0,0 +1,106 @@
+package admin_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/tavern/conf"
+	"github.com/omalloc/tavern/server/admin"
+)
+
+func serve(t *testing.T, c *conf.ServerAdmin, req *http.Request) int {
+	t.Helper()
+
+	auth, err := admin.New(c)
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	auth.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})).ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestToken(t *testing.T) {
+	c := &conf.ServerAdmin{Token: "secret"}
+
+	req := httptest.NewRequest(http.MethodGet, "/plugin/purge/tasks", nil)
+	assert.Equal(t, http.StatusUnauthorized, serve(t, c, req))
+
+	req.Header.Set("Authorization", "Bearer wrong")
+	assert.Equal(t, http.StatusUnauthorized, serve(t, c, req))
+
+	req.Header.Set("Authorization", "Basic c2VjcmV0")
+	assert.Equal(t, http.StatusUnauthorized, serve(t, c, req))
+
+	req.Header.Set("Authorization", "Bearer secret")
+	assert.Equal(t, http.StatusOK, serve(t, c, req))
+
+	// probes need no credentials
+	req = httptest.NewRequest(http.MethodGet, "/healthz/readiness-probe", nil)
+	assert.Equal(t, http.StatusOK, serve(t, c, req))
+}
+
+func TestAllowCIDRs(t *testing.T) {
+	c := &conf.ServerAdmin{AllowCIDRs: []string{"10.0.0.0/8", "fd00::/8"}}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "10.1.2.3:52100"
+	assert.Equal(t, http.StatusOK, serve(t, c, req))
+
+	req.RemoteAddr = "[fd00::1]:52100"
+	assert.Equal(t, http.StatusOK, serve(t, c, req))
+
+	req.RemoteAddr = "[fe80::1]:52100"
+	assert.Equal(t, http.StatusForbidden, serve(t, c, req))
+
+	// the allowlist is checked before the token
+	c.Token = "secret"
+	req.RemoteAddr = "192.168.1.1:52100"
+	req.Header.Set("Authorization", "Bearer secret")
+	assert.Equal(t, http.StatusForbidden, serve(t, c, req))
+
+	_, err := admin.New(&conf.ServerAdmin{AllowCIDRs: []string{"10.0.0.0/33"}})
+	assert.Error(t, err)
+}
+
+func TestLoopbackDefault(t *testing.T) {
+	// dedicated listener without any credential
+	c := &conf.ServerAdmin{Addr: "0.0.0.0:9090"}
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)
+	req.RemoteAddr = "127.0.0.1:52100"
+	assert.Equal(t, http.StatusOK, serve(t, c, req))
+
+	req.RemoteAddr = "[::1]:52100"
+	assert.Equal(t, http.StatusOK, serve(t, c, req))
+
+	req.RemoteAddr = "10.1.2.3:52100"
+	assert.Equal(t, http.StatusForbidden, serve(t, c, req))
+
+	// the token opens the listener to any source
+	c.Token = "secret"
+	req.Header.Set("Authorization", "Bearer secret")
+	assert.Equal(t, http.StatusOK, serve(t, c, req))
+
+	// unix socket peers have no IP
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "@"
+	assert.Equal(t, http.StatusOK, serve(t, &conf.ServerAdmin{Addr: "/run/tavern/admin.sock"}, req))
+}
+
+func TestClientCertificate(t *testing.T) {
+	c := &conf.ServerAdmin{TLS: &conf.ServerTLS{Enabled: true, ClientCA: "/etc/tavern/admin-ca.pem"}}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.TLS = &tls.ConnectionState{}
+	assert.Equal(t, http.StatusUnauthorized, serve(t, c, req))
+
+	req.TLS.VerifiedChains = [][]*x509.Certificate{{{}}}
+	assert.Equal(t, http.StatusOK, serve(t, c, req))
+}
@@ -0,0 +1,19 @@
+package admin
+
+import (
+	pkgmetrics "github.com/omalloc/tavern/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// _metricAdminDeniedTotal counts the rejected requests of internal routes by reason.
+	_metricAdminDeniedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: pkgmetrics.Namespace,
+		Name:      "admin_denied_total",
+		Help:      "The total number of rejected requests of internal routes & plugin APIs",
+	}, []string{"reason"})
+)
+
+func init() {
+	prometheus.MustRegister(_metricAdminDeniedTotal)
+}
@@ -20,16 +20,28 @@ import (
 	"github.com/omalloc/tavern/api/defined/v1/storage/object"
 	"github.com/omalloc/tavern/contrib/log"
 	"github.com/omalloc/tavern/internal/protocol"
+	"github.com/omalloc/tavern/pkg/cachekey"
 	"github.com/omalloc/tavern/pkg/iobuf"
 	"github.com/omalloc/tavern/pkg/iobuf/ioindexes"
 	xhttp "github.com/omalloc/tavern/pkg/x/http"
+	"github.com/omalloc/tavern/pkg/x/http/encodingcontrol"
 	"github.com/omalloc/tavern/proxy"
+	"github.com/omalloc/tavern/proxy/balancer"
 	"github.com/omalloc/tavern/server/middleware"
 	storagev1 "github.com/omalloc/tavern/storage"
 )
 
 const BYPASS = "BYPASS"
 
+// Flight groups for collapsed forwarding at object and chunk level.
+// These mirror Squid's collapsed_forwarding: one origin request
+// serves many waiting clients. They are shared by the caching instance
+// of every listener, the flight key is the object hash.
+var (
+	objectFlight = &ObjectFlightGroup{}
+	chunkFlight  = &ChunkFlightGroup{}
+)
+
 var keyMap = map[string]struct{}{
 	"Content-Range":  {},
 	"Content-Length": {},
@@ -56,8 +68,23 @@ type cachingOption struct {
 	VaryIgnoreKey               []string `json:"vary_ignore_key" yaml:"vary_ignore_key"`
 	Hostname                    string   `json:"hostname" yaml:"hostname"`
 	AsyncFlushChunk             bool     `json:"async_flush_chunk" yaml:"async_flush_chunk"`
+	StaleGracePeriod            Duration `json:"stale_grace_period" yaml:"stale_grace_period"` // 源站故障时过期对象可继续服务的时长 (stale-if-error 缺省值)
+	ForceMemory                 []string `json:"force_memory" yaml:"force_memory"`             // 强制存入内存桶的路径规则, e.g. `*.m3u8`, `/live/*`, 同 X-FS-Mem: 1
+	Listener                    string   `json:"listener,omitempty" yaml:"-"`                  // 由 server 注入, 区分各 listener 的实例
+
+	// cache key rules, the first rule matching host & path wins,
+	// `include_query_in_cache_key` is used when no rule matched.
+	CacheKey []cachekey.Rule `json:"cache_key" yaml:"cache_key"`
+	// caching policy rules overriding the options above, the first rule matching host & path wins.
+	Rules []policyRule `json:"rules" yaml:"rules"`
+	// cache time rules by status code & content type, the first matched rule wins.
+	TTLRules []ttlRule `json:"ttl_rules" yaml:"ttl_rules"`
+	// hosts ignoring the origin `Cache-Control: no-cache / private`, e.g. `*.example.com`.
+	IgnoreNoCacheHosts []string `json:"ignore_no_cache_hosts" yaml:"ignore_no_cache_hosts"`
 	// events.
 	publish func(ctx context.Context, payload event.CacheCompleted) `json:"-" yaml:"-"`
+	// cache key builder of `cache_key` rules.
+	cacheKey *cachekey.Builder `json:"-" yaml:"-"`
 }
 
 func init() {
@@ -82,16 +109,35 @@ func Middleware(c *configv1.Middleware) (middleware.Middleware, func(), error) {
 
 	log.Infof("middleware.caching init slice_size %d", opts.SliceSize)
 
+	cacheKey, err := cachekey.New(opts.CacheKey, opts.IncludeQueryInCacheKey)
+	if err != nil {
+		return nil, middleware.EmptyCleanup, err
+	}
+	opts.cacheKey = cacheKey
+
+	if err := compilePolicy(opts.Rules); err != nil {
+		return nil, middleware.EmptyCleanup, err
+	}
+
+	if err := compileTTL(opts.TTLRules); err != nil {
+		return nil, middleware.EmptyCleanup, err
+	}
+
+	// PURGE / qs 按 storeUrl 查找对象时使用相同的 cache key
+	unregister := cachekey.Register(opts.Listener, cacheKey)
+
+	vary := NewVaryProcessor(
+		WithVaryMaxLimit(opts.VaryLimit),
+		WithVaryIgnoreKeys(opts.VaryIgnoreKey...),
+	)
+
 	processor := NewProcessorChain(
 		// Cache-State
 		NewStateProcessor(),
 		// Cache Prefetch
 		NewPrefetchProcessor(),
 		// Vary
-		NewVaryProcessor(
-			WithVaryMaxLimit(opts.VaryLimit),
-			WithVaryIgnoreKeys(opts.VaryIgnoreKey...),
-		),
+		vary,
 		// ETag/Last-Modified If-Match Validation
 		NewRevalidateProcessor(),
 		// ETag/Last-Modified/ContentLength Changed
@@ -103,6 +149,13 @@ func Middleware(c *configv1.Middleware) (middleware.Middleware, func(), error) {
 		),
 	).fill()
 
+	// cached objects lookup, e.g. multirange serves the cached parts without a round-trip.
+	unregisterLookup := registerLookup(opts.Listener, &lookupContext{opt: opts, vary: vary})
+	cleanup := func() {
+		unregister()
+		unregisterLookup()
+	}
+
 	// register event.
 	opts.publish = event.NewPublish[event.CacheCompleted](
 		event.NewTopicKey[event.CacheCompleted](event.CacheCompletedKey),
@@ -111,13 +164,6 @@ func Middleware(c *configv1.Middleware) (middleware.Middleware, func(), error) {
 	return func(origin http.RoundTripper) http.RoundTripper {
 
 		proxyClient := proxy.GetProxy()
-		store := storagev1.Current()
-
-		// Flight groups for collapsed forwarding at object and chunk level.
-		// These mirror Squid's collapsed_forwarding: one origin request
-		// serves many waiting clients.
-		objectFlight := &ObjectFlightGroup{}
-		chunkFlight := &ChunkFlightGroup{}
 
 		return middleware.RoundTripperFunc(func(req *http.Request) (resp *http.Response, err error) {
 			// only cache GET/HEAD request
@@ -127,6 +173,16 @@ func Middleware(c *configv1.Middleware) (middleware.Middleware, func(), error) {
 				return proxyClient.Do(req, false, time.Millisecond)
 			}
 
+			// storage is handed off to the new process (SIGUSR2 hot upgrade),
+			// the nil store makes the request BYPASS caching.
+			var store storage.Storage
+			if release, ok := storagev1.Acquire(); ok {
+				store = storagev1.Current()
+				defer func() {
+					releaseOnClose(resp, release)
+				}()
+			}
+
 			// find indexdb cache-key has hit/miss.
 			caching, err := processor.preCacheProcessor(proxyClient, store, opts, req)
 
@@ -149,7 +205,15 @@ func Middleware(c *configv1.Middleware) (middleware.Middleware, func(), error) {
 
 			// err to BYPASS caching
 			if err != nil {
-				caching.log.Warnf("Precache processor failed: %v BYPASS", err)
+				switch {
+				case errors.Is(err, errPolicyBypass):
+					caching.log.Debugf("Precache processor matched bypass rule %s", req.URL.Path)
+				case errors.Is(err, errStorageHandoff):
+					// every request of the drain window, counted by tr_tavern_cache_requests_total{cache_status="BYPASS"}
+					caching.log.Debugf("Precache processor storage handed off %s BYPASS", req.URL.Path)
+				default:
+					caching.log.Warnf("Precache processor failed: %v BYPASS", err)
+				}
 				caching.cacheStatus = storage.BYPASS
 				resp, err = caching.doProxy(req, false) // do reverse proxy
 				if err != nil {
@@ -171,10 +235,13 @@ func Middleware(c *configv1.Middleware) (middleware.Middleware, func(), error) {
 			// full MISS — use object-level collapsed forwarding so that
 			// concurrent requests for the same cache object share one
 			// origin fetch (Squid-style collapsed_forwarding).
-			if opts.CollapsedRequest {
-				flightResp, _, flightErr := objectFlight.Do(caching.id.HashStr(), opts.CollapsedRequestWaitTimeout.AsDuration(), func() (*http.Response, error) {
+			if opts.CollapsedRequest && collapsible(req) {
+				flightResp, _, flightErr := objectFlight.Do(objectFlightKey(caching.id, req), opts.CollapsedRequestWaitTimeout.AsDuration(), func() (*http.Response, error) {
 					r, e := caching.doProxy(req, false)
 					if e != nil {
+						if stale, ok := caching.serveStaleOnError(req, e); ok {
+							return stale, nil
+						}
 						return nil, e
 					}
 					return processor.postCacheProcessor(caching, req, r)
@@ -189,6 +256,10 @@ func Middleware(c *configv1.Middleware) (middleware.Middleware, func(), error) {
 			// full MISS (collapsed forwarding disabled)
 			resp, err = caching.doProxy(req, false)
 			if err != nil {
+				if stale, ok := caching.serveStaleOnError(req, err); ok {
+					closeBody(resp)
+					return stale, nil
+				}
 				return nil, err
 			}
 
@@ -196,7 +267,31 @@ func Middleware(c *configv1.Middleware) (middleware.Middleware, func(), error) {
 			return
 		})
 
-	}, middleware.EmptyCleanup, nil
+	}, cleanup, nil
+}
+
+type noCollapseKey struct{}
+
+// WithoutCollapse returns the context of the request skipping the collapsed forwarding, e.g. the
+// parallel part sub-requests of multirange, the shared body fans out in lockstep and the parts
+// consumed in order by the same client would block each other.
+func WithoutCollapse(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCollapseKey{}, true)
+}
+
+// collapsible reports whether req may join the collapsed forwarding.
+func collapsible(req *http.Request) bool {
+	skip, _ := req.Context().Value(noCollapseKey{}).(bool)
+	return !skip
+}
+
+// objectFlightKey returns the collapsed forwarding key of req, the waiters share the leader's
+// response body so the requests of different ranges must not join the same flight.
+func objectFlightKey(id *object.ID, req *http.Request) string {
+	if rawRange := req.Header.Get("Range"); rawRange != "" {
+		return id.HashStr() + "#" + rawRange
+	}
+	return id.HashStr()
 }
 
 // respondFromCache assembles a response from cached chunks for a cache HIT.
@@ -214,6 +309,9 @@ func (c *Caching) respondFromCache(req *http.Request) (*http.Response, error) {
 	}
 
 	c.markCacheStatus(rng.Start, rng.End)
+	if c.stale {
+		c.cacheStatus = storage.CacheStale
+	}
 
 	resp, err := c.lazilyRespond(req, rng.Start, rng.End)
 	if err != nil {
@@ -321,7 +419,7 @@ func (c *Caching) getUpstreamReader(fromByte, toByte uint64, async bool) (io.Rea
 	// and share the union response body (io.MultiWriter fan-out + RangeReader
 	// trimming). This mirrors Squid's collapsed_forwarding at the chunk
 	// level, with automatic range union.
-	if c.chunkFlight != nil && c.opt.CollapsedRequest && c.id != nil {
+	if c.chunkFlight != nil && c.opt.CollapsedRequest && c.id != nil && collapsible(c.req) {
 		reader, _, err := c.chunkFlight.Do(c.id.HashStr(), fromByte, toByte,
 			c.opt.CollapsedRequestWaitTimeout.AsDuration(), doSubRequest)
 		// Both leader and shared callers are partial hits: at least one
@@ -359,6 +457,9 @@ func (c *Caching) doProxy(req *http.Request, subRequest bool) (*http.Response, e
 		return nil, fmt.Errorf("pre-request failed: %w", err)
 	}
 
+	// consistent-hash upstream balancing on cache key
+	proxyReq = proxyReq.WithContext(balancer.WithHashKey(proxyReq.Context(), c.id.Path()))
+
 	c.log.Debugf("doProxy begin with %s", proxyReq.URL.String())
 
 	resp, err := c.proxyClient.Do(proxyReq, c.opt.CollapsedRequest, c.opt.CollapsedRequestWaitTimeout.AsDuration())
@@ -366,6 +467,11 @@ func (c *Caching) doProxy(req *http.Request, subRequest bool) (*http.Response, e
 		return resp, err
 	}
 
+	// compress the `Accept-Encoding` variant before it is saved, see middleware compress `cache_variants`
+	if transform, ok := encodingcontrol.TransformFromContext(c.req.Context()); ok {
+		transform(proxyReq, resp)
+	}
+
 	c.log.Debugf("doProxy upstream resp content-length %d content-range %s etag %q lm %q",
 		resp.ContentLength, resp.Header.Get("Content-Range"),
 		resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"))
@@ -377,6 +483,12 @@ func (c *Caching) doProxy(req *http.Request, subRequest bool) (*http.Response, e
 
 	var proxyErr error
 
+	// origin failure while revalidating, keep the stale object (RFC 5861 stale-if-error)
+	if c.revalidate && resp.StatusCode >= http.StatusInternalServerError && c.canServeStaleIfError() {
+		closeBody(resp)
+		return nil, fmt.Errorf("upstream returns error status: %d", resp.StatusCode)
+	}
+
 	// handle redirect caching
 	if resp.StatusCode == http.StatusFound || resp.StatusCode == http.StatusMovedPermanently {
 		// origin response
@@ -417,7 +529,7 @@ func (c *Caching) doProxy(req *http.Request, subRequest bool) (*http.Response, e
 		c.md = &object.Metadata{
 			ID:          c.id,
 			Headers:     make(http.Header),
-			BlockSize:   c.opt.SliceSize, // iobuf.BitBlock,
+			BlockSize:   c.policy.sliceSize(c.opt.SliceSize), // iobuf.BitBlock,
 			Parts:       bitmap.Bitmap{},
 			Size:        respRange.ObjSize,
 			Code:        http.StatusOK,
@@ -427,7 +539,8 @@ func (c *Caching) doProxy(req *http.Request, subRequest bool) (*http.Response, e
 	}
 
 	// parsed cache-control header
-	expiredAt, cacheable := xhttp.ParseCacheTime(protocol.ProtocolCacheTime, resp.Header)
+	expiredAt, cacheable := c.opt.cacheTime(c.req, resp)
+	expiredAt, cacheable = c.policy.cacheTime(resp, expiredAt, cacheable)
 
 	// expire time
 	c.md.ExpiresAt = now.Add(expiredAt).Unix()
@@ -452,7 +565,11 @@ func (c *Caching) doProxy(req *http.Request, subRequest bool) (*http.Response, e
 
 			// Caching is disabled
 			// restoring the default behavior for error codes.
-			if resp.Header.Get(protocol.InternalCacheErrCode) != protocol.FlagOn {
+			// `ttl_rules` 显式列出该状态码且缓存时间不为 0 时同样允许缓存
+			allowed := resp.Header.Get(protocol.InternalCacheErrCode) == protocol.FlagOn ||
+				c.policy.cacheErrorCode(statusCode) ||
+				(c.cacheable && c.opt.cacheErrorCode(resp))
+			if !allowed {
 				c.cacheable = false
 
 				copiedHeaders := make(http.Header)
@@ -467,6 +584,9 @@ func (c *Caching) doProxy(req *http.Request, subRequest bool) (*http.Response, e
 
 		// `cacheable` means can write to cache storage
 		if c.cacheable {
+			// object forced into memory bucket, fallback to disk when it does not fit.
+			c.settleMemoryBucket(respRange.ObjSize)
+
 			// flushbuffer 文件从这里写出到 bucket / disk
 			flushBuffer, cleanup := c.flushbufferSlice(respRange)
 
@@ -11,6 +11,7 @@ import (
 	"github.com/omalloc/tavern/api/defined/v1/storage"
 	"github.com/omalloc/tavern/api/defined/v1/storage/object"
 	"github.com/omalloc/tavern/contrib/log"
+	"github.com/omalloc/tavern/pkg/cachekey"
 	"github.com/omalloc/tavern/proxy"
 	"github.com/omalloc/tavern/storage/bucket/memory"
 	"github.com/omalloc/tavern/storage/sharedkv"
@@ -27,11 +28,14 @@ func makebuf(size int) []byte {
 	return buf
 }
 
+// includeQueryKeys is the legacy `include_query_in_cache_key: true` key.
+var includeQueryKeys, _ = cachekey.New(nil, true)
+
 func Test_getContents(t *testing.T) {
 	memoryBucket, _ := memory.New(&storage.BucketConfig{}, sharedkv.NewEmpty())
 
 	req, _ := http.NewRequestWithContext(t.Context(), http.MethodGet, "http://www.example.com/path/to/1.apk", nil)
-	objectID, _ := newObjectIDFromRequest(req, "", true)
+	objectID, _ := newObjectIDFromRequest(req, "", includeQueryKeys)
 	c := &Caching{
 		log:       log.NewHelper(log.GetLogger()),
 		processor: mockProcessorChain(),
@@ -100,7 +104,7 @@ func Test_getContents_lastChunkSizeMismatch(t *testing.T) {
 
 	req, _ := http.NewRequestWithContext(t.Context(), http.MethodGet, "http://www.example.com/path/to/2.apk", nil)
 	req.Header.Set("Range", "bytes=524288-1672863")
-	objectID, _ := newObjectIDFromRequest(req, "", true)
+	objectID, _ := newObjectIDFromRequest(req, "", includeQueryKeys)
 
 	blockSize := uint64(524288)
 	totalSize := blockSize*3 + 100000 // 3 full chunks + 1 partial last chunk (100000 bytes)
@@ -50,7 +50,7 @@ func (f *fillRange) Lookup(c *Caching, req *http.Request) (bool, error) {
 // PreRequest implements [Processor].
 func (f *fillRange) PreRequest(c *Caching, req *http.Request) (*http.Request, error) {
 	rawRange := req.Header.Get("Range")
-	if rawRange == "" || f.fillRangePercent == 0 {
+	if rawRange == "" || c.policy.fillRangePercent(f.fillRangePercent) == 0 {
 		return req, nil
 	}
 	// HEAD request do not need to fill range
@@ -132,20 +132,21 @@ func (f *fillRange) fill(c *Caching, req *http.Request, rawRange string) *http.R
 		rawEnd:   0,
 	}
 
-	fp := parseFillPercent(req.Header, f.fillRangePercent)
+	fp := parseFillPercent(req.Header, c.policy.fillRangePercent(f.fillRangePercent))
+	chunkSize := c.policy.sliceSize(f.chunkSize)
 
-	maxFillSize := f.chunkSize * fp / 100
-	minFillSize := f.chunkSize * (100 - fp) / 100
+	maxFillSize := chunkSize * fp / 100
+	minFillSize := chunkSize * (100 - fp) / 100
 
 	// Range Start
-	fill.newStart = int((uint64(rng.Start) / f.chunkSize) * f.chunkSize)
+	fill.newStart = int((uint64(rng.Start) / chunkSize) * chunkSize)
 	fill.rawStart = int(rng.Start)
 	if fill.rawStart-fill.newStart > int(maxFillSize) {
 		fill.newStart = fill.rawStart
 	}
 
 	// Range End
-	fill.newEnd = int((uint64(rng.End)/f.chunkSize+1)*f.chunkSize - 1)
+	fill.newEnd = int((uint64(rng.End)/chunkSize+1)*chunkSize - 1)
 	fill.rawEnd = int(rng.End)
 	if fill.newEnd-fill.rawEnd > int(maxFillSize) {
 		fill.newEnd = fill.rawEnd
@@ -158,7 +159,7 @@ func (f *fillRange) fill(c *Caching, req *http.Request, rawRange string) *http.R
 	if (fill.rawEnd >= 0 && fill.rawEnd < fill.rawStart) || (fill.newEnd >= 0 && fill.newEnd < fill.newStart) {
 		return req
 	}
-	if (fill.newEnd-fill.newStart <= int(f.chunkSize) && fill.rawStart-fill.newStart+fill.newEnd-fill.rawEnd > int(maxFillSize)) ||
+	if (fill.newEnd-fill.newStart <= int(chunkSize) && fill.rawStart-fill.newStart+fill.newEnd-fill.rawEnd > int(maxFillSize)) ||
 		(fill.rawEnd-fill.rawStart+1) < int(minFillSize) {
 		fill.newStart = fill.rawStart
 		fill.newEnd = fill.rawEnd
@@ -0,0 +1,256 @@
+package caching
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+	"github.com/omalloc/tavern/contrib/log"
+	"github.com/omalloc/tavern/internal/protocol"
+	"github.com/omalloc/tavern/pkg/iobuf"
+	"github.com/omalloc/tavern/pkg/traces"
+	xhttp "github.com/omalloc/tavern/pkg/x/http"
+	"github.com/omalloc/tavern/pkg/x/http/cachecontrol"
+	storagev1 "github.com/omalloc/tavern/storage"
+)
+
+// ErrChunkNotCached the chunk of the range is missing in the bucket.
+var ErrChunkNotCached = errors.New("chunk not cached")
+
+// lookupContext is the cache key, policy and vary rules of the caching middleware used by Lookup.
+type lookupContext struct {
+	opt  *cachingOption
+	vary *VaryProcessor
+}
+
+// lookups holds the lookup context of each caching middleware keyed by listener,
+// the middlewares in front of caching look up with the listener of their own chain.
+var lookups sync.Map // map[string]*lookupContext
+
+// registerLookup registers the lookup context of the caching middleware on listener,
+// the returned func unregisters it.
+func registerLookup(listener string, lc *lookupContext) func() {
+	lookups.Store(listener, lc)
+	return func() {
+		lookups.CompareAndDelete(listener, lc)
+	}
+}
+
+// CachedObject is a fresh object found in the storage index by Lookup,
+// the cached ranges are read from the chunk files directly.
+type CachedObject struct {
+	id      *object.ID
+	md      *object.Metadata
+	bucket  storage.Bucket
+	opt     *cachingOption
+	release func()
+}
+
+// Lookup finds the fresh cached object of req with the cache key, policy and vary rules of the
+// caching middleware on listener, it never goes upstream nor touches the object.
+// It returns false when the caching middleware of listener is not initialized, the request is
+// conditional (If-Range) or `no-cache`, or the object is not cached, expired or without a known size;
+// those requests are left to the caching middleware. The returned object must be closed.
+func Lookup(listener string, req *http.Request) (*CachedObject, bool) {
+	v, ok := lookups.Load(listener)
+	if !ok || !cachedOnly(req) {
+		return nil, false
+	}
+	lc := v.(*lookupContext)
+
+	policy := lc.opt.matchPolicy(req)
+	if policy.bypass() {
+		return nil, false
+	}
+
+	release, ok := storagev1.Acquire()
+	if !ok {
+		return nil, false
+	}
+
+	obj := lookup(lc, policy, storagev1.Current(), req)
+	if obj == nil {
+		release()
+		return nil, false
+	}
+	obj.release = release
+	return obj, true
+}
+
+// cachedOnly reports whether req can be served from the cached chunks without the validation
+// of the caching middleware, i.e. it is neither If-Range nor `Cache-Control/Pragma: no-cache`.
+func cachedOnly(req *http.Request) bool {
+	if req.Header.Get("If-Range") != "" {
+		return false
+	}
+	if noCache, _ := cachecontrol.Parse(req.Header.Get("Cache-Control")).NoCache(); noCache {
+		return false
+	}
+	return !strings.Contains(strings.ToLower(req.Header.Get("Pragma")), "no-cache")
+}
+
+func lookup(lc *lookupContext, policy *policyRule, store storage.Storage, req *http.Request) *CachedObject {
+	if store == nil {
+		return nil
+	}
+
+	id, err := newObjectIDFromRequest(req, "", lc.opt.cacheKey)
+	if err != nil {
+		return nil
+	}
+
+	bucket := store.Select(req.Context(), id)
+	if bucket == nil {
+		return nil
+	}
+
+	md, _ := bucket.Lookup(req.Context(), id)
+	if md == nil && lc.opt.forceMemory(req) {
+		if mem := selectMemory(req.Context(), store, id); mem != nil {
+			bucket = mem
+			md, _ = bucket.Lookup(req.Context(), id)
+		}
+	}
+	if md == nil {
+		return nil
+	}
+
+	if md.IsVary() {
+		c := &Caching{
+			log:    log.Context(req.Context()),
+			ctx:    req.Context(),
+			opt:    lc.opt,
+			policy: policy,
+			req:    req,
+			id:     id,
+			md:     md,
+			bucket: bucket,
+		}
+		// all the Vary headers are ignored, the caching middleware downgrades the vary index.
+		if len(lc.vary.varyKey(c, md.Headers.Values("Vary")...)) == 0 {
+			return nil
+		}
+		if md = lc.vary.lookup(c, req); md == nil {
+			return nil
+		}
+		id = md.ID
+	}
+
+	// the chunked response without Content-Length cannot be served by ranges.
+	if md.IsChunked() || md.Size == 0 || md.BlockSize == 0 || hasExpired(md) {
+		return nil
+	}
+	if md.Code != http.StatusOK && md.Code != http.StatusPartialContent {
+		return nil
+	}
+
+	return &CachedObject{
+		id:     id,
+		md:     md,
+		bucket: bucket,
+		opt:    lc.opt,
+	}
+}
+
+// Size returns the size of the object.
+func (o *CachedObject) Size() uint64 {
+	return o.md.Size
+}
+
+// Cached reports whether all the chunks of the range [start, end] are cached.
+func (o *CachedObject) Cached(start, end int64) bool {
+	if start < 0 || end < start || uint64(end) >= o.md.Size {
+		return false
+	}
+
+	psize := int64(o.md.BlockSize)
+	return iobuf.FullHit(uint32(start/psize), uint32(end/psize), o.md.Chunks)
+}
+
+// ReadRange reads the cached range [start, end] from the chunk files,
+// ErrChunkNotCached is returned if any chunk file is missing.
+func (o *CachedObject) ReadRange(req *http.Request, start, end int64) (io.ReadCloser, error) {
+	if !o.Cached(start, end) {
+		return nil, ErrChunkNotCached
+	}
+
+	psize := int64(o.md.BlockSize)
+	first, last := uint32(start/psize), uint32(end/psize)
+
+	readers := make([]io.ReadCloser, 0, last-first+1)
+	for idx := first; idx <= last; idx++ {
+		f, wpath, err := o.bucket.ReadChunkFile(req.Context(), o.id, idx)
+		if err != nil {
+			iobuf.AllCloser(readers).Close()
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("%w: %s", ErrChunkNotCached, wpath)
+			}
+			return nil, err
+		}
+
+		if err = o.checkChunkSize(f, idx); err != nil {
+			_ = f.Close()
+			iobuf.AllCloser(readers).Close()
+			return nil, err
+		}
+		readers = append(readers, f)
+	}
+
+	r := iobuf.PartsReadCloser(iobuf.AllCloser(readers), readers...)
+	r = iobuf.SkipReadCloser(r, start-int64(first)*psize)
+	return iobuf.LimitReadCloser(r, end-start+1), nil
+}
+
+func (o *CachedObject) checkChunkSize(f storage.File, idx uint32) error {
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	realSize := o.md.BlockSize
+	if idx == uint32(o.md.Size/realSize) {
+		realSize = o.md.Size % realSize // last chunk size
+	}
+
+	if stat.Size() != int64(realSize) {
+		return fmt.Errorf("file-size(%d) != chunk-size(%d); fileName: %s", stat.Size(), realSize, f.Name())
+	}
+	return nil
+}
+
+// Header returns the cached response headers without the Content-Length and Content-Range,
+// the X-Cache and the trace of req are set with status like the caching middleware.
+func (o *CachedObject) Header(req *http.Request, status storage.CacheStatus) http.Header {
+	header := make(http.Header)
+	xhttp.CopyHeader(header, o.md.Headers)
+	for k := range keyMap {
+		header.Del(k)
+	}
+
+	header.Set("Age", strconv.FormatInt(time.Now().Unix()-o.md.RespUnix, 10))
+	header.Set("Date", time.Unix(o.md.RespUnix, 0).UTC().Format(http.TimeFormat))
+	header.Set("Expires", time.Unix(o.md.ExpiresAt, 0).UTC().Format(http.TimeFormat))
+	header.Set(protocol.ProtocolCacheStatusKey, strings.Join([]string{status.String(), "from", o.opt.Hostname, o.bucket.StoreType(), "(tavern/4.0)"}, " "))
+
+	tr := traces.FromContext(req.Context())
+	tr.CacheStatus = status.String()
+	tr.Bucket = o.bucket.ID()
+	return header
+}
+
+// Close touches the object and releases the storage.
+func (o *CachedObject) Close() {
+	o.bucket.Touch(context.Background(), o.id)
+	if o.release != nil {
+		o.release()
+	}
+}
@@ -0,0 +1,127 @@
+package caching
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	configv1 "github.com/omalloc/tavern/api/defined/v1/middleware"
+	storagev1 "github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+	"github.com/omalloc/tavern/internal/protocol"
+	"github.com/omalloc/tavern/pkg/cachekey"
+	"github.com/omalloc/tavern/server/middleware/internal/middlewaretest"
+)
+
+func storeChunks(t *testing.T, bucket storagev1.Bucket, md *object.Metadata, body []byte, chunks ...uint32) {
+	t.Helper()
+
+	ctx := context.Background()
+	for _, idx := range chunks {
+		w, _, err := bucket.WriteChunkFile(ctx, md.ID, idx)
+		assert.NoError(t, err)
+		start := uint64(idx) * md.BlockSize
+		_, err = w.Write(body[start:min(start+md.BlockSize, uint64(len(body)))])
+		assert.NoError(t, err)
+		assert.NoError(t, w.Close())
+		md.Chunks.Set(idx)
+	}
+	assert.NoError(t, bucket.Store(ctx, md))
+}
+
+func TestLookupCachedObject(t *testing.T) {
+	bucket := middlewaretest.NewMemoryBucket(t)
+
+	cacheKey, err := cachekey.New(nil, false)
+	assert.NoError(t, err)
+	opt := &cachingOption{Hostname: "edge-1", cacheKey: cacheKey}
+	lc := &lookupContext{opt: opt, vary: NewVaryProcessor()}
+	store := &middlewaretest.BucketStorage{Bucket: bucket}
+
+	body := []byte("0123456789")
+	now := time.Now()
+	newMetadata := func(rawURL string, expiresAt time.Time) *object.Metadata {
+		return &object.Metadata{
+			ID:        object.NewID(rawURL),
+			Code:      http.StatusOK,
+			Size:      uint64(len(body)),
+			BlockSize: 4,
+			Headers:   http.Header{"Content-Type": {"application/pdf"}, "Content-Length": {"10"}},
+			RespUnix:  now.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		}
+	}
+	storeChunks(t, bucket, newMetadata("http://www.example.com/a.pdf", now.Add(time.Hour)), body, 0, 1)
+	storeChunks(t, bucket, newMetadata("http://www.example.com/expired.pdf", now.Add(-time.Hour)), body, 0, 1, 2)
+
+	lookupURL := func(rawURL string) *CachedObject {
+		req := httptest.NewRequest(http.MethodGet, rawURL, nil)
+		return lookup(lc, opt.matchPolicy(req), store, req)
+	}
+
+	assert.Nil(t, lookupURL("http://www.example.com/none.pdf"))
+	assert.Nil(t, lookupURL("http://www.example.com/expired.pdf"))
+	assert.Nil(t, lookup(lc, nil, nil, httptest.NewRequest(http.MethodGet, "http://www.example.com/a.pdf", nil)))
+
+	obj := lookupURL("http://www.example.com/a.pdf")
+	assert.NotNil(t, obj)
+	assert.Equal(t, uint64(10), obj.Size())
+	assert.True(t, obj.Cached(0, 7))
+	assert.False(t, obj.Cached(6, 9))
+	assert.False(t, obj.Cached(0, 10))
+
+	req := httptest.NewRequest(http.MethodGet, "http://www.example.com/a.pdf", nil)
+	r, err := obj.ReadRange(req, 2, 6)
+	assert.NoError(t, err)
+	buf, _ := io.ReadAll(r)
+	_ = r.Close()
+	assert.Equal(t, "23456", string(buf))
+
+	_, err = obj.ReadRange(req, 8, 9)
+	assert.ErrorIs(t, err, ErrChunkNotCached)
+
+	header := obj.Header(req, storagev1.CachePartHit)
+	assert.Equal(t, "application/pdf", header.Get("Content-Type"))
+	assert.Empty(t, header.Get("Content-Length"))
+	assert.Equal(t, "PART_HIT from edge-1 warm (tavern/4.0)", header.Get(protocol.ProtocolCacheStatusKey))
+	obj.Close()
+}
+
+func TestLookupListener(t *testing.T) {
+	newCaching := func(listener, hostname string) func() {
+		_, cleanup, err := Middleware(&configv1.Middleware{Name: "caching", Options: map[string]any{"hostname": hostname, "listener": listener}})
+		assert.NoError(t, err)
+		return cleanup
+	}
+
+	cleanupHTTP := newCaching("http", "edge-http")
+	cleanupHTTPS := newCaching("https", "edge-https")
+	defer cleanupHTTPS()
+
+	hostname := func(listener string) string {
+		v, ok := lookups.Load(listener)
+		if !ok {
+			return ""
+		}
+		return v.(*lookupContext).opt.Hostname
+	}
+	assert.Equal(t, "edge-http", hostname("http"))
+	assert.Equal(t, "edge-https", hostname("https"))
+
+	cleanupHTTP()
+	assert.Empty(t, hostname("http"))
+	assert.Equal(t, "edge-https", hostname("https"))
+
+	_, ok := Lookup("http", httptest.NewRequest(http.MethodGet, "http://www.example.com/a.pdf", nil))
+	assert.False(t, ok)
+
+	req := httptest.NewRequest(http.MethodGet, "http://www.example.com/a.pdf", nil)
+	assert.True(t, cachedOnly(req))
+	req.Header.Set("Cache-Control", "max-age=0, no-cache")
+	assert.False(t, cachedOnly(req))
+}
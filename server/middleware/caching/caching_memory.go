@@ -0,0 +1,86 @@
+package caching
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+	"github.com/omalloc/tavern/internal/protocol"
+)
+
+// capacitor is implemented by the buckets reporting their capacity, e.g. memory bucket.
+type capacitor interface {
+	Capacity() uint64
+}
+
+// forceMemory reports whether the object of req is forced into memory bucket,
+// by `X-FS-Mem: 1` of the gateway or the `force_memory` path rules.
+func (o *cachingOption) forceMemory(req *http.Request) bool {
+	if req.Header.Get(protocol.ProtocolForceStoreMemory) == protocol.FlagOn {
+		return true
+	}
+
+	for _, rule := range o.ForceMemory {
+		if matchPath(rule, req.URL.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath matches the rule with `path.Match`, the rule without `/` matches the file name only.
+func matchPath(rule, urlPath string) bool {
+	if !strings.Contains(rule, "/") {
+		urlPath = path.Base(urlPath)
+	}
+	ok, _ := path.Match(rule, urlPath)
+	return ok
+}
+
+// selectMemory returns the memory bucket, nil if the storage has no memory bucket.
+func selectMemory(ctx context.Context, store storage.Storage, id *object.ID) storage.Bucket {
+	ls, ok := store.(storage.LayerSelector)
+	if !ok {
+		return nil
+	}
+	return ls.SelectLayer(ctx, id, storage.TypeInMemory)
+}
+
+// settleMemoryBucket switches to the fallback bucket when the object of size bytes
+// exceeds the capacity of memory bucket, the unknown size (chunked) fits.
+// a full memory bucket still takes the object, the eviction makes room for it.
+func (c *Caching) settleMemoryBucket(size uint64) {
+	if c.fallback == nil {
+		return
+	}
+
+	fallback := c.fallback
+	c.fallback = nil
+
+	if size > 0 && size > capacity(c.bucket) {
+		c.log.Infof("memory bucket is too small, object %s (%d bytes) falls back to %s", c.id.Key(), size, fallback.ID())
+		cacheForceMemoryTotal.WithLabelValues("fallback").Inc()
+		c.bucket = fallback
+		return
+	}
+	cacheForceMemoryTotal.WithLabelValues("memory").Inc()
+}
+
+// capacity returns the capacity bytes of bucket, unwrapping the decorated buckets.
+func capacity(bucket storage.Bucket) uint64 {
+	for {
+		if a, ok := bucket.(capacitor); ok {
+			return a.Capacity()
+		}
+
+		u, ok := bucket.(interface{ Unwrap() storage.Bucket })
+		if !ok {
+			// unknown capacity
+			return ^uint64(0)
+		}
+		bucket = u.Unwrap()
+	}
+}
@@ -0,0 +1,103 @@
+package caching
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	storagev1 "github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+	"github.com/omalloc/tavern/contrib/log"
+	"github.com/omalloc/tavern/internal/protocol"
+	"github.com/omalloc/tavern/storage/bucket/memory"
+	"github.com/omalloc/tavern/storage/sharedkv"
+)
+
+func TestForceMemory(t *testing.T) {
+	opt := &cachingOption{ForceMemory: []string{"*.m3u8", "/live/hot/*"}}
+
+	tests := []struct {
+		url    string
+		header bool
+		want   bool
+	}{
+		{url: "http://www.example.com/live/a/index.m3u8", want: true},
+		{url: "http://www.example.com/live/hot/1.ts", want: true},
+		{url: "http://www.example.com/live/hot/a/1.ts", want: false},
+		{url: "http://www.example.com/live/a/1.ts", want: false},
+		{url: "http://www.example.com/live/a/1.ts", header: true, want: true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		if tt.header {
+			req.Header.Set(protocol.ProtocolForceStoreMemory, protocol.FlagOn)
+		}
+		assert.Equal(t, tt.want, opt.forceMemory(req), tt.url)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://www.example.com/1.ts", nil)
+	req.Header.Set(protocol.ProtocolForceStoreMemory, protocol.FlagOff)
+	assert.False(t, (&cachingOption{}).forceMemory(req))
+
+	// the gateway header is not forwarded to the origin
+	req.Header.Set(protocol.ProtocolForceStoreMemory, protocol.FlagOn)
+	assert.Empty(t, cloneRequest(req).Header.Get(protocol.ProtocolForceStoreMemory))
+}
+
+func TestSettleMemoryBucket(t *testing.T) {
+	mem, err := memory.New(&storagev1.BucketConfig{
+		Driver:         "memory",
+		Type:           storagev1.TypeInMemory,
+		EvictionPolicy: "lru",
+		MaxCacheSize:   4096,
+	}, sharedkv.NewEmpty())
+	assert.NoError(t, err)
+	defer mem.Close()
+
+	disk, err := memory.New(&storagev1.BucketConfig{Driver: "memory", Type: storagev1.TypeWarm}, sharedkv.NewEmpty())
+	assert.NoError(t, err)
+	defer disk.Close()
+
+	newCaching := func() *Caching {
+		return &Caching{
+			log:      log.NewHelper(log.GetLogger()),
+			id:       object.NewID("http://www.example.com/live/index.m3u8"),
+			bucket:   mem,
+			fallback: disk,
+		}
+	}
+
+	// fits
+	c := newCaching()
+	c.settleMemoryBucket(1024)
+	assert.Equal(t, mem, c.bucket)
+	assert.Nil(t, c.fallback)
+
+	// unknown size (chunked) fits
+	c = newCaching()
+	c.settleMemoryBucket(0)
+	assert.Equal(t, mem, c.bucket)
+
+	// the memory bucket is full, the eviction makes room
+	md := &object.Metadata{
+		ID:        object.NewID("http://www.example.com/live/1.ts"),
+		Size:      3584,
+		BlockSize: 4096,
+		Headers:   make(http.Header),
+	}
+	md.Chunks.Set(0)
+	assert.NoError(t, mem.Store(context.Background(), md))
+	c = newCaching()
+	c.settleMemoryBucket(1024)
+	assert.Equal(t, mem, c.bucket)
+	assert.Nil(t, c.fallback)
+
+	// larger than the memory bucket
+	c = newCaching()
+	c.settleMemoryBucket(8192)
+	assert.Equal(t, disk, c.bucket)
+	assert.Nil(t, c.fallback)
+}
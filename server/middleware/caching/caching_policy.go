@@ -0,0 +1,182 @@
+package caching
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/omalloc/tavern/internal/protocol"
+	"github.com/omalloc/tavern/pkg/cachekey"
+	xhttp "github.com/omalloc/tavern/pkg/x/http"
+)
+
+// Cache modes of policyRule.
+const (
+	policyCacheDefault = ""       // follow the origin Cache-Control / Expires
+	policyCacheForce   = "force"  // cache even if the origin says no-store / private
+	policyCacheBypass  = "bypass" // never cache, forward to the origin
+)
+
+// errPolicyBypass the request matched a `cache: bypass` rule.
+var errPolicyBypass = errors.New("policy bypass")
+
+// policyRule overrides the global caching options for the requests matching
+// the host and path, the first matched rule wins.
+type policyRule struct {
+	Hosts            []string `json:"hosts" yaml:"hosts"`                           // `www.example.com`, `*.example.com`; empty matches all
+	Path             string   `json:"path" yaml:"path"`                             // glob of `path.Match`, the rule without `/` matches the file name only
+	PathRegex        string   `json:"path_regex" yaml:"path_regex"`                 // regexp of the url path
+	Cache            string   `json:"cache" yaml:"cache"`                           // "", force, bypass
+	TTL              Duration `json:"ttl" yaml:"ttl"`                               // cache time replacing Cache-Control / Expires
+	FillRangePercent *uint64  `json:"fill_range_percent" yaml:"fill_range_percent"` // 0 disables the range fill
+	SliceSize        uint64   `json:"slice_size" yaml:"slice_size"`                 // chunk size of the new objects
+	VaryIgnoreKey    []string `json:"vary_ignore_key" yaml:"vary_ignore_key"`       // appended to the global `vary_ignore_key`
+	CacheErrorCode   []int    `json:"cache_error_code" yaml:"cache_error_code"`     // error status codes allowed to cache, same as `i-x-ct-code: 1`
+	ErrorTTL         Duration `json:"error_ttl" yaml:"error_ttl"`                   // cache time of the error status codes
+
+	pathRegex     *regexp.Regexp
+	varyIgnoreKey map[string]struct{}
+}
+
+// compilePolicy validates the rules and compiles the patterns in place.
+func compilePolicy(rules []policyRule) error {
+	for i := range rules {
+		r := &rules[i]
+
+		switch r.Cache {
+		case policyCacheDefault, policyCacheForce, policyCacheBypass:
+		default:
+			return fmt.Errorf("rules[%d]: unknown cache mode %q", i, r.Cache)
+		}
+
+		if r.Path != "" {
+			if _, err := path.Match(r.Path, ""); err != nil {
+				return fmt.Errorf("rules[%d]: invalid path pattern %q: %w", i, r.Path, err)
+			}
+		}
+
+		if r.PathRegex != "" {
+			re, err := regexp.Compile(r.PathRegex)
+			if err != nil {
+				return fmt.Errorf("rules[%d]: invalid path_regex %q: %w", i, r.PathRegex, err)
+			}
+			r.pathRegex = re
+		}
+
+		for _, d := range []Duration{r.TTL, r.ErrorTTL} {
+			if _, err := time.ParseDuration(string(d)); d != "" && err != nil {
+				return fmt.Errorf("rules[%d]: invalid duration %q: %w", i, d, err)
+			}
+		}
+
+		if r.FillRangePercent != nil && *r.FillRangePercent > 100 {
+			return fmt.Errorf("rules[%d]: fill_range_percent %d out of range [0, 100]", i, *r.FillRangePercent)
+		}
+
+		r.varyIgnoreKey = make(map[string]struct{}, len(r.VaryIgnoreKey))
+		for _, key := range r.VaryIgnoreKey {
+			r.varyIgnoreKey[http.CanonicalHeaderKey(key)] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// matchPolicy returns the first rule matching req, nil if no rule matched.
+func (o *cachingOption) matchPolicy(req *http.Request) *policyRule {
+	if len(o.Rules) == 0 {
+		return nil
+	}
+
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	for i := range o.Rules {
+		if o.Rules[i].match(host, req.URL.Path) {
+			return &o.Rules[i]
+		}
+	}
+	return nil
+}
+
+func (r *policyRule) match(host, urlPath string) bool {
+	if r.Path != "" && !matchPath(r.Path, urlPath) {
+		return false
+	}
+	if r.pathRegex != nil && !r.pathRegex.MatchString(urlPath) {
+		return false
+	}
+	return cachekey.MatchHost(r.Hosts, host)
+}
+
+// bypass reports whether the request never touches the cache.
+func (r *policyRule) bypass() bool {
+	return r != nil && r.Cache == policyCacheBypass
+}
+
+// cacheTime applies the rule to the cache time parsed from the response headers,
+// the `X-CacheTime` of the gateway takes precedence over the rule.
+func (r *policyRule) cacheTime(resp *http.Response, ttl time.Duration, cacheable bool) (time.Duration, bool) {
+	if r == nil || resp.Header.Get(protocol.ProtocolCacheTime) != "" {
+		return ttl, cacheable
+	}
+
+	switch r.Cache {
+	case policyCacheBypass:
+		return 0, false
+	case policyCacheForce:
+		cacheable = true
+	}
+
+	if !cacheable {
+		return ttl, cacheable
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest && r.ErrorTTL != "" {
+		return r.ErrorTTL.AsDuration(), true
+	}
+	if r.TTL != "" {
+		return r.TTL.AsDuration(), true
+	}
+	if r.Cache == policyCacheForce && ttl <= 0 {
+		// forced, the origin gives no cache time
+		ttl = xhttp.DefaultProtocolCacheTime
+	}
+	return ttl, cacheable
+}
+
+// cacheErrorCode reports whether the error status code is allowed to cache.
+func (r *policyRule) cacheErrorCode(code int) bool {
+	return r != nil && slices.Contains(r.CacheErrorCode, code)
+}
+
+// fillRangePercent returns the fill range percent of the rule, def if the rule has none.
+func (r *policyRule) fillRangePercent(def uint64) uint64 {
+	if r == nil || r.FillRangePercent == nil {
+		return def
+	}
+	return *r.FillRangePercent
+}
+
+// sliceSize returns the chunk size of the rule, def if the rule has none.
+func (r *policyRule) sliceSize(def uint64) uint64 {
+	if r == nil || r.SliceSize == 0 {
+		return def
+	}
+	return r.SliceSize
+}
+
+// ignoreVary reports whether the canonical header key is ignored by the rule.
+func (r *policyRule) ignoreVary(key string) bool {
+	if r == nil {
+		return false
+	}
+	_, ok := r.varyIgnoreKey[key]
+	return ok
+}
@@ -0,0 +1,174 @@
+package caching
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+	"github.com/omalloc/tavern/contrib/log"
+	"github.com/omalloc/tavern/internal/protocol"
+	xhttp "github.com/omalloc/tavern/pkg/x/http"
+	"github.com/omalloc/tavern/server/middleware/internal/middlewaretest"
+)
+
+func newPolicyOption(t *testing.T, rules ...policyRule) *cachingOption {
+	t.Helper()
+
+	opt := &cachingOption{Rules: rules}
+	assert.NoError(t, compilePolicy(opt.Rules))
+	return opt
+}
+
+func TestMatchPolicy(t *testing.T) {
+	opt := newPolicyOption(t,
+		policyRule{Hosts: []string{"api.example.com"}, Cache: policyCacheBypass},
+		policyRule{Hosts: []string{"*.example.com"}, Path: "*.m3u8", TTL: "2s"},
+		policyRule{PathRegex: `^/video/.+\.mp4$`, SliceSize: 4 << 20},
+	)
+
+	tests := []struct {
+		url  string
+		want int
+	}{
+		{"http://api.example.com/a.m3u8", 0},
+		{"http://live.example.com:8080/live/index.m3u8", 1},
+		{"http://live.example.com/live/1.ts", -1},
+		{"http://example.com/live/index.m3u8", -1},
+		{"http://www.example.org/video/a/1.mp4", 2},
+		{"http://www.example.org/video/1.mp4.tmp", -1},
+	}
+	for _, tt := range tests {
+		rule := opt.matchPolicy(httptest.NewRequest(http.MethodGet, tt.url, nil))
+		if tt.want < 0 {
+			assert.Nil(t, rule, tt.url)
+			continue
+		}
+		assert.Same(t, &opt.Rules[tt.want], rule, tt.url)
+	}
+
+	assert.Nil(t, (&cachingOption{}).matchPolicy(httptest.NewRequest(http.MethodGet, "http://www.example.com/", nil)))
+}
+
+func TestCompilePolicy(t *testing.T) {
+	percent := uint64(101)
+
+	for _, rule := range []policyRule{
+		{Cache: "always"},
+		{Path: "[a"},
+		{PathRegex: "(a"},
+		{TTL: "10"},
+		{ErrorTTL: "1x"},
+		{FillRangePercent: &percent},
+	} {
+		assert.Error(t, compilePolicy([]policyRule{rule}), "%+v", rule)
+	}
+}
+
+func TestPolicyCacheTime(t *testing.T) {
+	newResp := func(code int, header ...string) *http.Response {
+		resp := &http.Response{StatusCode: code, Header: make(http.Header)}
+		for i := 0; i+1 < len(header); i += 2 {
+			resp.Header.Set(header[i], header[i+1])
+		}
+		return resp
+	}
+
+	var none *policyRule
+	ttl, cacheable := none.cacheTime(newResp(http.StatusOK), time.Minute, true)
+	assert.Equal(t, time.Minute, ttl)
+	assert.True(t, cacheable)
+
+	rule := &policyRule{TTL: "1h", ErrorTTL: "10s"}
+	ttl, cacheable = rule.cacheTime(newResp(http.StatusOK), time.Minute, true)
+	assert.Equal(t, time.Hour, ttl)
+	assert.True(t, cacheable)
+
+	// the origin says no-store
+	_, cacheable = rule.cacheTime(newResp(http.StatusOK), 0, false)
+	assert.False(t, cacheable)
+
+	ttl, _ = rule.cacheTime(newResp(http.StatusNotFound), time.Minute, true)
+	assert.Equal(t, 10*time.Second, ttl)
+
+	// X-CacheTime of the gateway wins
+	ttl, _ = rule.cacheTime(newResp(http.StatusOK, protocol.ProtocolCacheTime, "60"), time.Minute, true)
+	assert.Equal(t, time.Minute, ttl)
+
+	force := &policyRule{Cache: policyCacheForce}
+	ttl, cacheable = force.cacheTime(newResp(http.StatusOK, "Cache-Control", "no-store"), 0, false)
+	assert.Equal(t, xhttp.DefaultProtocolCacheTime, ttl)
+	assert.True(t, cacheable)
+
+	bypass := &policyRule{Cache: policyCacheBypass}
+	_, cacheable = bypass.cacheTime(newResp(http.StatusOK), time.Minute, true)
+	assert.False(t, cacheable)
+	assert.True(t, bypass.bypass())
+	assert.False(t, none.bypass())
+}
+
+func TestPolicyOverrides(t *testing.T) {
+	percent := uint64(0)
+	opt := newPolicyOption(t, policyRule{
+		FillRangePercent: &percent,
+		SliceSize:        4096,
+		VaryIgnoreKey:    []string{"accept-language"},
+		CacheErrorCode:   []int{http.StatusNotFound},
+	})
+	rule := &opt.Rules[0]
+
+	var none *policyRule
+	assert.Equal(t, uint64(100), none.fillRangePercent(100))
+	assert.Equal(t, uint64(0), rule.fillRangePercent(100))
+	assert.Equal(t, uint64(1024), none.sliceSize(1024))
+	assert.Equal(t, uint64(4096), rule.sliceSize(1024))
+	assert.True(t, rule.cacheErrorCode(http.StatusNotFound))
+	assert.False(t, rule.cacheErrorCode(http.StatusBadGateway))
+	assert.False(t, none.cacheErrorCode(http.StatusNotFound))
+
+	v := NewVaryProcessor(WithVaryIgnoreKeys("cookie"))
+	c := &Caching{policy: rule}
+	assert.Equal(t, []string{"Accept-Encoding"}, []string(v.varyKey(c, "Accept-Encoding, Cookie", "Accept-Language")))
+	assert.Equal(t, []string{"Accept-Encoding", "Accept-Language"}, []string(v.varyKey(&Caching{}, "Accept-Encoding, Cookie", "Accept-Language")))
+}
+
+func TestVaryIgnoredDowngrade(t *testing.T) {
+	ctx := context.Background()
+	bucket := middlewaretest.NewMemoryBucket(t)
+
+	id := object.NewID("http://www.example.com/a.html")
+	vid := object.NewVirtualID(id.Path(), "Accept-Language=en")
+	index := &object.Metadata{
+		ID:         id,
+		Flags:      object.FlagVaryIndex,
+		VirtualKey: []string{"Accept-Language=en"},
+		Headers:    http.Header{"Vary": {"Accept-Language"}},
+	}
+	assert.NoError(t, bucket.Store(ctx, index))
+	assert.NoError(t, bucket.Store(ctx, &object.Metadata{ID: vid, Flags: object.FlagVaryCache, Code: http.StatusOK, Headers: make(http.Header)}))
+
+	opt := newPolicyOption(t, policyRule{VaryIgnoreKey: []string{"accept-language"}})
+	c := &Caching{
+		log:    log.NewHelper(log.GetLogger()),
+		opt:    opt,
+		id:     id,
+		md:     index,
+		bucket: bucket,
+		policy: &opt.Rules[0],
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://www.example.com/a.html", nil)
+	req.Header.Set("Accept-Language", "en")
+	hit, err := NewVaryProcessor().Lookup(c, req)
+	assert.NoError(t, err)
+	assert.False(t, hit)
+	assert.Nil(t, c.md)
+
+	// the index and its vary versions are discarded together
+	assert.False(t, bucket.Exist(ctx, id.Bytes()))
+	assert.False(t, bucket.Exist(ctx, vid.Bytes()))
+}
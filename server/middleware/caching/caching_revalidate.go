@@ -92,7 +92,7 @@ func (r *RevalidateProcessor) Lookup(c *Caching, req *http.Request) (bool, error
 						time.Unix(hardTTL, 0).Format(time.DateTime))
 
 					// Trigger async revalidation in background
-					go r.asyncRevalidate(c, req)
+					go r.asyncRevalidate(c.snapshot())
 				}
 			}
 
@@ -112,6 +112,20 @@ func (r *RevalidateProcessor) Lookup(c *Caching, req *http.Request) (bool, error
 	}
 
 	if c.md.HasComplete() && hasConditionHeader(c.md.Headers) {
+		// RFC 5861 stale-while-revalidate, serve stale and refresh in background
+		if c.canServeStaleWhileRevalidate() {
+			c.stale = true
+			go r.asyncRevalidate(c.snapshot())
+			return true, nil
+		}
+
+		c.revalidate = true
+		c.cacheStatus = storagev1.CacheRevalidateHit
+		return false, nil
+	}
+
+	// no validators, but keep the stale object in case of origin failure.
+	if c.canServeStaleIfError() {
 		c.revalidate = true
 		c.cacheStatus = storagev1.CacheRevalidateHit
 		return false, nil
@@ -151,6 +165,11 @@ func (r *RevalidateProcessor) PreRequest(c *Caching, req *http.Request) (*http.R
 			conditionHeader = true
 		}
 
+		// unconditional refresh, the stale object is discarded once origin responds.
+		if !conditionHeader && c.canServeStaleIfError() {
+			return req, nil
+		}
+
 		if !conditionHeader {
 			c.log.Warnf("refresh error while get 'Etag' & 'Last-Modified' is nil, delete cache do proxy")
 			c.md.Chunks.Clear()
@@ -239,14 +258,22 @@ func (r *RevalidateProcessor) freshness(c *Caching, resp *http.Response) bool {
 	return true
 }
 
-// asyncRevalidate performs background revalidation for fuzzy refresh
-func (r *RevalidateProcessor) asyncRevalidate(c *Caching, req *http.Request) {
+// asyncRevalidate performs background revalidation for fuzzy refresh,
+// c is the snapshot of the foreground Caching.
+func (r *RevalidateProcessor) asyncRevalidate(c *Caching) {
+	// only one background revalidation per object
+	if _, loaded := revalidating.LoadOrStore(c.id.HashStr(), struct{}{}); loaded {
+		return
+	}
+	defer revalidating.Delete(c.id.HashStr())
+
 	// Create a background context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	// Clone the request for background processing
-	bgReq := req.Clone(ctx)
+	bgReq := c.req.Clone(ctx)
+	c.req = bgReq
 
 	// Set conditional headers for revalidation
 	if c.md.Headers.Get("ETag") != "" {
@@ -0,0 +1,96 @@
+package caching
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/omalloc/tavern/pkg/x/http/cachecontrol"
+)
+
+// revalidating 正在后台 revalidate 的对象, 避免 stale-while-revalidate 时每个请求都触发一次回源
+var revalidating sync.Map
+
+// staleIfErrorWindow returns how long after ExpiresAt the object may be served
+// when the origin fails (RFC 5861 stale-if-error).
+// 取缓存响应 Cache-Control 中的 stale-if-error 与全局 stale_grace_period 的较大值.
+func (c *Caching) staleIfErrorWindow() time.Duration {
+	window := c.opt.StaleGracePeriod.AsDuration()
+	if d := cachecontrol.Parse(c.md.Headers.Get("Cache-Control")).StaleIfError(); d > window {
+		window = d
+	}
+	return window
+}
+
+// staleWhileRevalidateWindow returns how long after ExpiresAt the object may be served
+// while revalidating in background (RFC 5861 stale-while-revalidate).
+func (c *Caching) staleWhileRevalidateWindow() time.Duration {
+	return cachecontrol.Parse(c.md.Headers.Get("Cache-Control")).StaleWhileRevalidate()
+}
+
+// canServeStale reports whether the expired object is still complete and within the window.
+func (c *Caching) canServeStale(window time.Duration) bool {
+	if c.md == nil || window <= 0 || !c.md.HasComplete() {
+		return false
+	}
+	// error response never served as stale.
+	if c.md.Code >= http.StatusBadRequest {
+		return false
+	}
+	return time.Now().Before(time.Unix(c.md.ExpiresAt, 0).Add(window))
+}
+
+func (c *Caching) canServeStaleIfError() bool {
+	return c.md != nil && c.canServeStale(c.staleIfErrorWindow())
+}
+
+func (c *Caching) canServeStaleWhileRevalidate() bool {
+	return c.md != nil && c.canServeStale(c.staleWhileRevalidateWindow())
+}
+
+// serveStaleOnError responds the expired object when revalidation against origin failed.
+func (c *Caching) serveStaleOnError(req *http.Request, err error) (*http.Response, bool) {
+	if !c.revalidate || !c.canServeStaleIfError() {
+		return nil, false
+	}
+
+	c.log.Warnf("origin failed for %s, serve stale content: %v", c.id.Key(), err)
+
+	c.revalidate = false
+	c.stale = true
+	resp, err := c.respondFromCache(req)
+	if err != nil {
+		c.log.Errorf("serve stale content for %s failed: %v", c.id.Key(), err)
+		return nil, false
+	}
+	return resp, true
+}
+
+// snapshot returns the copy of c for the background revalidation, the foreground request
+// keeps serving the stale object with c while doProxy / freshness mutate the copy.
+func (c *Caching) snapshot() *Caching {
+	sc := &Caching{
+		log:          c.log,
+		processor:    c.processor,
+		opt:          c.opt,
+		req:          c.req.Clone(context.Background()),
+		ctx:          context.Background(),
+		id:           c.id,
+		md:           c.md.Clone(),
+		bucket:       c.bucket,
+		fallback:     c.fallback,
+		policy:       c.policy,
+		proxyClient:  c.proxyClient,
+		chunkFlight:  c.chunkFlight,
+		cacheStatus:  c.cacheStatus,
+		cacheable:    c.cacheable,
+		hit:          c.hit,
+		noContentLen: c.noContentLen,
+		migration:    c.migration,
+	}
+	if c.rootmd != nil {
+		sc.rootmd = c.rootmd.Clone()
+	}
+	return sc
+}
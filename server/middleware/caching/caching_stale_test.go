@@ -0,0 +1,129 @@
+package caching
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+)
+
+func newStaleCaching(grace string, cc string, expiresAt time.Time) *Caching {
+	md := &object.Metadata{
+		ID:        object.NewID("http://www.example.com/stale"),
+		Code:      http.StatusOK,
+		Size:      1024,
+		BlockSize: 1024,
+		ExpiresAt: expiresAt.Unix(),
+		Headers:   make(http.Header),
+	}
+	md.Chunks.Set(0)
+	if cc != "" {
+		md.Headers.Set("Cache-Control", cc)
+	}
+
+	return &Caching{
+		opt: &cachingOption{StaleGracePeriod: Duration(grace)},
+		md:  md,
+	}
+}
+
+func TestStaleWindow(t *testing.T) {
+	c := newStaleCaching("", "max-age=60, stale-if-error=300, stale-while-revalidate=30", time.Now())
+	assert.Equal(t, 300*time.Second, c.staleIfErrorWindow())
+	assert.Equal(t, 30*time.Second, c.staleWhileRevalidateWindow())
+
+	// grace period wins when larger than stale-if-error
+	c = newStaleCaching("10m", "max-age=60, stale-if-error=300", time.Now())
+	assert.Equal(t, 10*time.Minute, c.staleIfErrorWindow())
+
+	c = newStaleCaching("", "max-age=60", time.Now())
+	assert.Equal(t, time.Duration(0), c.staleIfErrorWindow())
+	assert.True(t, c.staleWhileRevalidateWindow() <= 0)
+}
+
+func TestCanServeStale(t *testing.T) {
+	tests := []struct {
+		name      string
+		grace     string
+		cc        string
+		expiresAt time.Time
+		code      int
+		swr       bool
+		sie       bool
+	}{
+		{
+			name:      "disabled",
+			cc:        "max-age=60",
+			expiresAt: time.Now().Add(-time.Second),
+			code:      http.StatusOK,
+		},
+		{
+			name:      "within stale-while-revalidate",
+			cc:        "max-age=60, stale-while-revalidate=60",
+			expiresAt: time.Now().Add(-10 * time.Second),
+			code:      http.StatusOK,
+			swr:       true,
+		},
+		{
+			name:      "beyond stale-while-revalidate",
+			cc:        "max-age=60, stale-while-revalidate=60",
+			expiresAt: time.Now().Add(-2 * time.Minute),
+			code:      http.StatusOK,
+		},
+		{
+			name:      "within grace period",
+			grace:     "10m",
+			expiresAt: time.Now().Add(-5 * time.Minute),
+			code:      http.StatusOK,
+			sie:       true,
+		},
+		{
+			name:      "error response never stale",
+			grace:     "10m",
+			cc:        "stale-while-revalidate=60",
+			expiresAt: time.Now().Add(-time.Second),
+			code:      http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newStaleCaching(tt.grace, tt.cc, tt.expiresAt)
+			c.md.Code = tt.code
+			assert.Equal(t, tt.swr, c.canServeStaleWhileRevalidate())
+			assert.Equal(t, tt.sie, c.canServeStaleIfError())
+		})
+	}
+
+	// incomplete object
+	c := newStaleCaching("10m", "", time.Now().Add(-time.Second))
+	c.md.Size = 4096
+	assert.False(t, c.canServeStaleIfError())
+}
+
+func TestSnapshot(t *testing.T) {
+	c := newStaleCaching("", "max-age=60, stale-while-revalidate=60", time.Now().Add(-time.Second))
+	c.req, _ = http.NewRequest(http.MethodGet, "http://www.example.com/stale", nil)
+	c.req.Header.Set("Range", "bytes=0-9")
+	c.stale = true
+
+	sc := c.snapshot()
+	assert.False(t, sc.stale)
+
+	// the background revalidation never touches the foreground state
+	sc.md.ExpiresAt = time.Now().Add(time.Hour).Unix()
+	sc.md.Chunks.Clear()
+	sc.md.Headers.Set("ETag", `"v2"`)
+	sc.req.Header.Del("Range")
+	sc.cacheable = true
+
+	assert.True(t, hasExpired(c.md))
+	assert.True(t, c.md.HasComplete())
+	assert.Empty(t, c.md.Headers.Get("ETag"))
+	assert.Equal(t, "bytes=0-9", c.req.Header.Get("Range"))
+	assert.False(t, c.cacheable)
+	assert.True(t, c.stale)
+}
@@ -0,0 +1,176 @@
+package caching
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/omalloc/tavern/internal/protocol"
+	"github.com/omalloc/tavern/pkg/cachekey"
+	xhttp "github.com/omalloc/tavern/pkg/x/http"
+)
+
+// ttlRule is the cache time of the responses matching the status code and content type,
+// the first matched rule wins.
+type ttlRule struct {
+	Status      []string `json:"status" yaml:"status"`             // `200`, `301`, `404`, `5xx`; empty matches all, 206 matches 200
+	ContentType []string `json:"content_type" yaml:"content_type"` // `text/html`, `image/*`; empty matches all
+	Default     Duration `json:"default" yaml:"default"`           // used when the origin omits Cache-Control and Expires, `0s` does not cache
+	Min         Duration `json:"min" yaml:"min"`                   // clamps the cache time of the origin
+	Max         Duration `json:"max" yaml:"max"`
+}
+
+// compileTTL validates the rules.
+func compileTTL(rules []ttlRule) error {
+	for i, r := range rules {
+		for _, status := range r.Status {
+			if !xhttp.ValidStatusPattern(status) {
+				return fmt.Errorf("ttl_rules[%d]: invalid status %q", i, status)
+			}
+		}
+
+		for _, pattern := range r.ContentType {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("ttl_rules[%d]: invalid content_type pattern %q: %w", i, pattern, err)
+			}
+		}
+
+		for _, d := range []Duration{r.Default, r.Min, r.Max} {
+			if _, err := time.ParseDuration(string(d)); d != "" && err != nil {
+				return fmt.Errorf("ttl_rules[%d]: invalid duration %q: %w", i, d, err)
+			}
+		}
+
+		if r.Min != "" && r.Max != "" && r.Min.AsDuration() > r.Max.AsDuration() {
+			return fmt.Errorf("ttl_rules[%d]: min %s greater than max %s", i, r.Min, r.Max)
+		}
+	}
+	return nil
+}
+
+func (r *ttlRule) match(statusCode int, contentType string) bool {
+	if len(r.Status) > 0 && !matchStatus(r.Status, statusCode) {
+		return false
+	}
+
+	if len(r.ContentType) == 0 {
+		return true
+	}
+	for _, pattern := range r.ContentType {
+		if ok, _ := path.Match(pattern, contentType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchStatus matches the 206 response as 200.
+func matchStatus(patterns []string, statusCode int) bool {
+	if statusCode == http.StatusPartialContent {
+		statusCode = http.StatusOK
+	}
+	return xhttp.MatchStatus(patterns, statusCode)
+}
+
+// clamp limits ttl into [Min, Max].
+func (r *ttlRule) clamp(ttl time.Duration) time.Duration {
+	if r.Min != "" {
+		ttl = max(ttl, r.Min.AsDuration())
+	}
+	if r.Max != "" {
+		ttl = min(ttl, r.Max.AsDuration())
+	}
+	return ttl
+}
+
+// matchTTL returns the first ttl rule matching resp, nil if no rule matched.
+func (o *cachingOption) matchTTL(resp *http.Response) *ttlRule {
+	if len(o.TTLRules) == 0 {
+		return nil
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	for i := range o.TTLRules {
+		if o.TTLRules[i].match(resp.StatusCode, contentType) {
+			return &o.TTLRules[i]
+		}
+	}
+	return nil
+}
+
+// cacheErrorCode reports whether the error status of resp is allowed to cache by the
+// `ttl_rules` rule listing the status explicitly, e.g. `404` or `5xx`.
+func (o *cachingOption) cacheErrorCode(resp *http.Response) bool {
+	rule := o.matchTTL(resp)
+	return rule != nil && len(rule.Status) > 0
+}
+
+// ignoreNoCache reports whether the origin `no-cache` / `private` of host is ignored.
+func (o *cachingOption) ignoreNoCache(host string) bool {
+	if len(o.IgnoreNoCacheHosts) == 0 {
+		return false
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return cachekey.MatchHost(o.IgnoreNoCacheHosts, host)
+}
+
+// cacheTime returns the cache time of resp, the `X-CacheTime` of the gateway takes precedence,
+// then the `ttl_rules` default when the origin omits Cache-Control and Expires, the cache time
+// of the origin is clamped by the rule.
+func (o *cachingOption) cacheTime(req *http.Request, resp *http.Response) (time.Duration, bool) {
+	if resp.Header.Get(protocol.ProtocolCacheTime) != "" {
+		return xhttp.ParseCacheTime(protocol.ProtocolCacheTime, resp.Header)
+	}
+
+	header := resp.Header
+	if o.ignoreNoCache(req.Host) {
+		header = withoutNoCache(header)
+	}
+
+	rule := o.matchTTL(resp)
+	if rule != nil && rule.Default != "" && header.Get("Cache-Control") == "" && header.Get("Expires") == "" {
+		ttl := rule.Default.AsDuration()
+		return ttl, ttl > 0
+	}
+
+	ttl, cacheable := xhttp.ParseCacheTime(protocol.ProtocolCacheTime, header)
+	if cacheable && rule != nil {
+		ttl = rule.clamp(ttl)
+	}
+	return ttl, cacheable
+}
+
+// withoutNoCache returns a copy of h without the `no-cache` / `private` Cache-Control directives.
+func withoutNoCache(h http.Header) http.Header {
+	values := h.Values("Cache-Control")
+	if len(values) == 0 {
+		return h
+	}
+
+	directives := make([]string, 0, len(values))
+	for _, value := range values {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			name, _, _ := strings.Cut(directive, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if directive == "" || name == "no-cache" || name == "private" {
+				continue
+			}
+			directives = append(directives, directive)
+		}
+	}
+
+	h = h.Clone()
+	h.Del("Cache-Control")
+	if len(directives) > 0 {
+		h.Set("Cache-Control", strings.Join(directives, ", "))
+	}
+	return h
+}
@@ -0,0 +1,98 @@
+package caching
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/tavern/internal/protocol"
+	xhttp "github.com/omalloc/tavern/pkg/x/http"
+)
+
+func TestCacheTime(t *testing.T) {
+	opt := &cachingOption{
+		TTLRules: []ttlRule{
+			{Status: []string{"200"}, ContentType: []string{"text/html"}, Default: "1m", Max: "10m"},
+			{Status: []string{"200", "301"}, Default: "24h", Min: "1h"},
+			{Status: []string{"404"}, Default: "10s"},
+			{Status: []string{"5xx"}, Default: "0s"},
+		},
+		IgnoreNoCacheHosts: []string{"*.example.com"},
+	}
+	assert.NoError(t, compileTTL(opt.TTLRules))
+
+	newResp := func(code int, header ...string) *http.Response {
+		resp := &http.Response{StatusCode: code, Header: make(http.Header)}
+		for i := 0; i+1 < len(header); i += 2 {
+			resp.Header.Set(header[i], header[i+1])
+		}
+		return resp
+	}
+
+	tests := []struct {
+		name      string
+		host      string
+		resp      *http.Response
+		ttl       time.Duration
+		cacheable bool
+	}{
+		{"html default", "www.example.org", newResp(http.StatusOK, "Content-Type", "text/html; charset=utf-8"), time.Minute, true},
+		{"html clamp max", "www.example.org", newResp(http.StatusOK, "Content-Type", "text/html", "Cache-Control", "max-age=86400"), 10 * time.Minute, true},
+		{"partial default", "www.example.org", newResp(http.StatusPartialContent, "Content-Type", "video/mp4"), 24 * time.Hour, true},
+		{"clamp min", "www.example.org", newResp(http.StatusOK, "Cache-Control", "max-age=60"), time.Hour, true},
+		{"redirect", "www.example.org", newResp(http.StatusMovedPermanently), 24 * time.Hour, true},
+		{"not found", "www.example.org", newResp(http.StatusNotFound), 10 * time.Second, true},
+		{"5xx not cache", "www.example.org", newResp(http.StatusBadGateway), 0, false},
+		{"no rule", "www.example.org", newResp(http.StatusForbidden), xhttp.DefaultProtocolCacheTime, true},
+		{"no-cache", "www.example.org", newResp(http.StatusOK, "Cache-Control", "no-cache"), 0, false},
+		{"ignore no-cache", "img.example.com:8080", newResp(http.StatusOK, "Cache-Control", "private, no-cache"), 24 * time.Hour, true},
+		{"ignore private keep max-age", "img.example.com", newResp(http.StatusOK, "Cache-Control", "private, max-age=7200"), 2 * time.Hour, true},
+		{"gateway wins", "www.example.org", newResp(http.StatusOK, protocol.ProtocolCacheTime, "30"), 30 * time.Second, true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "http://"+tt.host+"/a", nil)
+		ttl, cacheable := opt.cacheTime(req, tt.resp)
+		assert.Equal(t, tt.cacheable, cacheable, tt.name)
+		if tt.cacheable {
+			assert.Equal(t, tt.ttl, ttl, tt.name)
+		}
+	}
+}
+
+func TestTTLCacheErrorCode(t *testing.T) {
+	opt := &cachingOption{
+		TTLRules: []ttlRule{
+			{Status: []string{"404"}, Default: "10s"},
+			{ContentType: []string{"text/html"}, Default: "1m"},
+		},
+	}
+	assert.NoError(t, compileTTL(opt.TTLRules))
+
+	newResp := func(code int, contentType string) *http.Response {
+		return &http.Response{StatusCode: code, Header: http.Header{"Content-Type": {contentType}}}
+	}
+
+	assert.True(t, opt.cacheErrorCode(newResp(http.StatusNotFound, "text/plain")))
+	// the rule matching all status does not enable error caching
+	assert.False(t, opt.cacheErrorCode(newResp(http.StatusForbidden, "text/html")))
+	assert.False(t, opt.cacheErrorCode(newResp(http.StatusBadGateway, "image/png")))
+	assert.False(t, (&cachingOption{}).cacheErrorCode(newResp(http.StatusNotFound, "text/plain")))
+}
+
+func TestCompileTTL(t *testing.T) {
+	assert.NoError(t, compileTTL([]ttlRule{{Status: []string{"200", "4XX"}, ContentType: []string{"image/*"}}}))
+
+	for _, rule := range []ttlRule{
+		{Status: []string{"2x"}},
+		{Status: []string{"600"}},
+		{Status: []string{"2ab"}},
+		{ContentType: []string{"[image"}},
+		{Default: "1 day"},
+		{Min: "1h", Max: "1m"},
+	} {
+		assert.Error(t, compileTTL([]ttlRule{rule}), "%+v", rule)
+	}
+}
@@ -56,6 +56,17 @@ func (v *VaryProcessor) Lookup(caching *Caching, req *http.Request) (bool, error
 		return true, nil
 	}
 
+	// All the Vary headers are ignored by `vary_ignore_key`, downgrade to normal cache.
+	// the normal object is stored under the id of the index, discarding the index discards
+	// all the vary versions of its VirtualKey as well, none of them is orphaned.
+	if len(v.varyKey(caching, caching.md.Headers.Values("Vary")...)) == 0 {
+		if err := caching.bucket.Discard(context.Background(), caching.id); err != nil {
+			caching.log.Errorf("vary headers ignored, discard vary index err: %v", err)
+		}
+		caching.md = nil
+		return false, nil
+	}
+
 	// Find the matching Vary cache.
 	vmd := v.lookup(caching, req)
 	if vmd == nil {
@@ -123,10 +134,10 @@ func (v *VaryProcessor) PostRequest(caching *Caching, req *http.Request, resp *h
 
 // lookup finds the matching Vary cache entry based on request headers.
 func (v *VaryProcessor) lookup(caching *Caching, req *http.Request) *object.Metadata {
-	varyKey := varycontrol.Clean(caching.md.Headers.Values("Vary")...)
+	varyKey := v.varyKey(caching, caching.md.Headers.Values("Vary")...)
 
 	// Generate object ID based on Vary data from request headers.
-	vid, err := newObjectIDFromRequest(req, varyKey.VaryData(req.Header), caching.opt.IncludeQueryInCacheKey)
+	vid, err := newObjectIDFromRequest(req, varyKey.VaryData(req.Header), caching.opt.cacheKey)
 	if err != nil {
 		return nil
 	}
@@ -144,8 +155,8 @@ func (v *VaryProcessor) lookup(caching *Caching, req *http.Request) *object.Meta
 //   - When the origin response has no Vary header but cached metadata has Vary info
 //   - When the origin response contains Vary header
 func (v *VaryProcessor) convertVaryMetadata(caching *Caching, resp *http.Response) (*object.Metadata, error) {
-	metaVary := varycontrol.Clean(caching.md.Headers.Values("Vary")...)
-	respVary := varycontrol.Clean(resp.Header.Values("Vary")...)
+	metaVary := v.varyKey(caching, caching.md.Headers.Values("Vary")...)
+	respVary := v.varyKey(caching, resp.Header.Values("Vary")...)
 
 	if caching.log.Enabled(log.LevelDebug) && (len(metaVary) > 0 || len(respVary) > 0) {
 		caching.log.Debugf("convertVaryMetadata: metaVaryKey: %s, respVaryKey: %s", metaVary, respVary)
@@ -193,7 +204,7 @@ func (v *VaryProcessor) handleNoResponseVary(caching *Caching, resp *http.Respon
 		caching.log.Debugf("vary data already exist: %s", varyData)
 	}
 
-	l2MetaID, err := newObjectIDFromRequest(caching.req, varyData, caching.opt.IncludeQueryInCacheKey)
+	l2MetaID, err := newObjectIDFromRequest(caching.req, varyData, caching.opt.cacheKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create hash-key: %w", err)
 	}
@@ -222,7 +233,7 @@ func (v *VaryProcessor) handleResponseVary(caching *Caching, resp *http.Response
 		if metaVary.Compare(respVary) {
 			// Vary keys match, try to find existing Vary cache.
 			varyData = metaVary.VaryData(caching.req.Header)
-			varyKey, _ := newObjectIDFromRequest(caching.req, varyData, caching.opt.IncludeQueryInCacheKey)
+			varyKey, _ := newObjectIDFromRequest(caching.req, varyData, caching.opt.cacheKey)
 			varyMeta, err := caching.bucket.Lookup(caching.req.Context(), varyKey)
 			if err != nil {
 				caching.log.Warnf("Vary key lookup failed: %v", err)
@@ -251,7 +262,7 @@ func (v *VaryProcessor) handleResponseVary(caching *Caching, resp *http.Response
 		}
 
 		// Build new Vary cache object.
-		varyObjectID, _ := newObjectIDFromRequest(caching.req, varyData, caching.opt.IncludeQueryInCacheKey)
+		varyObjectID, _ := newObjectIDFromRequest(caching.req, varyData, caching.opt.cacheKey)
 		return v.upgrade(caching, resp, varyObjectID, varyData)
 	}
 
@@ -264,10 +275,19 @@ func (v *VaryProcessor) handleResponseVary(caching *Caching, resp *http.Response
 
 	caching.md.VirtualKey = nil
 	varyData = respVary.VaryData(caching.req.Header)
-	varyObjectID, _ := newObjectIDFromRequest(caching.req, varyData, caching.opt.IncludeQueryInCacheKey)
+	varyObjectID, _ := newObjectIDFromRequest(caching.req, varyData, caching.opt.cacheKey)
 	return v.upgrade(caching, resp, varyObjectID, varyData)
 }
 
+// varyKey cleans the Vary header values, the keys of global and rule `vary_ignore_key` are dropped.
+func (v *VaryProcessor) varyKey(caching *Caching, values ...string) varycontrol.Key {
+	return slices.DeleteFunc(varycontrol.Clean(values...), func(key string) bool {
+		key = http.CanonicalHeaderKey(key)
+		_, ok := v.varyIgnoreKey[key]
+		return ok || caching.policy.ignoreVary(key)
+	})
+}
+
 // upgrade converts a normal cache object to a Vary-aware cache structure.
 // It creates a new Vary metadata entry and updates the cache flags.
 func (v *VaryProcessor) upgrade(c *Caching, resp *http.Response, id *object.ID, varyData string) (*object.Metadata, error) {
@@ -341,7 +361,7 @@ func WithVaryMaxLimit(limit int) VaryOption {
 func WithVaryIgnoreKeys(keys ...string) VaryOption {
 	return func(r *VaryProcessor) {
 		for _, key := range keys {
-			r.varyIgnoreKey[key] = struct{}{}
+			r.varyIgnoreKey[http.CanonicalHeaderKey(key)] = struct{}{}
 		}
 	}
 }
@@ -22,8 +22,9 @@ import (
 	"github.com/omalloc/tavern/api/defined/v1/storage/object"
 	"github.com/omalloc/tavern/contrib/log"
 	"github.com/omalloc/tavern/internal/protocol"
-	"github.com/omalloc/tavern/pkg/traces"
+	"github.com/omalloc/tavern/pkg/cachekey"
 	"github.com/omalloc/tavern/pkg/iobuf"
+	"github.com/omalloc/tavern/pkg/traces"
 	xhttp "github.com/omalloc/tavern/pkg/x/http"
 	"github.com/omalloc/tavern/proxy"
 )
@@ -44,6 +45,8 @@ type Caching struct {
 	md           *object.Metadata
 	rootmd       *object.Metadata
 	bucket       storage.Bucket
+	fallback     storage.Bucket // disk bucket of the object forced into memory bucket
+	policy       *policyRule    // matched caching policy rule, nil if no rule matched
 	proxyClient  proxy.Proxy
 	chunkFlight  *ChunkFlightGroup
 	cacheStatus  storage.CacheStatus
@@ -51,6 +54,9 @@ type Caching struct {
 	hit          bool
 	prefetch     bool
 	revalidate   bool
+	stale        bool // serve expired object (stale-if-error / stale-while-revalidate)
+	chunksHit    int  // chunks of requested range found in cache
+	chunksTotal  int
 	fileChanged  bool
 	noContentLen bool // noContentLen indicates whether the content length is omitted in the HTTP response.
 	migration    bool // cache migration
@@ -69,6 +75,14 @@ func (c *Caching) markCacheStatus(start, end int64) {
 	first := uint32(start / psize)
 	last := uint32(end / psize)
 
+	c.chunksTotal = int(last-first) + 1
+	c.chunksHit = 0
+	for i := first; i <= last; i++ {
+		if c.md.Chunks.Contains(i) {
+			c.chunksHit++
+		}
+	}
+
 	// full hit
 	if iobuf.FullHit(first, last, c.md.Chunks) {
 		c.cacheStatus = storage.CacheHit
@@ -97,6 +111,9 @@ func (c *Caching) setXCache(resp *http.Response) {
 
 	tr := traces.FromContext(c.req.Context())
 	tr.CacheStatus = c.cacheStatus.String()
+	tr.Bucket = c.bucket.ID()
+	tr.ChunksHit = c.chunksHit
+	tr.ChunksTotal = c.chunksTotal
 
 	// debug header
 	if trace := c.req.Header.Get(protocol.InternalTraceKey); trace != "" {
@@ -114,6 +131,9 @@ func (c *Caching) reset() {
 	c.hit = false
 	c.prefetch = false
 	c.revalidate = false
+	c.stale = false
+	c.chunksHit = 0
+	c.chunksTotal = 0
 	c.fileChanged = false
 	c.noContentLen = false
 	c.migration = false
@@ -307,6 +327,8 @@ func cloneRequest(req *http.Request) *http.Request {
 	}
 	xhttp.CopyHeader(proxyReq.Header, req.Header)
 	xhttp.RemoveHopByHopHeaders(proxyReq.Header)
+	// gateway control header, not forwarded to the origin
+	proxyReq.Header.Del(protocol.ProtocolForceStoreMemory)
 
 	// custom upstream addr
 	if upsAddr := req.Header.Get(protocol.InternalUpstreamAddr); upsAddr != "" {
@@ -318,17 +340,9 @@ func cloneRequest(req *http.Request) *http.Request {
 	return proxyReq
 }
 
-func newObjectIDFromRequest(req *http.Request, vd string, includeQuery bool) (*object.ID, error) {
-	// option: cache-key include querystring
-	//
-	// TODO: get cache-key from frontend protocol rule.
-
-	// or later default rule.
-	if includeQuery {
-		return object.NewVirtualID(req.URL.String(), vd), nil
-	}
-
-	return object.NewVirtualID(fmt.Sprintf("%s://%s%s", req.URL.Scheme, req.Host, req.URL.Path), vd), nil
+// newObjectIDFromRequest returns the object ID of the cache key built by `cache_key` rules.
+func newObjectIDFromRequest(req *http.Request, vd string, keys *cachekey.Builder) (*object.ID, error) {
+	return object.NewVirtualID(keys.Key(req), vd), nil
 }
 
 func closeBody(resp *http.Response) {
@@ -336,3 +350,28 @@ func closeBody(resp *http.Response) {
 		_ = resp.Body.Close()
 	}
 }
+
+// releaseOnClose defers the release until the response body is closed,
+// the body still reads chunk files of the bucket after RoundTrip returned.
+func releaseOnClose(resp *http.Response, release func()) {
+	if resp == nil || resp.Body == nil {
+		release()
+		return
+	}
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+}
+
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+// WriteTo keeps the io.WriterTo of the underlying body.
+func (r *releaseBody) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, r.ReadCloser)
+}
+
+func (r *releaseBody) Close() error {
+	defer r.release()
+	return r.ReadCloser.Close()
+}
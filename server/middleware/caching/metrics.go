@@ -38,6 +38,14 @@ var (
 		Name:      "cache_fillrange_total",
 		Help:      "The total number of fillrange upstream sub-requests triggered by partial cache hits",
 	}, []string{"store_type"})
+
+	// cacheForceMemoryTotal counts the objects forced into memory bucket (X-FS-Mem / force_memory).
+	// Labels: result (memory/fallback), fallback means the memory bucket is full and stored on disk.
+	cacheForceMemoryTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: pkgmetrics.Namespace,
+		Name:      "cache_force_memory_total",
+		Help:      "The total number of objects forced into memory bucket by result",
+	}, []string{"result"})
 )
 
 func init() {
@@ -46,5 +54,6 @@ func init() {
 		cacheChunkWriteTotal,
 		cacheFlushFailedTotal,
 		cacheFillrangeTotal,
+		cacheForceMemoryTotal,
 	)
 }
@@ -1,6 +1,7 @@
 package caching
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,6 +14,8 @@ import (
 	"github.com/omalloc/tavern/api/defined/v1/storage"
 	"github.com/omalloc/tavern/contrib/log"
 	"github.com/omalloc/tavern/proxy"
+	"github.com/omalloc/tavern/storage/bucket/empty"
+	"github.com/omalloc/tavern/storage/sharedkv"
 )
 
 // Processor defines the interface for caching processor middleware.
@@ -25,6 +28,12 @@ type Processor interface {
 	PostRequest(caching *Caching, req *http.Request, resp *http.Response) (*http.Response, error)
 }
 
+// nopBucket is used when no bucket is available (e.g. all disks are bad), request BYPASS.
+var nopBucket, _ = empty.New(&storage.BucketConfig{}, sharedkv.NewEmpty())
+
+// errStorageHandoff the storage is released to the new process during hot upgrade, request BYPASS.
+var errStorageHandoff = errors.New("storage handed off")
+
 // ProcessorChain represents a chain of caching processors.
 type ProcessorChain []Processor
 
@@ -90,13 +99,23 @@ func (pc *ProcessorChain) preCacheProcessor(proxyClient proxy.Proxy, store stora
 		processor:   pc,
 		opt:         opt,
 		req:         req,
+		bucket:      nopBucket, // replaced by the selected bucket
 	}
 
-	objectID, err := newObjectIDFromRequest(req, "", opt.IncludeQueryInCacheKey)
+	objectID, err := newObjectIDFromRequest(req, "", opt.cacheKey)
 	if err != nil {
 		return caching, fmt.Errorf("failed new object-objectID from request err: %w", err)
 	}
 	caching.id = objectID
+	caching.policy = opt.matchPolicy(req)
+
+	if caching.policy.bypass() {
+		return caching, errPolicyBypass
+	}
+
+	if store == nil {
+		return caching, errStorageHandoff
+	}
 
 	// Select storage bucket by object ID
 	// hashring or diskhash
@@ -105,11 +124,19 @@ func (pc *ProcessorChain) preCacheProcessor(proxyClient proxy.Proxy, store stora
 		// fallback EMPTY storage
 		return caching, fmt.Errorf("failed select bucket for objectID: %s", objectID)
 	}
-	caching.bucket = bucket
 
 	// lookup cache with cache-key
 	md, _ := bucket.Lookup(req.Context(), objectID)
 
+	// X-FS-Mem: the missed object is stored into memory bucket, the selected one is the fallback.
+	if md == nil && opt.forceMemory(req) {
+		if mem := selectMemory(req.Context(), store, objectID); mem != nil && mem != bucket {
+			caching.fallback = bucket
+			bucket = mem
+		}
+	}
+	caching.bucket = bucket
+
 	// TODO: object pool for Caching struct
 
 	caching.md = md
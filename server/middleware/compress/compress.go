@@ -0,0 +1,225 @@
+package compress
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	configv1 "github.com/omalloc/tavern/api/defined/v1/middleware"
+	"github.com/omalloc/tavern/pkg/x/http/encodingcontrol"
+	"github.com/omalloc/tavern/server/middleware"
+)
+
+type middlewareOption struct {
+	Types         []string `json:"types" yaml:"types"`                   // 可压缩的 Content-Type, 支持通配 e.g. `text/*`
+	MinLength     int64    `json:"min_length" yaml:"min_length"`         // 小于该长度不压缩, 默认 1024, 未知长度(chunked)总是压缩
+	Encodings     []string `json:"encodings" yaml:"encodings"`           // 服务端编码优先级, 默认 br, zstd, gzip
+	CacheVariants bool     `json:"cache_variants" yaml:"cache_variants"` // 压缩结果按 Accept-Encoding 作为 vary 版本缓存
+}
+
+var defaultTypes = []string{
+	"text/*",
+	"application/json",
+	"application/*+json",
+	"application/javascript",
+	"application/x-javascript",
+	"application/xml",
+	"application/*+xml",
+	"application/wasm",
+	"image/svg+xml",
+	"font/ttf",
+	"font/otf",
+}
+
+type compressor struct {
+	*middlewareOption
+}
+
+func init() {
+	middleware.Register("compress", Middleware)
+}
+
+func Middleware(c *configv1.Middleware) (middleware.Middleware, func(), error) {
+	opts := &middlewareOption{
+		Types:     slices.Clone(defaultTypes),
+		MinLength: 1024,
+		Encodings: []string{encodingcontrol.Brotli, encodingcontrol.Zstd, encodingcontrol.Gzip},
+	}
+	if err := c.Unmarshal(opts); err != nil {
+		return nil, nil, err
+	}
+
+	for i, enc := range opts.Encodings {
+		opts.Encodings[i] = strings.ToLower(enc)
+		if !encodingcontrol.Supported(opts.Encodings[i]) {
+			return nil, nil, fmt.Errorf("compress: unsupported encoding %q", enc)
+		}
+	}
+	for _, t := range opts.Types {
+		if _, err := path.Match(t, ""); err != nil {
+			return nil, nil, fmt.Errorf("compress: invalid type pattern %q: %w", t, err)
+		}
+	}
+
+	cp := &compressor{middlewareOption: opts}
+
+	return func(origin http.RoundTripper) http.RoundTripper {
+		return middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Method != http.MethodGet && req.Method != http.MethodHead {
+				return origin.RoundTrip(req)
+			}
+
+			acceptEncoding := req.Header.Get("Accept-Encoding")
+			encoding := encodingcontrol.Negotiate(acceptEncoding, cp.Encodings)
+			// partial content is never compressed
+			if req.Header.Get("Range") != "" {
+				encoding = encodingcontrol.Identity
+			}
+
+			if cp.CacheVariants {
+				// Accept-Encoding 归一化为协商结果, vary 版本数最多 len(encodings)+1
+				req.Header.Set("Accept-Encoding", encoding)
+				req = req.WithContext(encodingcontrol.WithTransform(req.Context(), cp.transform(encoding)))
+			}
+
+			resp, err := origin.RoundTrip(req)
+			if err != nil || resp == nil {
+				return resp, err
+			}
+
+			cp.negotiate(resp, acceptEncoding, encoding)
+			return resp, nil
+		})
+	}, middleware.EmptyCleanup, nil
+}
+
+// transform compresses the upstream response before it is cached, the `Vary: Accept-Encoding` makes
+// the caching VaryProcessor store each encoding as a separate vary version.
+func (cp *compressor) transform(encoding string) encodingcontrol.Transform {
+	return func(req *http.Request, resp *http.Response) {
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			return
+		}
+		if !cp.compressibleType(resp.Header) {
+			return
+		}
+
+		// identity 版本同样作为 vary 版本存储, 避免普通缓存对象命中所有编码
+		addVary(resp.Header)
+
+		if req.Method != http.MethodGet || resp.StatusCode != http.StatusOK || encoding == encodingcontrol.Identity {
+			return
+		}
+		if encodingcontrol.Of(resp.Header) != encodingcontrol.Identity || !cp.compressible(resp) {
+			return
+		}
+		if cp.encode(resp, encoding) {
+			compressedTotal.WithLabelValues(encoding, "cache").Inc()
+		}
+	}
+}
+
+// negotiate serves the response in the encoding accepted by the client, the stored encoding is
+// decompressed for the clients that cannot accept it, the identity response is compressed on the fly.
+func (cp *compressor) negotiate(resp *http.Response, acceptEncoding, encoding string) {
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+
+	stored := encodingcontrol.Of(resp.Header)
+	if stored != encodingcontrol.Identity {
+		addVary(resp.Header)
+		if encodingcontrol.Accepts(acceptEncoding, stored) || !encodingcontrol.Supported(stored) {
+			return
+		}
+
+		body, err := encodingcontrol.Decode(stored, resp.Body)
+		if err != nil {
+			return
+		}
+		resp.Body = body
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		weakenETag(resp.Header)
+		decompressedTotal.WithLabelValues(stored).Inc()
+	}
+
+	if !cp.compressibleType(resp.Header) {
+		return
+	}
+	addVary(resp.Header)
+
+	if encoding == encodingcontrol.Identity || !cp.compressible(resp) {
+		return
+	}
+	if cp.encode(resp, encoding) {
+		compressedTotal.WithLabelValues(encoding, "response").Inc()
+	}
+}
+
+// encode replaces the identity body of resp with the encoding one.
+func (cp *compressor) encode(resp *http.Response, encoding string) bool {
+	body, err := encodingcontrol.Encode(encoding, resp.Body)
+	if err != nil {
+		return false
+	}
+
+	resp.Body = body
+	resp.Header.Set("Content-Encoding", encoding)
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	weakenETag(resp.Header)
+	return true
+}
+
+// compressible reports whether the identity response is worth compressing.
+func (cp *compressor) compressible(resp *http.Response) bool {
+	if strings.Contains(strings.ToLower(resp.Header.Get("Cache-Control")), "no-transform") {
+		return false
+	}
+
+	length := resp.ContentLength
+	if cl := resp.Header.Get("Content-Length"); cl != "" {
+		length, _ = strconv.ParseInt(cl, 10, 64)
+	}
+	return length < 0 || length >= cp.MinLength
+}
+
+// compressibleType reports whether the Content-Type matches `types`.
+func (cp *compressor) compressibleType(h http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+
+	for _, t := range cp.Types {
+		if ok, _ := path.Match(t, mediaType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func addVary(h http.Header) {
+	for _, v := range h.Values("Vary") {
+		for _, key := range strings.Split(v, ",") {
+			key = strings.TrimSpace(key)
+			if key == "*" || strings.EqualFold(key, "Accept-Encoding") {
+				return
+			}
+		}
+	}
+	h.Add("Vary", "Accept-Encoding")
+}
+
+// weakenETag marks the ETag weak, the encoded representation is not byte-identical to the origin one.
+func weakenETag(h http.Header) {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+}
@@ -0,0 +1,220 @@
+package compress_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	configv1 "github.com/omalloc/tavern/api/defined/v1/middleware"
+	"github.com/omalloc/tavern/internal/protocol"
+	"github.com/omalloc/tavern/pkg/x/http/encodingcontrol"
+	"github.com/omalloc/tavern/proxy"
+	"github.com/omalloc/tavern/server/middleware/caching"
+	"github.com/omalloc/tavern/server/middleware/compress"
+	"github.com/omalloc/tavern/server/middleware/internal/middlewaretest"
+	"github.com/omalloc/tavern/storage"
+)
+
+var jsonBody = strings.Repeat(`{"name":"tavern","cache":true}`, 128)
+
+// staticOrigin responds the same body for every request.
+type staticOrigin struct {
+	header   http.Header
+	body     []byte
+	requests atomic.Int32
+	req      *http.Request
+}
+
+func (o *staticOrigin) RoundTrip(req *http.Request) (*http.Response, error) {
+	o.requests.Add(1)
+	o.req = req
+	header := o.header.Clone()
+	header.Set("Content-Length", strconv.Itoa(len(o.body)))
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        header,
+		ContentLength: int64(len(o.body)),
+		Body:          io.NopCloser(bytes.NewReader(o.body)),
+		Request:       req,
+	}, nil
+}
+
+func newRequest(acceptEncoding string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "http://www.example.com/api/a.json", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	return req
+}
+
+// readBody decodes the body by Content-Encoding.
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+
+	body := resp.Body
+	if enc := encodingcontrol.Of(resp.Header); enc != encodingcontrol.Identity {
+		var err error
+		body, err = encodingcontrol.Decode(enc, resp.Body)
+		assert.NoError(t, err)
+	}
+	buf, err := io.ReadAll(body)
+	assert.NoError(t, err)
+	_ = body.Close()
+	return string(buf)
+}
+
+func TestCompressResponse(t *testing.T) {
+	origin := &staticOrigin{
+		header: http.Header{"Content-Type": {"application/json; charset=utf-8"}, "Etag": {`"v1"`}},
+		body:   []byte(jsonBody),
+	}
+	rt := middlewaretest.NewRoundTripper(t, "compress", origin, nil)
+
+	resp, err := rt.RoundTrip(newRequest("gzip, deflate, br"))
+	assert.NoError(t, err)
+	assert.Equal(t, encodingcontrol.Brotli, resp.Header.Get("Content-Encoding"))
+	assert.Empty(t, resp.Header.Get("Content-Length"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, `W/"v1"`, resp.Header.Get("ETag"))
+	assert.Equal(t, jsonBody, readBody(t, resp))
+	assert.Equal(t, "gzip, deflate, br", origin.req.Header.Get("Accept-Encoding"))
+
+	resp, err = rt.RoundTrip(newRequest("gzip;q=0.5, zstd;q=0.8"))
+	assert.NoError(t, err)
+	assert.Equal(t, encodingcontrol.Zstd, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, jsonBody, readBody(t, resp))
+
+	// identity client, Vary is still set
+	resp, err = rt.RoundTrip(newRequest(""))
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, jsonBody, readBody(t, resp))
+
+	// partial content is never compressed
+	req := newRequest("gzip")
+	req.Header.Set("Range", "bytes=0-9")
+	resp, err = rt.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+
+	// no-transform
+	origin.header.Set("Cache-Control", "max-age=60, no-transform")
+	resp, err = rt.RoundTrip(newRequest("gzip"))
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	origin.header.Del("Cache-Control")
+
+	// not compressible type
+	origin.header.Set("Content-Type", "image/png")
+	resp, err = rt.RoundTrip(newRequest("gzip"))
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Empty(t, resp.Header.Get("Vary"))
+
+	// below min_length
+	origin.header.Set("Content-Type", "application/javascript")
+	origin.body = []byte("var a = 1;")
+	resp, err = rt.RoundTrip(newRequest("gzip"))
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "10", resp.Header.Get("Content-Length"))
+}
+
+func TestDecompressStored(t *testing.T) {
+	var encoded bytes.Buffer
+	w, err := encodingcontrol.NewWriter(encodingcontrol.Gzip, &encoded)
+	assert.NoError(t, err)
+	_, _ = w.Write([]byte(jsonBody))
+	assert.NoError(t, w.Close())
+
+	origin := &staticOrigin{
+		header: http.Header{"Content-Type": {"text/javascript"}, "Content-Encoding": {"gzip"}},
+		body:   encoded.Bytes(),
+	}
+	rt := middlewaretest.NewRoundTripper(t, "compress", origin, map[string]any{"encodings": []any{"zstd", "gzip"}})
+
+	// stored encoding accepted, served as is
+	resp, err := rt.RoundTrip(newRequest("gzip, zstd"))
+	assert.NoError(t, err)
+	assert.Equal(t, encodingcontrol.Gzip, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, strconv.Itoa(encoded.Len()), resp.Header.Get("Content-Length"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+
+	// decompressed for the identity client
+	resp, err = rt.RoundTrip(newRequest(""))
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Empty(t, resp.Header.Get("Content-Length"))
+	assert.Equal(t, jsonBody, readBody(t, resp))
+
+	// decompressed and compressed by the encoding the client accepts
+	resp, err = rt.RoundTrip(newRequest("zstd"))
+	assert.NoError(t, err)
+	assert.Equal(t, encodingcontrol.Zstd, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, jsonBody, readBody(t, resp))
+}
+
+func TestCacheVariants(t *testing.T) {
+	origin := &staticOrigin{
+		header: http.Header{"Content-Type": {"application/json"}, "Cache-Control": {"max-age=600"}},
+		body:   []byte(jsonBody),
+	}
+	proxy.SetDefault(&middlewaretest.Proxy{RoundTripper: origin})
+	defer proxy.SetDefault(nil)
+
+	bucket := middlewaretest.NewMemoryBucket(t)
+	storage.SetDefault(&middlewaretest.BucketStorage{Bucket: bucket})
+	defer storage.SetDefault(nil)
+
+	cachingMw, _, err := caching.Middleware(&configv1.Middleware{Name: "caching", Options: map[string]any{"hostname": "edge-1"}})
+	assert.NoError(t, err)
+	rt := middlewaretest.NewRoundTripper(t, "compress", cachingMw(nil), map[string]any{"cache_variants": true})
+
+	fetch := func(acceptEncoding string) *http.Response {
+		resp, err := rt.RoundTrip(newRequest(acceptEncoding))
+		assert.NoError(t, err)
+		return resp
+	}
+
+	for _, tc := range []struct {
+		acceptEncoding string
+		encoding       string
+		requests       int32
+		cacheStatus    string
+	}{
+		{"gzip, br", encodingcontrol.Brotli, 1, "MISS"},
+		{"br", encodingcontrol.Brotli, 1, "HIT"},
+		{"gzip", encodingcontrol.Gzip, 2, "MISS"},
+		{"", "", 3, "MISS"},
+		{"deflate", "", 3, "HIT"},
+		{"gzip;q=1, br;q=0.1", encodingcontrol.Gzip, 3, "HIT"},
+	} {
+		resp := fetch(tc.acceptEncoding)
+		assert.Equal(t, tc.encoding, resp.Header.Get("Content-Encoding"), tc.acceptEncoding)
+		assert.Equal(t, jsonBody, readBody(t, resp), tc.acceptEncoding)
+		assert.True(t, strings.HasPrefix(resp.Header.Get(protocol.ProtocolCacheStatusKey), tc.cacheStatus),
+			"%q %s", tc.acceptEncoding, resp.Header.Get(protocol.ProtocolCacheStatusKey))
+		assert.Equal(t, tc.requests, origin.requests.Load(), tc.acceptEncoding)
+	}
+
+	// the origin is asked for the identity body
+	assert.Equal(t, encodingcontrol.Identity, origin.req.Header.Get("Accept-Encoding"))
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, options := range []map[string]any{
+		{"encodings": []any{"deflate"}},
+		{"types": []any{"text/["}},
+	} {
+		_, _, err := compress.Middleware(&configv1.Middleware{Name: "compress", Options: options})
+		assert.Error(t, err, "%v", options)
+	}
+}
@@ -0,0 +1,27 @@
+package compress
+
+import (
+	pkgmetrics "github.com/omalloc/tavern/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// compressedTotal counts the compressed responses by encoding and stage,
+	// `cache` compressed before saved as the vary version, `response` compressed on the fly.
+	compressedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: pkgmetrics.Namespace,
+		Name:      "compress_compressed_total",
+		Help:      "The total number of compressed responses by encoding and stage",
+	}, []string{"encoding", "stage"})
+
+	// decompressedTotal counts the responses decompressed for the clients not accepting the stored encoding.
+	decompressedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: pkgmetrics.Namespace,
+		Name:      "compress_decompressed_total",
+		Help:      "The total number of responses decompressed for the client by the stored encoding",
+	}, []string{"encoding"})
+)
+
+func init() {
+	prometheus.MustRegister(compressedTotal, decompressedTotal)
+}
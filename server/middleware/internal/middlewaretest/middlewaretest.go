@@ -0,0 +1,65 @@
+// Package middlewaretest provides the fake storage, upstream proxy and middleware helpers
+// shared by the tests of the middlewares.
+package middlewaretest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/omalloc/proxy/selector"
+	"github.com/stretchr/testify/assert"
+
+	configv1 "github.com/omalloc/tavern/api/defined/v1/middleware"
+	storagev1 "github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+	"github.com/omalloc/tavern/proxy"
+	"github.com/omalloc/tavern/server/middleware"
+	"github.com/omalloc/tavern/storage/bucket/memory"
+	"github.com/omalloc/tavern/storage/sharedkv"
+)
+
+// NewRoundTripper creates the registered middleware name with options in front of origin.
+func NewRoundTripper(t *testing.T, name string, origin http.RoundTripper, options map[string]any) http.RoundTripper {
+	t.Helper()
+
+	mw, _, err := middleware.Create(&configv1.Middleware{Name: name, Options: options})
+	assert.NoError(t, err)
+	return mw(origin)
+}
+
+// NewMemoryBucket creates a warm memory bucket closed with the test.
+func NewMemoryBucket(t *testing.T) storagev1.Bucket {
+	t.Helper()
+
+	bucket, err := memory.New(&storagev1.BucketConfig{Driver: "memory", Type: storagev1.TypeWarm}, sharedkv.NewEmpty())
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = bucket.Close() })
+	return bucket
+}
+
+// BucketStorage is a storage of the single bucket.
+type BucketStorage struct {
+	storagev1.Bucket
+}
+
+func (s *BucketStorage) Select(context.Context, *object.ID) storagev1.Bucket { return s.Bucket }
+func (s *BucketStorage) Rebuild(context.Context, []storagev1.Bucket) error   { return nil }
+func (s *BucketStorage) Buckets() []storagev1.Bucket                         { return []storagev1.Bucket{s.Bucket} }
+func (s *BucketStorage) SharedKV() storagev1.SharedKV                        { return sharedkv.NewEmpty() }
+func (s *BucketStorage) PURGE(string, storagev1.PurgeControl) error          { return nil }
+
+// Proxy is the upstream proxy of the caching middleware sending all the requests to origin.
+type Proxy struct {
+	http.RoundTripper
+}
+
+var _ proxy.Proxy = (*Proxy)(nil)
+
+func (p *Proxy) Do(req *http.Request, _ bool, _ time.Duration) (*http.Response, error) {
+	return p.RoundTrip(req)
+}
+func (p *Proxy) DoLoopback(req *http.Request) (*http.Response, error) { return p.RoundTrip(req) }
+func (p *Proxy) Apply([]selector.Node)                                {}
+func (p *Proxy) Health() []proxy.NodeStatus                           { return nil }
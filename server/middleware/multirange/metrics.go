@@ -0,0 +1,26 @@
+package multirange
+
+import (
+	pkgmetrics "github.com/omalloc/tavern/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// rejectedTotal counts the multi-range requests rejected by `max_ranges`.
+	rejectedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: pkgmetrics.Namespace,
+		Name:      "multirange_rejected_total",
+		Help:      "The total number of multi-range requests rejected by max_ranges",
+	})
+
+	// partsTotal counts the parts of the multi-range responses by source, `cache` or `upstream`.
+	partsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: pkgmetrics.Namespace,
+		Name:      "multirange_parts_total",
+		Help:      "The total number of multi-range response parts by source",
+	}, []string{"source"})
+)
+
+func init() {
+	prometheus.MustRegister(rejectedTotal, partsTotal)
+}
@@ -1,32 +1,47 @@
 package multirange
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"slices"
 	"strconv"
 
 	configv1 "github.com/omalloc/tavern/api/defined/v1/middleware"
+	"github.com/omalloc/tavern/api/defined/v1/storage"
 	"github.com/omalloc/tavern/contrib/log"
 	"github.com/omalloc/tavern/pkg/traces"
 	xhttp "github.com/omalloc/tavern/pkg/x/http"
 	"github.com/omalloc/tavern/pkg/x/http/rangecontrol"
 	"github.com/omalloc/tavern/server/middleware"
+	"github.com/omalloc/tavern/server/middleware/caching"
 )
 
-type middlewareOption struct{}
+type middlewareOption struct {
+	Merge       bool   `json:"merge" yaml:"merge"`               // 合并相邻/重叠的 range, 合并后只剩一个时按单 range 请求
+	Concurrency int    `json:"concurrency" yaml:"concurrency"`   // 并发子请求数, 默认 4, 1 为串行
+	MaxRanges   int    `json:"max_ranges" yaml:"max_ranges"`     // 单请求 range 数上限, 超出返回 416, 0 不限制
+	CacheLookup bool   `json:"cache_lookup" yaml:"cache_lookup"` // 从缓存索引获取对象信息, 已缓存的 part 不回源, 默认开启
+	Listener    string `json:"listener,omitempty" yaml:"-"`      // 由 server 注入, 查找同一 listener 的 caching 中间件
+}
 
 func init() {
 	middleware.Register("multirange", Middleware)
 }
 
 func Middleware(c *configv1.Middleware) (middleware.Middleware, func(), error) {
-	var opts middlewareOption
+	opts := middlewareOption{
+		Concurrency: 4,
+		MaxRanges:   64,
+		CacheLookup: true,
+	}
 	if err := c.Unmarshal(&opts); err != nil {
 		return nil, nil, err
 	}
+	opts.Concurrency = max(opts.Concurrency, 1)
 
 	cleanup := func() {}
 
@@ -38,11 +53,6 @@ func Middleware(c *configv1.Middleware) (middleware.Middleware, func(), error) {
 				return origin.RoundTrip(req)
 			}
 
-			// unsafeRanges, err := xhttp.UnsatisfiableMultiRange(rawRange)
-			// if err != nil || len(unsafeRanges) <= 1 {
-			// 	return origin.RoundTrip(req)
-			// }
-
 			byteRanges, err := rangecontrol.Parse(rawRange)
 			if err != nil {
 				// Range 解析失败，返回 416
@@ -51,62 +61,101 @@ func Middleware(c *configv1.Middleware) (middleware.Middleware, func(), error) {
 				return nil, xhttp.NewBizError(http.StatusRequestedRangeNotSatisfiable, headers)
 			}
 
-			mergedByteRanges := rangecontrol.MergeRanges(byteRanges)
-			if len(mergedByteRanges) <= 1 {
+			// range 放大攻击: 大量小 range 引发大量子请求
+			if opts.MaxRanges > 0 && len(byteRanges) > opts.MaxRanges {
+				rejectedTotal.Inc()
+				headers := make(http.Header)
+				headers.Set("X-Error", fmt.Sprintf("too many ranges %d > %d", len(byteRanges), opts.MaxRanges))
+				return nil, xhttp.NewBizError(http.StatusRequestedRangeNotSatisfiable, headers)
+			}
+
+			if len(byteRanges) <= 1 {
 				return origin.RoundTrip(req)
 			}
 
-			header := make(http.Header)
+			if opts.Merge {
+				sorted := slices.Clone(byteRanges)
+				rangecontrol.SortRanges(sorted)
+				byteRanges = rangecontrol.MergeRanges(sorted)
 
-			head, err1 := prefetchResource(req, origin)
-			if err1 != nil {
-				return nil, err1
-			}
-			if head.Body != nil {
-				_ = head.Body.Close()
+				// 合并为单个 range, 直接按单 range 请求
+				if len(byteRanges) == 1 {
+					single := req.Clone(req.Context())
+					single.Header.Set("Range", byteRanges[0].String())
+					return origin.RoundTrip(single)
+				}
 			}
 
-			objSize := uint64(head.ContentLength) // ContentLength 不一定存在
-			cr, err := rangecontrol.ParseContentRange(head.Header.Get("Content-Range"))
-			if err == nil {
-				// 如果源站支持 Range 头响应，则使用其总大小
-				objSize = uint64(cr.Size)
-			}
+			header := make(http.Header)
 
-			ctype := head.Header.Get("Content-Type")
-			xhttp.CopyHeadersWithout(header, head.Header, "Content-Length", "Content-Range", "Content-Type")
+			var (
+				ctype   string
+				objSize uint64
+				fetch   = func(ctx context.Context, ra rangecontrol.ByteRange) (io.ReadCloser, error) {
+					return fetchPart(ctx, req, origin, ra)
+				}
+			)
 
-			sendSize := rangesMIMESize(byteRanges, ctype, objSize)
-			pr, pw := io.Pipe()
-			mw := multipart.NewWriter(pw)
+			// 已缓存的对象直接使用索引中的 size/content-type, 已缓存的 part 直接读取 chunk 文件
+			obj, ok := lookupCached(req, opts)
+			if ok {
+				objSize = obj.Size()
+				byteRanges = clampRanges(byteRanges, objSize)
 
-			go func() {
+				status := storage.CacheHit
 				for _, ra := range byteRanges {
-					part, err3 := mw.CreatePart(ra.MimeHeader(ctype, objSize))
-					if err3 != nil {
-						log.Errorf("create part failed: %s", err3)
-						_ = pw.CloseWithError(err3)
-						return
+					if !obj.Cached(ra.Start, ra.End) {
+						status = storage.CachePartHit
+						break
 					}
+				}
+				cachedHeader := obj.Header(req, status)
+				ctype = cachedHeader.Get("Content-Type")
+				xhttp.CopyHeadersWithout(header, cachedHeader, "Content-Type")
 
-					// copy request-id
-					newTr := traces.FromContext(req.Context()).Clone()
-					newTr.RequestID = fmt.Sprintf("%s@%d-%d", newTr.RequestID, ra.Start, ra.End)
-					workerRequest := req.Clone(req.Context())
-					workerRequest = workerRequest.WithContext(traces.NewContext(workerRequest.Context(), newTr))
-
-					workerRequest.Header.Set("Range", ra.String())
-					raResp, err3 := origin.RoundTrip(workerRequest)
-					if err3 != nil {
-						_ = pw.CloseWithError(err3)
-						return
+				fetch = func(ctx context.Context, ra rangecontrol.ByteRange) (io.ReadCloser, error) {
+					if obj.Cached(ra.Start, ra.End) {
+						body, err := obj.ReadRange(req, ra.Start, ra.End)
+						if err == nil {
+							partsTotal.WithLabelValues("cache").Inc()
+							return body, nil
+						}
+						log.Context(req.Context()).Warnf("multirange read cached range %s failed: %s", ra.String(), err)
 					}
-					if _, err3 = io.Copy(part, raResp.Body); err3 != nil &&
-						!errors.Is(err3, io.EOF) {
+					return fetchPart(ctx, req, origin, ra)
+				}
+			} else {
+				head, err1 := prefetchResource(req, origin)
+				if err1 != nil {
+					return nil, err1
+				}
+				if head.Body != nil {
+					_ = head.Body.Close()
+				}
 
-						_ = pw.CloseWithError(err3)
-						return
-					}
+				objSize = uint64(head.ContentLength) // ContentLength 不一定存在
+				cr, err := rangecontrol.ParseContentRange(head.Header.Get("Content-Range"))
+				if err == nil {
+					// 如果源站支持 Range 头响应，则使用其总大小
+					objSize = uint64(cr.Size)
+				}
+
+				ctype = head.Header.Get("Content-Type")
+				xhttp.CopyHeadersWithout(header, head.Header, "Content-Length", "Content-Range", "Content-Type")
+			}
+
+			sendSize := rangesMIMESize(byteRanges, ctype, objSize)
+			pr, pw := io.Pipe()
+			mw := multipart.NewWriter(pw)
+
+			go func() {
+				if obj != nil {
+					defer obj.Close()
+				}
+				if err3 := writeParts(req.Context(), mw, byteRanges, ctype, objSize, opts.Concurrency, fetch); err3 != nil {
+					log.Errorf("multirange write parts failed: %s", err3)
+					_ = pw.CloseWithError(err3)
+					return
 				}
 				_ = mw.Close()
 				_ = pw.Close()
@@ -125,6 +174,158 @@ func Middleware(c *configv1.Middleware) (middleware.Middleware, func(), error) {
 	}, cleanup, nil
 }
 
+type partResult struct {
+	body io.ReadCloser
+	err  error
+}
+
+// writeParts 按请求顺序写出每个 range 的 part.
+//
+// 最多 concurrency 个子请求同时进行, 子请求按顺序获取并发槽位,
+// part 写出完成后才释放槽位, 因此当前待写出的 part 总能拿到槽位.
+func writeParts(ctx context.Context, mw *multipart.Writer, ranges []rangecontrol.ByteRange, ctype string, objSize uint64, concurrency int,
+	fetch func(ctx context.Context, ra rangecontrol.ByteRange) (io.ReadCloser, error)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sem := make(chan struct{}, concurrency)
+	results := make([]chan partResult, len(ranges))
+	for i := range results {
+		results[i] = make(chan partResult, 1)
+	}
+
+	go func() {
+		for i, ra := range ranges {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				// 未发起的 part 不会再有结果
+				for j := i; j < len(ranges); j++ {
+					close(results[j])
+				}
+				return
+			}
+			go func() {
+				body, err := fetch(ctx, ra)
+				results[i] <- partResult{body: body, err: err}
+			}()
+		}
+	}()
+
+	// 出错返回时关闭已获取但未写出的 part
+	written := 0
+	defer func() {
+		cancel()
+		for i := written; i < len(ranges); i++ {
+			go func(ch chan partResult) {
+				if r, ok := <-ch; ok && r.body != nil {
+					_ = r.body.Close()
+				}
+			}(results[i])
+		}
+	}()
+
+	for i, ra := range ranges {
+		var (
+			r  partResult
+			ok bool
+		)
+		select {
+		case r, ok = <-results[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		written = i + 1
+
+		if !ok {
+			return ctx.Err()
+		}
+
+		if r.err != nil {
+			return r.err
+		}
+
+		part, err := mw.CreatePart(ra.MimeHeader(ctype, objSize))
+		if err != nil {
+			_ = r.body.Close()
+			return fmt.Errorf("create part failed: %w", err)
+		}
+
+		_, err = io.Copy(part, r.body)
+		_ = r.body.Close()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		<-sem
+	}
+	return nil
+}
+
+// fetchPart 发起单个 range 的子请求.
+func fetchPart(ctx context.Context, req *http.Request, origin http.RoundTripper, ra rangecontrol.ByteRange) (io.ReadCloser, error) {
+	partsTotal.WithLabelValues("upstream").Inc()
+
+	// copy request-id
+	newTr := traces.FromContext(req.Context()).Clone()
+	newTr.RequestID = fmt.Sprintf("%s@%d-%d", newTr.RequestID, ra.Start, ra.End)
+	// 同一客户端按顺序读取各 part, 不参与 caching 的合并回源
+	workerRequest := req.Clone(caching.WithoutCollapse(traces.NewContext(ctx, newTr)))
+	workerRequest.Header.Set("Range", ra.String())
+
+	resp, err := origin.RoundTrip(workerRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	// 非 206 响应 (如完整的 200) 写入 part 会破坏 multipart 内容
+	if resp.StatusCode != http.StatusPartialContent {
+		if resp.Body != nil {
+			_ = resp.Body.Close()
+		}
+		return nil, fmt.Errorf("range %s upstream returns status %d", ra.String(), resp.StatusCode)
+	}
+
+	// 其他 range 的响应 (如合并回源返回的 206) 同样会写错 part
+	if cr, err := rangecontrol.ParseContentRange(resp.Header.Get("Content-Range")); err != nil || !partMatches(cr, ra) {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("range %s upstream returns Content-Range %q", ra.String(), resp.Header.Get("Content-Range"))
+	}
+	return resp.Body, nil
+}
+
+// partMatches 检查子请求响应的 Content-Range 是否为请求的 range, 超出对象大小的 range 按对象末尾比较.
+func partMatches(cr *rangecontrol.ContentRange, ra rangecontrol.ByteRange) bool {
+	if cr.Unsatisfied || cr.Start != ra.Start {
+		return false
+	}
+
+	end := ra.End
+	if cr.Size > 0 && (end < 0 || end >= cr.Size) {
+		end = cr.Size - 1
+	}
+	return end < 0 || cr.End == end
+}
+
+// lookupCached 查找已缓存的对象, 找不到时回退 HEAD 预取.
+func lookupCached(req *http.Request, opts middlewareOption) (*caching.CachedObject, bool) {
+	if !opts.CacheLookup || req.Method != http.MethodGet {
+		return nil, false
+	}
+	return caching.Lookup(opts.Listener, req)
+}
+
+// clampRanges 将 open-ended 及超出对象大小的 range 截断到对象末尾.
+func clampRanges(ranges []rangecontrol.ByteRange, size uint64) []rangecontrol.ByteRange {
+	clamped := make([]rangecontrol.ByteRange, len(ranges))
+	for i, ra := range ranges {
+		if ra.End < 0 || ra.End >= int64(size) {
+			ra.End = int64(size) - 1
+		}
+		clamped[i] = ra
+	}
+	return clamped
+}
+
 // 发起 HEAD 请求获取资源信息; content-type, content-length
 func prefetchResource(req *http.Request, next http.RoundTripper) (*http.Response, error) {
 	newreq := req.Clone(req.Context())
@@ -0,0 +1,292 @@
+package multirange_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	configv1 "github.com/omalloc/tavern/api/defined/v1/middleware"
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+	"github.com/omalloc/tavern/internal/protocol"
+	xhttp "github.com/omalloc/tavern/pkg/x/http"
+	"github.com/omalloc/tavern/proxy"
+	"github.com/omalloc/tavern/server/middleware"
+	"github.com/omalloc/tavern/server/middleware/caching"
+	"github.com/omalloc/tavern/server/middleware/internal/middlewaretest"
+	"github.com/omalloc/tavern/storage"
+)
+
+// rangeOrigin serves the byte ranges of body and records the peak of in-flight sub-requests,
+// the sub-request of the first range is the slowest.
+type rangeOrigin struct {
+	body     []byte
+	inflight atomic.Int32
+	peak     atomic.Int32
+
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (o *rangeOrigin) RoundTrip(req *http.Request) (*http.Response, error) {
+	rng, err := xhttp.SingleRange(req.Header.Get("Range"), uint64(len(o.body)))
+	if err != nil {
+		return nil, err
+	}
+
+	o.mu.Lock()
+	o.ranges = append(o.ranges, req.Header.Get("Range"))
+	o.mu.Unlock()
+
+	n := o.inflight.Add(1)
+	defer o.inflight.Add(-1)
+	for {
+		peak := o.peak.Load()
+		if n <= peak || o.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	if req.Method == http.MethodGet {
+		delay := 20 * time.Millisecond
+		if rng.Start == 0 {
+			delay = 50 * time.Millisecond
+		}
+		time.Sleep(delay)
+	}
+
+	header := make(http.Header)
+	header.Set("Content-Type", "video/mp4")
+	header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rng.Start, rng.End, len(o.body)))
+	header.Set("Content-Length", strconv.FormatInt(rng.End-rng.Start+1, 10))
+	return &http.Response{
+		StatusCode:    http.StatusPartialContent,
+		Header:        header,
+		ContentLength: rng.End - rng.Start + 1,
+		Body:          io.NopCloser(bytes.NewReader(o.body[rng.Start : rng.End+1])),
+	}, nil
+}
+
+func readParts(t *testing.T, resp *http.Response) []string {
+	t.Helper()
+
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, resp.Header.Get("Content-Length"), strconv.Itoa(len(body)))
+
+	parts := make([]string, 0)
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		buf, _ := io.ReadAll(part)
+		parts = append(parts, part.Header.Get("Content-Range")+" "+string(buf))
+	}
+	return parts
+}
+
+func newRequest(rawRange string) *http.Request {
+	req, _ := http.NewRequest(http.MethodGet, "http://www.example.com/video.mp4", nil)
+	req.Header.Set("Range", rawRange)
+	return req
+}
+
+func TestParallelParts(t *testing.T) {
+	origin := &rangeOrigin{body: []byte("0123456789abcdefghij")}
+	rt := middlewaretest.NewRoundTripper(t, "multirange", origin, map[string]any{"concurrency": 3})
+
+	resp, err := rt.RoundTrip(newRequest("bytes=0-1,4-5,8-9,12-13"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+
+	// the parts are in request order even though the first one is the slowest
+	assert.Equal(t, []string{
+		"bytes 0-1/20 01",
+		"bytes 4-5/20 45",
+		"bytes 8-9/20 89",
+		"bytes 12-13/20 cd",
+	}, readParts(t, resp))
+	assert.Equal(t, int32(3), origin.peak.Load())
+}
+
+func TestMergeRanges(t *testing.T) {
+	origin := &rangeOrigin{body: []byte("0123456789abcdefghij")}
+	rt := middlewaretest.NewRoundTripper(t, "multirange", origin, map[string]any{"merge": true})
+
+	resp, err := rt.RoundTrip(newRequest("bytes=10-12,0-3,2-5,13-14"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bytes 0-5/20 012345", "bytes 10-14/20 abcde"}, readParts(t, resp))
+
+	// merged into one range, no multipart
+	resp, err = rt.RoundTrip(newRequest("bytes=0-3,4-7"))
+	assert.NoError(t, err)
+	assert.Equal(t, "bytes 0-7/20", resp.Header.Get("Content-Range"))
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "01234567", string(body))
+}
+
+func TestMaxRanges(t *testing.T) {
+	origin := &rangeOrigin{body: []byte("0123456789")}
+	rt := middlewaretest.NewRoundTripper(t, "multirange", origin, map[string]any{"max_ranges": 2})
+
+	_, err := rt.RoundTrip(newRequest("bytes=0-0,2-2,4-4"))
+	biz, ok := xhttp.ParseBizError(err)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, biz.Code())
+	assert.Empty(t, origin.ranges)
+}
+
+func TestPartNotPartialContent(t *testing.T) {
+	origin := &rangeOrigin{body: []byte("0123456789")}
+	rt := middlewaretest.NewRoundTripper(t, "multirange", middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("Range") == "bytes=4-5" {
+			return &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: http.NoBody}, nil
+		}
+		return origin.RoundTrip(req)
+	}), nil)
+
+	resp, err := rt.RoundTrip(newRequest("bytes=0-1,4-5"))
+	assert.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	assert.Error(t, err)
+}
+
+func TestCachedParts(t *testing.T) {
+	_, cleanup, err := caching.Middleware(&configv1.Middleware{Name: "caching", Options: map[string]any{"hostname": "edge-1", "listener": "http"}})
+	assert.NoError(t, err)
+	defer cleanup()
+
+	bucket := middlewaretest.NewMemoryBucket(t)
+	storage.SetDefault(&middlewaretest.BucketStorage{Bucket: bucket})
+	defer storage.SetDefault(nil)
+
+	// chunk 0, 1 of the 4 bytes block are cached
+	ctx := context.Background()
+	body := []byte("0123456789abcdefghij")
+	md := &object.Metadata{
+		ID:        object.NewID("http://www.example.com/video.mp4"),
+		Code:      http.StatusOK,
+		Size:      uint64(len(body)),
+		BlockSize: 4,
+		Headers:   http.Header{"Content-Type": {"application/pdf"}},
+		RespUnix:  time.Now().Unix(),
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}
+	for _, idx := range []uint32{0, 1} {
+		w, _, err := bucket.WriteChunkFile(ctx, md.ID, idx)
+		assert.NoError(t, err)
+		_, _ = w.Write(body[idx*4 : idx*4+4])
+		assert.NoError(t, w.Close())
+		md.Chunks.Set(idx)
+	}
+	assert.NoError(t, bucket.Store(ctx, md))
+
+	origin := &rangeOrigin{body: body}
+	rt := middlewaretest.NewRoundTripper(t, "multirange", origin, map[string]any{"listener": "http"})
+
+	// full hit, no HEAD nor sub-request upstream
+	resp, err := rt.RoundTrip(newRequest("bytes=0-1,3-6"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bytes 0-1/20 01", "bytes 3-6/20 3456"}, readParts(t, resp))
+	assert.Equal(t, "HIT from edge-1 warm (tavern/4.0)", resp.Header.Get(protocol.ProtocolCacheStatusKey))
+	assert.Empty(t, origin.ranges)
+
+	// only the missing part goes upstream
+	resp, err = rt.RoundTrip(newRequest("bytes=2-3,10-"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bytes 2-3/20 23", "bytes 10-19/20 abcdefghij"}, readParts(t, resp))
+	assert.True(t, strings.HasPrefix(resp.Header.Get(protocol.ProtocolCacheStatusKey), "PART_HIT"))
+	assert.Equal(t, []string{"bytes=10-19"}, origin.ranges)
+
+	// If-Range / no-cache requests and the listener without caching go through the chain
+	for _, tc := range []struct {
+		rt     http.RoundTripper
+		header string
+		value  string
+	}{
+		{rt: rt, header: "If-Range", value: `"etag"`},
+		{rt: rt, header: "Cache-Control", value: "no-cache"},
+		{rt: rt, header: "Pragma", value: "no-cache"},
+		{rt: middlewaretest.NewRoundTripper(t, "multirange", origin, map[string]any{"listener": "admin"})},
+	} {
+		origin.ranges = nil
+		req := newRequest("bytes=0-1,3-6")
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		resp, err = tc.rt.RoundTrip(req)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"bytes 0-1/20 01", "bytes 3-6/20 3456"}, readParts(t, resp))
+		assert.ElementsMatch(t, []string{"bytes=0-0", "bytes=0-1", "bytes=3-6"}, origin.ranges, tc.header)
+	}
+}
+
+func TestCollapsedParts(t *testing.T) {
+	origin := &rangeOrigin{body: []byte("0123456789abcdefghij")}
+	proxy.SetDefault(&middlewaretest.Proxy{RoundTripper: origin})
+	defer proxy.SetDefault(nil)
+
+	bucket := middlewaretest.NewMemoryBucket(t)
+	storage.SetDefault(&middlewaretest.BucketStorage{Bucket: bucket})
+	defer storage.SetDefault(nil)
+
+	// the parallel sub-requests of the uncached object go through the collapsed forwarding
+	cachingMw, _, err := caching.Middleware(&configv1.Middleware{Name: "caching", Options: map[string]any{
+		"hostname":                       "edge-1",
+		"collapsed_request":              true,
+		"collapsed_request_wait_timeout": "30ms",
+	}})
+	assert.NoError(t, err)
+	rt := middlewaretest.NewRoundTripper(t, "multirange", cachingMw(nil), map[string]any{"concurrency": 3})
+
+	// the parts sharing one flight block each other, guarded by the timeout
+	parts := make(chan []string, 1)
+	go func() {
+		resp, err := rt.RoundTrip(newRequest("bytes=0-1,4-5,8-9"))
+		assert.NoError(t, err)
+		parts <- readParts(t, resp)
+	}()
+
+	select {
+	case got := <-parts:
+		assert.Equal(t, []string{
+			"bytes 0-1/20 01",
+			"bytes 4-5/20 45",
+			"bytes 8-9/20 89",
+		}, got)
+	case <-time.After(5 * time.Second):
+		t.Fatal("multirange parts blocked by the collapsed forwarding")
+	}
+}
+
+func TestPartMismatchedRange(t *testing.T) {
+	origin := &rangeOrigin{body: []byte("0123456789")}
+	rt := middlewaretest.NewRoundTripper(t, "multirange", middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		// the 206 of another range
+		if req.Header.Get("Range") == "bytes=4-5" {
+			req.Header.Set("Range", "bytes=0-1")
+		}
+		return origin.RoundTrip(req)
+	}), nil)
+
+	resp, err := rt.RoundTrip(newRequest("bytes=0-1,4-5"))
+	assert.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	assert.Error(t, err)
+}
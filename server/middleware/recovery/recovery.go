@@ -1,19 +1,28 @@
 package recovery
 
 import (
+	"fmt"
 	"net/http"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	configv1 "github.com/omalloc/tavern/api/defined/v1/middleware"
 	"github.com/omalloc/tavern/contrib/log"
+	"github.com/omalloc/tavern/pkg/health"
 	"github.com/omalloc/tavern/pkg/x/runtime"
 	"github.com/omalloc/tavern/server/middleware"
 )
 
+// healthComponent is the component name prefix in readiness probe, suffixed by the listener name.
+const healthComponent = "middleware.recovery"
+
+const defaultFailWindow = 60
+
 type middlewareOption struct {
-	FailCountThreshold int64 `json:"fail_count_threshold,omitempty" yaml:"fail_count_threshold,omitempty"`
-	FailWindow         int32 `json:"fail_window,omitempty" yaml:"fail_window,omitempty"`
+	FailCountThreshold int64  `json:"fail_count_threshold,omitempty" yaml:"fail_count_threshold,omitempty"`
+	FailWindow         int32  `json:"fail_window,omitempty" yaml:"fail_window,omitempty"`
+	Listener           string `json:"listener,omitempty" yaml:"-"` // 由 server 注入, 区分各 listener 的实例
 }
 
 func init() {
@@ -26,11 +35,23 @@ func Middleware(c *configv1.Middleware) (middleware.Middleware, func(), error) {
 		return nil, nil, err
 	}
 
+	if opts.FailWindow <= 0 {
+		opts.FailWindow = defaultFailWindow
+	}
+	window := time.Duration(opts.FailWindow) * time.Second
+
+	// 每个 listener 一个实例, 互不覆盖对方的 readiness 状态
+	component := healthComponent
+	if opts.Listener != "" {
+		component += "." + opts.Listener
+	}
+
 	var failCount atomic.Int32
 
 	stopCh := make(chan struct{}, 1)
 	tick := func() {
-		windowTicker := time.NewTicker(time.Duration(opts.FailWindow) * time.Second)
+		windowTicker := time.NewTicker(window)
+		defer windowTicker.Stop()
 
 		for {
 			select {
@@ -44,6 +65,14 @@ func Middleware(c *configv1.Middleware) (middleware.Middleware, func(), error) {
 
 	go tick()
 
+	var once sync.Once
+	cleanup := func() {
+		once.Do(func() {
+			close(stopCh)
+			health.MarkHealthy(component)
+		})
+	}
+
 	return func(origin http.RoundTripper) http.RoundTripper {
 		return middleware.RoundTripperFunc(func(req *http.Request) (resp *http.Response, err error) {
 			defer func() {
@@ -52,10 +81,11 @@ func Middleware(c *configv1.Middleware) (middleware.Middleware, func(), error) {
 					log.Context(req.Context()).Errorf("middleware recovery: %s \n%s", r, runtime.PrintStackTrace(4))
 
 					panicTotal.Inc()
-					failCount.Add(1)
-					if failCount.Load() >= int32(opts.FailCountThreshold) {
+					n := failCount.Add(1)
+					if opts.FailCountThreshold > 0 && int64(n) >= opts.FailCountThreshold {
 						log.Context(req.Context()).Errorf("middleware recovery: reached fail count threshold (%d), healthy now fail.", opts.FailCountThreshold)
-						// TODO: trigger healthy fail
+						// readiness-probe 失败直到 fail_window 内不再 panic, 由 LB 摘除流量
+						health.MarkUnhealthy(component, fmt.Sprintf("%d panics within %s", n, window), window)
 					}
 				}
 			}()
@@ -64,5 +94,5 @@ func Middleware(c *configv1.Middleware) (middleware.Middleware, func(), error) {
 
 			return
 		})
-	}, middleware.EmptyCleanup, nil
+	}, cleanup, nil
 }
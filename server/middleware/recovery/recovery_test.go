@@ -0,0 +1,72 @@
+package recovery_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	configv1 "github.com/omalloc/tavern/api/defined/v1/middleware"
+	"github.com/omalloc/tavern/pkg/health"
+	"github.com/omalloc/tavern/server/middleware"
+	"github.com/omalloc/tavern/server/middleware/recovery"
+)
+
+func TestPanicThresholdFailsReadiness(t *testing.T) {
+	mw, cleanup, err := recovery.Middleware(&configv1.Middleware{
+		Name: "recovery",
+		Options: map[string]any{
+			"fail_count_threshold": 2,
+			"fail_window":          60,
+		},
+	})
+	assert.NoError(t, err)
+
+	rt := mw(middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		panic("boom")
+	}))
+
+	req, _ := http.NewRequest(http.MethodGet, "http://www.example.com/", nil)
+
+	_, _ = rt.RoundTrip(req)
+	ready, _ := health.Ready()
+	assert.True(t, ready)
+
+	_, _ = rt.RoundTrip(req)
+	ready, unhealthy := health.Ready()
+	assert.False(t, ready)
+	assert.Len(t, unhealthy, 1)
+	assert.Equal(t, "middleware.recovery", unhealthy[0].Component)
+
+	// cleanup on shutdown / reload resets the state
+	cleanup()
+	ready, _ = health.Ready()
+	assert.True(t, ready)
+}
+
+func TestPerListenerHealthComponent(t *testing.T) {
+	newRecovery := func(listener string) (http.RoundTripper, func()) {
+		mw, cleanup, err := recovery.Middleware(&configv1.Middleware{
+			Name:    "recovery",
+			Options: map[string]any{"fail_count_threshold": 1, "listener": listener},
+		})
+		assert.NoError(t, err)
+		return mw(middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			panic("boom")
+		})), cleanup
+	}
+
+	public, cleanupPublic := newRecovery("public")
+	_, cleanupGateway := newRecovery("gateway")
+	defer cleanupPublic()
+
+	req, _ := http.NewRequest(http.MethodGet, "http://www.example.com/", nil)
+	_, _ = public.RoundTrip(req)
+
+	// the other listener's cleanup keeps the degraded state
+	cleanupGateway()
+	ready, unhealthy := health.Ready()
+	assert.False(t, ready)
+	assert.Len(t, unhealthy, 1)
+	assert.Equal(t, "middleware.recovery.public", unhealthy[0].Component)
+}
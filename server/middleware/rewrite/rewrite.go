@@ -8,14 +8,22 @@ import (
 )
 
 type HeadersPolicy struct {
-	Set    map[string]string `yaml:"set,omitempty"`
-	Add    map[string]string `yaml:"add,omitempty"`
-	Remove []string          `yaml:"remove,omitempty"`
+	Set    map[string]string `json:"set,omitempty" yaml:"set,omitempty"`
+	Add    map[string]string `json:"add,omitempty" yaml:"add,omitempty"`
+	Remove []string          `json:"remove,omitempty" yaml:"remove,omitempty"`
 }
 
 type middlewareOption struct {
-	RequestHeadersRewrite  *HeadersPolicy `yaml:"request_headers_rewrite,omitempty"`
-	ResponseHeadersRewrite *HeadersPolicy `yaml:"response_headers_rewrite,omitempty"`
+	RequestHeadersRewrite  *HeadersPolicy `json:"request_headers_rewrite,omitempty" yaml:"request_headers_rewrite,omitempty"`
+	ResponseHeadersRewrite *HeadersPolicy `json:"response_headers_rewrite,omitempty" yaml:"response_headers_rewrite,omitempty"`
+	// conditional rules evaluated in order after the global headers rewrite.
+	Rules []Rule `json:"rules,omitempty" yaml:"rules,omitempty"`
+}
+
+// matched is a rule matched in the request phase.
+type matched struct {
+	rule     *compiledRule
+	captures []string
 }
 
 func init() {
@@ -28,33 +36,58 @@ func Middleware(c *configv1.Middleware) (middleware.Middleware, func(), error) {
 		return nil, nil, err
 	}
 
+	// the variables are only supported in `rules`
+	requestHeaders := literalHeaders(opts.RequestHeadersRewrite)
+	responseHeaders := literalHeaders(opts.ResponseHeadersRewrite)
+	rules, err := compileRules(opts.Rules)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	return func(origin http.RoundTripper) http.RoundTripper {
 		return middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
 			// Rewrite request headers
-			if opts.RequestHeadersRewrite != nil {
-				for k, v := range opts.RequestHeadersRewrite.Set {
-					req.Header.Set(k, v)
+			requestHeaders.apply(req.Header, &varContext{req: req})
+
+			var (
+				hits []matched
+				resp *http.Response
+				err  error
+			)
+			for _, rule := range rules {
+				captures, ok := rule.matchRequest(req)
+				if !ok {
+					continue
 				}
-				for k, v := range opts.RequestHeadersRewrite.Add {
-					req.Header.Add(k, v)
+				hits = append(hits, matched{rule: rule, captures: captures})
+
+				vc := &varContext{req: req, captures: captures}
+				req = rule.rewriteURL(req, vc)
+				vc.req = req
+				rule.requestHeaders.apply(req.Header, vc)
+
+				// synthetic redirect / deny, skip upstream
+				if rule.Return != nil {
+					resp = rule.respond(req, vc)
+					break
 				}
-				for _, k := range opts.RequestHeadersRewrite.Remove {
-					req.Header.Del(k)
+				if rule.Last {
+					break
 				}
 			}
 
-			resp, err := origin.RoundTrip(req)
+			if resp == nil {
+				resp, err = origin.RoundTrip(req)
+			}
 
 			// Rewrite response headers
-			if err == nil && opts.ResponseHeadersRewrite != nil {
-				for k, v := range opts.ResponseHeadersRewrite.Set {
-					resp.Header.Set(k, v)
-				}
-				for k, v := range opts.ResponseHeadersRewrite.Add {
-					resp.Header.Add(k, v)
-				}
-				for _, k := range opts.ResponseHeadersRewrite.Remove {
-					resp.Header.Del(k)
+			if err == nil && resp != nil {
+				responseHeaders.apply(resp.Header, &varContext{req: req, resp: resp})
+
+				for _, hit := range hits {
+					if hit.rule.matchStatus(resp.StatusCode) {
+						hit.rule.responseHeaders.apply(resp.Header, &varContext{req: req, resp: resp, captures: hit.captures})
+					}
 				}
 			}
 
@@ -0,0 +1,152 @@
+package rewrite_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	configv1 "github.com/omalloc/tavern/api/defined/v1/middleware"
+	"github.com/omalloc/tavern/pkg/traces"
+	"github.com/omalloc/tavern/server/middleware/internal/middlewaretest"
+	"github.com/omalloc/tavern/server/middleware/rewrite"
+)
+
+// echoOrigin records the upstream request and responds with status.
+type echoOrigin struct {
+	status int
+	req    *http.Request
+}
+
+func (o *echoOrigin) RoundTrip(req *http.Request) (*http.Response, error) {
+	o.req = req
+	traces.FromContext(req.Context()).CacheStatus = "HIT"
+	return &http.Response{
+		StatusCode: o.status,
+		Header:     http.Header{"Server": {"origin"}},
+		Body:       http.NoBody,
+	}, nil
+}
+
+func newRequest(method, rawURL string) *http.Request {
+	req := httptest.NewRequest(method, rawURL, nil)
+	req, tr := traces.WithTrace(req)
+	tr.RequestID = "req-1"
+	return req
+}
+
+func TestHeadersRewrite(t *testing.T) {
+	origin := &echoOrigin{status: http.StatusOK}
+	rt := middlewaretest.NewRoundTripper(t, "rewrite", origin, map[string]any{
+		// the global values are literal
+		"request_headers_rewrite": map[string]any{
+			"set": map[string]any{"X-Price": "$1", "X-Unknown": "$unknown"},
+		},
+		"response_headers_rewrite": map[string]any{
+			"add":    map[string]any{"X-Literal": "$host"},
+			"remove": []any{"Server"},
+		},
+		"rules": []any{
+			map[string]any{
+				"request_headers": map[string]any{
+					"set": map[string]any{"X-Request-Id": "$request_id"},
+				},
+				"response_headers": map[string]any{
+					"set": map[string]any{"X-Cache-Status": "$cache_status from $host", "X-Dollar": "$$1"},
+				},
+			},
+		},
+	})
+
+	resp, err := rt.RoundTrip(newRequest(http.MethodGet, "http://www.example.com:8080/a.js"))
+	assert.NoError(t, err)
+	assert.Equal(t, "$1", origin.req.Header.Get("X-Price"))
+	assert.Equal(t, "$unknown", origin.req.Header.Get("X-Unknown"))
+	assert.Equal(t, "$host", resp.Header.Get("X-Literal"))
+	assert.Equal(t, "req-1", origin.req.Header.Get("X-Request-Id"))
+	assert.Equal(t, "HIT from www.example.com", resp.Header.Get("X-Cache-Status"))
+	assert.Equal(t, "$1", resp.Header.Get("X-Dollar"))
+	assert.Empty(t, resp.Header.Get("Server"))
+}
+
+func TestRules(t *testing.T) {
+	origin := &echoOrigin{status: http.StatusOK}
+	rt := middlewaretest.NewRoundTripper(t, "rewrite", origin, map[string]any{
+		"rules": []any{
+			// deny
+			map[string]any{
+				"match":  map[string]any{"hosts": []any{"*.example.com"}, "methods": []any{"POST"}},
+				"return": map[string]any{"code": 403},
+			},
+			// redirect
+			map[string]any{
+				"match":  map[string]any{"path_regex": "^/old/(.*)$", "headers": map[string]any{"X-Forwarded-Proto": "^http$"}},
+				"return": map[string]any{"code": 301, "location": "https://$host/new/$1?$args"},
+			},
+			// URL rewrite with captures
+			map[string]any{
+				"match":           map[string]any{"path_regex": `^/img/(\w+)/(\d+)\.jpg$`},
+				"rewrite":         map[string]any{"path": "/images/$1.jpg", "query": "w=$2"},
+				"request_headers": map[string]any{"set": map[string]any{"X-Image": "$1"}},
+				"last":            true,
+			},
+			// response headers by status
+			map[string]any{
+				"match":            map[string]any{"status": []any{"2xx"}},
+				"response_headers": map[string]any{"set": map[string]any{"Cache-Control": "max-age=60"}},
+			},
+		},
+	})
+
+	resp, err := rt.RoundTrip(newRequest(http.MethodPost, "http://api.example.com/a"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	req := newRequest(http.MethodGet, "http://www.example.com/old/a.html?v=1")
+	req.Header.Set("X-Forwarded-Proto", "http")
+	resp, err = rt.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+	assert.Equal(t, "https://www.example.com/new/a.html?v=1", resp.Header.Get("Location"))
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "Moved Permanently", string(body))
+
+	// the header condition does not match
+	origin.req = nil
+	resp, err = rt.RoundTrip(newRequest(http.MethodGet, "http://www.example.com/old/a.html"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "/old/a.html", origin.req.URL.Path)
+	assert.Equal(t, "max-age=60", resp.Header.Get("Cache-Control"))
+
+	// rewritten before caching, the client request is kept, `last` skips the following rules
+	req = newRequest(http.MethodGet, "http://www.example.com/img/cat/200.jpg?x=1")
+	resp, err = rt.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "/images/cat.jpg", origin.req.URL.Path)
+	assert.Equal(t, "w=200", origin.req.URL.RawQuery)
+	assert.Equal(t, "cat", origin.req.Header.Get("X-Image"))
+	assert.Equal(t, "/img/cat/200.jpg", req.URL.Path)
+	assert.Empty(t, resp.Header.Get("Cache-Control"))
+
+	origin.status = http.StatusNotFound
+	resp, err = rt.RoundTrip(newRequest(http.MethodGet, "http://www.example.com/a.html"))
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Header.Get("Cache-Control"))
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, options := range []map[string]any{
+		{"rules": []any{map[string]any{"response_headers": map[string]any{"set": map[string]any{"X-A": "$unknown"}}}}},
+		{"rules": []any{map[string]any{"response_headers": map[string]any{"set": map[string]any{"X-A": "${host"}}}}},
+		{"rules": []any{map[string]any{"match": map[string]any{"path_regex": "("}}}},
+		{"rules": []any{map[string]any{"match": map[string]any{"status": []any{"2x"}}}}},
+		{"rules": []any{map[string]any{"return": map[string]any{"code": 302}}}},
+		{"rules": []any{map[string]any{"return": map[string]any{"code": 200}}}},
+	} {
+		_, _, err := rewrite.Middleware(&configv1.Middleware{Name: "rewrite", Options: options})
+		assert.Error(t, err, "%v", options)
+	}
+}
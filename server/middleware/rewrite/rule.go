@@ -0,0 +1,308 @@
+package rewrite
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/omalloc/tavern/pkg/cachekey"
+	xhttp "github.com/omalloc/tavern/pkg/x/http"
+)
+
+// Match is the conditions of a rule, all the conditions must match, empty matches all.
+type Match struct {
+	Hosts     []string          `json:"hosts" yaml:"hosts"`           // `www.example.com`, `*.example.com`
+	PathRegex string            `json:"path_regex" yaml:"path_regex"` // the captures are `$1`-`$9`
+	Methods   []string          `json:"methods" yaml:"methods"`
+	Headers   map[string]string `json:"headers" yaml:"headers"` // request header name -> value regex, `^$` matches the absent header
+	Status    []string          `json:"status" yaml:"status"`   // `200`, `5xx`; only applied to `response_headers`
+}
+
+// URLRewrite rewrites the request URL before the cache key is computed.
+type URLRewrite struct {
+	Path  string `json:"path" yaml:"path"`   // e.g. `/v2/$1`, empty keeps the path
+	Query string `json:"query" yaml:"query"` // e.g. `id=$2&$args`, empty keeps the query, `-` drops it
+}
+
+// Return responds the synthetic redirect or deny response without going upstream.
+type Return struct {
+	Code     int    `json:"code" yaml:"code"`         // 301, 302, 307, 308 redirect; 4xx deny
+	Location string `json:"location" yaml:"location"` // redirect target, e.g. `https://$host$request_uri`
+}
+
+// Rule is evaluated in order, the request headers / URL of the matched rules are rewritten in the request
+// phase, the response headers of the matched rules in the response phase.
+type Rule struct {
+	Match           Match          `json:"match" yaml:"match"`
+	Rewrite         *URLRewrite    `json:"rewrite,omitempty" yaml:"rewrite,omitempty"`
+	Return          *Return        `json:"return,omitempty" yaml:"return,omitempty"`
+	RequestHeaders  *HeadersPolicy `json:"request_headers,omitempty" yaml:"request_headers,omitempty"`
+	ResponseHeaders *HeadersPolicy `json:"response_headers,omitempty" yaml:"response_headers,omitempty"`
+	Last            bool           `json:"last" yaml:"last"` // stop evaluating the following rules
+}
+
+type compiledRule struct {
+	*Rule
+
+	pathRegex *regexp.Regexp
+	headers   map[string]*regexp.Regexp
+	path      template
+	query     template
+	location  template
+
+	requestHeaders  *headersTemplate
+	responseHeaders *headersTemplate
+}
+
+// headersTemplate is the HeadersPolicy with the parsed values.
+type headersTemplate struct {
+	set    map[string]template
+	add    map[string]template
+	remove []string
+}
+
+// literalHeaders keeps the values of the global `request_headers_rewrite` / `response_headers_rewrite`
+// literal as before the variables exist, a `$` of the existing configs is not substituted.
+func literalHeaders(p *HeadersPolicy) *headersTemplate {
+	if p == nil {
+		return nil
+	}
+
+	h := &headersTemplate{
+		set:    make(map[string]template, len(p.Set)),
+		add:    make(map[string]template, len(p.Add)),
+		remove: p.Remove,
+	}
+	for k, v := range p.Set {
+		h.set[k] = template{{literal: v}}
+	}
+	for k, v := range p.Add {
+		h.add[k] = template{{literal: v}}
+	}
+	return h
+}
+
+func compileHeaders(p *HeadersPolicy) (*headersTemplate, error) {
+	if p == nil {
+		return nil, nil
+	}
+
+	h := &headersTemplate{
+		set:    make(map[string]template, len(p.Set)),
+		add:    make(map[string]template, len(p.Add)),
+		remove: p.Remove,
+	}
+	for k, v := range p.Set {
+		t, err := parseTemplate(v)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", k, err)
+		}
+		h.set[k] = t
+	}
+	for k, v := range p.Add {
+		t, err := parseTemplate(v)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", k, err)
+		}
+		h.add[k] = t
+	}
+	return h, nil
+}
+
+// apply sets / adds / removes the headers of h.
+func (p *headersTemplate) apply(h http.Header, c *varContext) {
+	if p == nil {
+		return
+	}
+
+	for k, t := range p.set {
+		h.Set(k, t.render(c))
+	}
+	for k, t := range p.add {
+		h.Add(k, t.render(c))
+	}
+	for _, k := range p.remove {
+		h.Del(k)
+	}
+}
+
+func compileRules(rules []Rule) ([]*compiledRule, error) {
+	compiled := make([]*compiledRule, 0, len(rules))
+	for i := range rules {
+		cr, err := compileRule(&rules[i])
+		if err != nil {
+			return nil, fmt.Errorf("rewrite rules[%d]: %w", i, err)
+		}
+		compiled = append(compiled, cr)
+	}
+	return compiled, nil
+}
+
+func compileRule(r *Rule) (*compiledRule, error) {
+	cr := &compiledRule{Rule: r}
+
+	var err error
+	if r.Match.PathRegex != "" {
+		if cr.pathRegex, err = regexp.Compile(r.Match.PathRegex); err != nil {
+			return nil, fmt.Errorf("invalid path_regex %q: %w", r.Match.PathRegex, err)
+		}
+	}
+
+	if len(r.Match.Headers) > 0 {
+		cr.headers = make(map[string]*regexp.Regexp, len(r.Match.Headers))
+		for k, v := range r.Match.Headers {
+			re, err := regexp.Compile(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid header %s regex %q: %w", k, v, err)
+			}
+			cr.headers[k] = re
+		}
+	}
+
+	for _, status := range r.Match.Status {
+		if !xhttp.ValidStatusPattern(status) {
+			return nil, fmt.Errorf("invalid status %q", status)
+		}
+	}
+
+	if r.Rewrite != nil {
+		if cr.path, err = parseTemplate(r.Rewrite.Path); err != nil {
+			return nil, fmt.Errorf("rewrite path: %w", err)
+		}
+		if r.Rewrite.Query != "-" {
+			if cr.query, err = parseTemplate(r.Rewrite.Query); err != nil {
+				return nil, fmt.Errorf("rewrite query: %w", err)
+			}
+		}
+	}
+
+	if r.Return != nil {
+		switch {
+		case isRedirect(r.Return.Code):
+			if r.Return.Location == "" {
+				return nil, fmt.Errorf("return %d without location", r.Return.Code)
+			}
+			if cr.location, err = parseTemplate(r.Return.Location); err != nil {
+				return nil, fmt.Errorf("return location: %w", err)
+			}
+		case r.Return.Code >= http.StatusBadRequest && r.Return.Code < 600:
+		default:
+			return nil, fmt.Errorf("invalid return code %d", r.Return.Code)
+		}
+	}
+
+	if cr.requestHeaders, err = compileHeaders(r.RequestHeaders); err != nil {
+		return nil, fmt.Errorf("request_headers: %w", err)
+	}
+	if cr.responseHeaders, err = compileHeaders(r.ResponseHeaders); err != nil {
+		return nil, fmt.Errorf("response_headers: %w", err)
+	}
+	return cr, nil
+}
+
+// matchRequest reports whether req matches the request conditions,
+// the captures of `path_regex` are returned.
+func (r *compiledRule) matchRequest(req *http.Request) ([]string, bool) {
+	if !cachekey.MatchHost(r.Match.Hosts, hostname(req)) {
+		return nil, false
+	}
+
+	if len(r.Match.Methods) > 0 && !slices.ContainsFunc(r.Match.Methods, func(m string) bool {
+		return strings.EqualFold(m, req.Method)
+	}) {
+		return nil, false
+	}
+
+	for k, re := range r.headers {
+		if !re.MatchString(req.Header.Get(k)) {
+			return nil, false
+		}
+	}
+
+	if r.pathRegex == nil {
+		return nil, true
+	}
+	captures := r.pathRegex.FindStringSubmatch(req.URL.Path)
+	return captures, captures != nil
+}
+
+// matchStatus reports whether the response status matches `status`.
+func (r *compiledRule) matchStatus(statusCode int) bool {
+	if len(r.Match.Status) == 0 {
+		return true
+	}
+
+	return xhttp.MatchStatus(r.Match.Status, statusCode)
+}
+
+// rewriteURL returns the shallow copy of req with the rewritten path and query,
+// the URL of the client request is kept for the access log.
+func (r *compiledRule) rewriteURL(req *http.Request, c *varContext) *http.Request {
+	if r.Rewrite == nil {
+		return req
+	}
+
+	path, query := req.URL.Path, req.URL.RawQuery
+	if r.Rewrite.Path != "" {
+		path = r.path.render(c)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+	}
+	switch r.Rewrite.Query {
+	case "":
+	case "-":
+		query = ""
+	default:
+		query = r.query.render(c)
+	}
+
+	u := *req.URL
+	u.Path = path
+	u.RawPath = ""
+	u.RawQuery = query
+
+	req = req.WithContext(req.Context())
+	req.URL = &u
+	req.RequestURI = u.RequestURI()
+	return req
+}
+
+// respond builds the synthetic response of `return`.
+func (r *compiledRule) respond(req *http.Request, c *varContext) *http.Response {
+	code := r.Return.Code
+	body := http.StatusText(code)
+
+	header := make(http.Header)
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	header.Set("X-Content-Type-Options", "nosniff")
+	if isRedirect(code) {
+		header.Set("Location", r.location.render(c))
+	}
+
+	return &http.Response{
+		StatusCode:    code,
+		Status:        fmt.Sprintf("%d %s", code, body),
+		Proto:         req.Proto,
+		ProtoMajor:    req.ProtoMajor,
+		ProtoMinor:    req.ProtoMinor,
+		Header:        header,
+		ContentLength: int64(len(body)),
+		Body:          io.NopCloser(strings.NewReader(body)),
+		Request:       req,
+	}
+}
+
+func isRedirect(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
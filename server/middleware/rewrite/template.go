@@ -0,0 +1,164 @@
+package rewrite
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/omalloc/tavern/pkg/traces"
+	xhttp "github.com/omalloc/tavern/pkg/x/http"
+)
+
+// varContext is the request / response state the variables are resolved from.
+type varContext struct {
+	req      *http.Request
+	resp     *http.Response // nil in the request phase
+	captures []string       // submatches of `path_regex`, captures[0] is the whole match
+}
+
+type variable func(c *varContext) string
+
+var variables = map[string]variable{
+	"host":           func(c *varContext) string { return hostname(c.req) },
+	"scheme":         func(c *varContext) string { return c.req.URL.Scheme },
+	"uri":            func(c *varContext) string { return c.req.URL.Path },
+	"args":           func(c *varContext) string { return c.req.URL.RawQuery },
+	"request_uri":    func(c *varContext) string { return c.req.URL.RequestURI() },
+	"request_method": func(c *varContext) string { return c.req.Method },
+	"remote_addr":    func(c *varContext) string { return xhttp.ClientIP(c.req.RemoteAddr, c.req.Header) },
+	"request_id":     func(c *varContext) string { return traces.FromContext(c.req.Context()).RequestID },
+	// the response variables are empty in the request phase.
+	"status": func(c *varContext) string {
+		if c.resp == nil {
+			return ""
+		}
+		return strconv.Itoa(c.resp.StatusCode)
+	},
+	"cache_status":  func(c *varContext) string { return traces.FromContext(c.req.Context()).CacheStatus },
+	"upstream_addr": func(c *varContext) string { return traces.FromContext(c.req.Context()).UpstreamAddr },
+}
+
+// lookupVariable resolves a variable name, `$1`-`$9` are the captures of `path_regex`,
+// `$http_<name>` and `$sent_http_<name>` are the request / response headers like nginx.
+func lookupVariable(name string) (variable, bool) {
+	if v, ok := variables[name]; ok {
+		return v, true
+	}
+	if len(name) == 1 && '0' <= name[0] && name[0] <= '9' {
+		n := int(name[0] - '0')
+		return func(c *varContext) string {
+			if n < len(c.captures) {
+				return c.captures[n]
+			}
+			return ""
+		}, true
+	}
+	if h, ok := strings.CutPrefix(name, "sent_http_"); ok && h != "" {
+		key := http.CanonicalHeaderKey(strings.ReplaceAll(h, "_", "-"))
+		return func(c *varContext) string {
+			if c.resp == nil {
+				return ""
+			}
+			return c.resp.Header.Get(key)
+		}, true
+	}
+	if h, ok := strings.CutPrefix(name, "http_"); ok && h != "" {
+		key := http.CanonicalHeaderKey(strings.ReplaceAll(h, "_", "-"))
+		return func(c *varContext) string { return c.req.Header.Get(key) }, true
+	}
+	return nil, false
+}
+
+type segment struct {
+	literal string
+	v       variable
+}
+
+// template is a string with the nginx-style variables, e.g. `https://$host/v2/$1?$args`.
+type template []segment
+
+// parseTemplate parses s, `$$` is the literal `$`.
+func parseTemplate(s string) (template, error) {
+	var t template
+	for i := 0; i < len(s); {
+		if s[i] != '$' {
+			j := strings.IndexByte(s[i:], '$')
+			if j < 0 {
+				j = len(s) - i
+			}
+			t = append(t, segment{literal: s[i : i+j]})
+			i += j
+			continue
+		}
+
+		if i+1 < len(s) && s[i+1] == '$' {
+			t = append(t, segment{literal: "$"})
+			i += 2
+			continue
+		}
+
+		// ${name} or $name, $1 is a single digit capture
+		i++
+		braced := i < len(s) && s[i] == '{'
+		if braced {
+			i++
+		}
+		j := i
+		if j < len(s) && '0' <= s[j] && s[j] <= '9' && !braced {
+			j++
+		} else {
+			for j < len(s) && isVariableChar(s[j]) {
+				j++
+			}
+		}
+		name := s[i:j]
+		if braced {
+			if j >= len(s) || s[j] != '}' {
+				return nil, fmt.Errorf("unclosed variable ${%s in %q", name, s)
+			}
+			j++
+		}
+		if name == "" {
+			return nil, fmt.Errorf("empty variable name at %d in %q", i, s)
+		}
+
+		v, ok := lookupVariable(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown variable $%s in %q", name, s)
+		}
+		t = append(t, segment{v: v})
+		i = j
+	}
+	return t, nil
+}
+
+// render resolves the variables of t.
+func (t template) render(c *varContext) string {
+	if len(t) == 1 && t[0].v == nil {
+		return t[0].literal
+	}
+
+	var sb strings.Builder
+	for _, s := range t {
+		if s.v == nil {
+			sb.WriteString(s.literal)
+			continue
+		}
+		sb.WriteString(s.v(c))
+	}
+	return sb.String()
+}
+
+// hostname returns the request host without port.
+func hostname(req *http.Request) string {
+	if h, _, err := net.SplitHostPort(req.Host); err == nil {
+		return h
+	}
+	return req.Host
+}
+
+func isVariableChar(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
@@ -1,6 +1,7 @@
 package mod
 
 import (
+	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -12,19 +13,35 @@ import (
 
 	"github.com/omalloc/tavern/conf"
 	"github.com/omalloc/tavern/contrib/log"
+	"github.com/omalloc/tavern/pkg/logcrypto"
 	"github.com/omalloc/tavern/pkg/traces"
 	xhttp "github.com/omalloc/tavern/pkg/x/http"
 )
 
-func HandleAccessLog(opt *conf.ServerAccessLog, next http.HandlerFunc) http.HandlerFunc {
+func HandleAccessLog(opt *conf.ServerAccessLog, next http.HandlerFunc) (http.HandlerFunc, error) {
+	handle, err := AccessLog(opt)
+	if err != nil {
+		return nil, err
+	}
+	return handle(next), nil
+}
+
+// AccessLog returns the access-log wrapper, the log file is shared by
+// every wrapped handler, e.g. the handler of each listener.
+func AccessLog(opt *conf.ServerAccessLog) (func(next http.HandlerFunc) http.HandlerFunc, error) {
 	if !opt.Enabled {
 		log.Infof("access-log is turned off")
-		return wrap(next)
+		return wrap, nil
 	}
 
 	if opt.Path == "" {
 		log.Warnf("access-log `path` is empty, will be written to stdout")
-		return wrap(next)
+		return wrap, nil
+	}
+
+	formatter, err := NewFormatter(opt.Format, opt.Encoder)
+	if err != nil {
+		return nil, err
 	}
 
 	logWriter := newAccessLog(opt.Path)
@@ -35,26 +52,38 @@ func HandleAccessLog(opt *conf.ServerAccessLog, next http.HandlerFunc) http.Hand
 		logWriter.Info(string(buf))
 	}
 	if opt.Encrypt.Enabled {
+		// 加密配置错误时拒绝启动, 避免明文落盘或丢失日志
+		c, err := logcrypto.New(opt.Encrypt.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("access-log encrypt: %w", err)
+		}
+
 		defeaterWriter = func(buf []byte) {
-			// TODO: 对日志进行加密处理
-			// logWriter.Info()
+			line, err := c.Encrypt(buf)
+			if err != nil {
+				log.Errorf("access-log encrypt failed: %v", err)
+				return
+			}
+			logWriter.Info(string(line))
 		}
 	}
 
-	return func(w http.ResponseWriter, req *http.Request) {
-		// 补全 request 结构
-		fillRequest(req)
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			// 补全 request 结构
+			fillRequest(req)
 
-		req, _ = traces.WithTrace(req)
-		recorder := xhttp.NewResponseRecorder(w)
+			req, _ = traces.WithTrace(req)
+			recorder := xhttp.NewResponseRecorder(w)
 
-		defer func() {
-			// write access log
-			defeaterWriter(WithNormalFields(req, recorder))
-		}()
+			defer func() {
+				// write access log
+				defeaterWriter(formatter.Format(req, recorder))
+			}()
 
-		next(recorder, req)
-	}
+			next(recorder, req)
+		}
+	}, nil
 }
 
 func tickRotate(f *lumberjack.Logger, stop <-chan struct{}) {
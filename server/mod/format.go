@@ -0,0 +1,226 @@
+package mod
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/omalloc/tavern/pkg/traces"
+	xhttp "github.com/omalloc/tavern/pkg/x/http"
+)
+
+const (
+	EncoderText = "text"
+	EncoderJSON = "json"
+)
+
+// DefaultFormat is the historical positional format, `cmd/tq` decodes it by index.
+// 新增字段请追加到末尾, 避免破坏已有的日志解析.
+const DefaultFormat = "$remote_addr $host $sent_http_content_type [$time_local] $request $status $bytes_sent " +
+	"$http_referer $http_user_agent $request_time $body_bytes_sent $http_content_length $http_range " +
+	"$http_x_forwarded_for $cache_status $request_id"
+
+type fieldContext struct {
+	req  *http.Request
+	resp *xhttp.ResponseRecorder
+	tr   *traces.Trace
+	now  time.Time
+}
+
+type variable struct {
+	value func(c *fieldContext) string
+	// raw keeps spaces in text encoder, otherwise spaces are replaced with `+`.
+	raw bool
+	// numeric is written as JSON number.
+	numeric bool
+}
+
+var variables = map[string]variable{
+	"remote_addr": {value: func(c *fieldContext) string { return xhttp.ClientIP(c.req.RemoteAddr, c.req.Header) }},
+	"host":        {value: func(c *fieldContext) string { return c.req.URL.Hostname() }},
+	"scheme":      {value: func(c *fieldContext) string { return c.req.URL.Scheme }},
+	"request": {value: func(c *fieldContext) string {
+		return fmt.Sprintf("%s %s %s", c.req.Method, c.req.URL, c.req.Proto)
+	}},
+	"request_method":  {value: func(c *fieldContext) string { return c.req.Method }},
+	"request_uri":     {value: func(c *fieldContext) string { return c.req.URL.RequestURI() }},
+	"server_protocol": {value: func(c *fieldContext) string { return c.req.Proto }},
+	"time_local":      {value: func(c *fieldContext) string { return c.now.Format("02/Jan/2006:15:04:05 -0700") }, raw: true},
+	"time_iso8601":    {value: func(c *fieldContext) string { return c.now.Format(time.RFC3339) }},
+	"msec": {value: func(c *fieldContext) string {
+		return strconv.FormatFloat(float64(c.now.UnixMilli())/1e3, 'f', 3, 64)
+	}, numeric: true},
+	"status":          {value: func(c *fieldContext) string { return strconv.Itoa(c.resp.Status()) }, numeric: true},
+	"bytes_sent":      {value: func(c *fieldContext) string { return strconv.FormatUint(bytesSent(c.resp), 10) }, numeric: true},
+	"body_bytes_sent": {value: func(c *fieldContext) string { return strconv.FormatUint(c.resp.Size(), 10) }, numeric: true},
+	// request_time in milliseconds
+	"request_time": {value: func(c *fieldContext) string {
+		return strconv.FormatInt(c.now.Sub(c.tr.StartAt).Milliseconds(), 10)
+	}, numeric: true},
+	"cache_status":  {value: func(c *fieldContext) string { return c.tr.CacheStatus }},
+	"request_id":    {value: func(c *fieldContext) string { return c.tr.RequestID }},
+	"upstream_addr": {value: func(c *fieldContext) string { return c.tr.UpstreamAddr }},
+	"bucket":        {value: func(c *fieldContext) string { return c.tr.Bucket }},
+	"store_url":     {value: func(c *fieldContext) string { return c.tr.StoreUrl }},
+	"chunks_hit":    {value: func(c *fieldContext) string { return strconv.Itoa(c.tr.ChunksHit) }, numeric: true},
+	"chunks_total":  {value: func(c *fieldContext) string { return strconv.Itoa(c.tr.ChunksTotal) }, numeric: true},
+}
+
+// lookupVariable resolves a variable name, `$http_<name>` and `$sent_http_<name>`
+// are the request / response headers like nginx.
+func lookupVariable(name string) (variable, bool) {
+	if v, ok := variables[name]; ok {
+		return v, true
+	}
+	if h, ok := strings.CutPrefix(name, "sent_http_"); ok && h != "" {
+		key := http.CanonicalHeaderKey(strings.ReplaceAll(h, "_", "-"))
+		return variable{value: func(c *fieldContext) string { return c.resp.Header().Get(key) }}, true
+	}
+	if h, ok := strings.CutPrefix(name, "http_"); ok && h != "" {
+		key := http.CanonicalHeaderKey(strings.ReplaceAll(h, "_", "-"))
+		return variable{value: func(c *fieldContext) string { return c.req.Header.Get(key) }}, true
+	}
+	return variable{}, false
+}
+
+type segment struct {
+	literal string
+	name    string
+	v       variable
+}
+
+// Formatter renders access log line from a nginx-style format, e.g.
+//
+//	$remote_addr [$time_local] "$request" $status $cache_status
+type Formatter struct {
+	segments []segment
+	json     bool
+}
+
+// NewFormatter parses the format, empty format uses DefaultFormat.
+// The json encoder writes every variable as a key of JSON object, the literals are ignored.
+func NewFormatter(format string, encoder string) (*Formatter, error) {
+	if format == "" {
+		format = DefaultFormat
+	}
+
+	f := &Formatter{}
+	switch encoder {
+	case "", EncoderText:
+	case EncoderJSON:
+		f.json = true
+	default:
+		return nil, fmt.Errorf("unknown access-log encoder %q", encoder)
+	}
+
+	for i := 0; i < len(format); {
+		if format[i] != '$' {
+			j := strings.IndexByte(format[i:], '$')
+			if j < 0 {
+				j = len(format) - i
+			}
+			f.segments = append(f.segments, segment{literal: format[i : i+j]})
+			i += j
+			continue
+		}
+
+		// ${name} or $name
+		i++
+		braced := i < len(format) && format[i] == '{'
+		if braced {
+			i++
+		}
+		j := i
+		for j < len(format) && isVariableChar(format[j]) {
+			j++
+		}
+		name := format[i:j]
+		if braced {
+			if j >= len(format) || format[j] != '}' {
+				return nil, fmt.Errorf("access-log format: unclosed variable ${%s", name)
+			}
+			j++
+		}
+		if name == "" {
+			return nil, fmt.Errorf("access-log format: empty variable name at %d", i)
+		}
+
+		v, ok := lookupVariable(name)
+		if !ok {
+			return nil, fmt.Errorf("access-log format: unknown variable $%s", name)
+		}
+		f.segments = append(f.segments, segment{name: name, v: v})
+		i = j
+	}
+
+	return f, nil
+}
+
+// Format renders a single line without trailing newline.
+func (f *Formatter) Format(req *http.Request, resp *xhttp.ResponseRecorder) []byte {
+	c := &fieldContext{
+		req:  req,
+		resp: resp,
+		tr:   traces.FromContext(req.Context()),
+		now:  time.Now(),
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(defaultBufferSize)
+
+	if f.json {
+		f.formatJSON(&buf, c)
+		return buf.Bytes()
+	}
+
+	for _, s := range f.segments {
+		if s.name == "" {
+			buf.WriteString(s.literal)
+			continue
+		}
+		val := emptyWrap(s.v.value(c))
+		if !s.v.raw {
+			val = strings.ReplaceAll(val, " ", "+")
+		}
+		buf.WriteString(val)
+	}
+	return buf.Bytes()
+}
+
+func (f *Formatter) formatJSON(buf *bytes.Buffer, c *fieldContext) {
+	buf.WriteByte('{')
+	n := 0
+	for _, s := range f.segments {
+		if s.name == "" {
+			continue
+		}
+		if n > 0 {
+			buf.WriteByte(',')
+		}
+		n++
+
+		writeJSONString(buf, s.name)
+		buf.WriteByte(':')
+
+		val := s.v.value(c)
+		if s.v.numeric && val != "" {
+			buf.WriteString(val)
+			continue
+		}
+		writeJSONString(buf, val)
+	}
+	buf.WriteByte('}')
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	b, _ := json.Marshal(s)
+	buf.Write(b)
+}
+
+func isVariableChar(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
@@ -0,0 +1,85 @@
+package mod_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/tavern/pkg/traces"
+	xhttp "github.com/omalloc/tavern/pkg/x/http"
+	"github.com/omalloc/tavern/server/mod"
+)
+
+func newTestRequest() (*http.Request, *xhttp.ResponseRecorder) {
+	req := httptest.NewRequest(http.MethodGet, "http://www.example.com/path/to/1K.bin", nil)
+	req.RemoteAddr = "127.0.0.1:57416"
+	req.Header.Set("User-Agent", "curl/8.5.0")
+	req.Header.Set("Range", "bytes=0-1023")
+
+	req, tr := traces.WithTrace(req)
+	tr.CacheStatus = "HIT"
+	tr.UpstreamAddr = "10.0.0.1:80"
+	tr.ChunksHit = 1
+	tr.ChunksTotal = 2
+
+	rec := xhttp.NewResponseRecorder(httptest.NewRecorder())
+	rec.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rec.WriteHeader(http.StatusPartialContent)
+	_, _ = rec.Write([]byte("hello"))
+	return req, rec
+}
+
+func TestFormatterDefault(t *testing.T) {
+	req, rec := newTestRequest()
+
+	fields := strings.Split(string(mod.WithNormalFields(req, rec)), " ")
+	// positional layout consumed by cmd/tq
+	assert.Len(t, fields, 17)
+	assert.Equal(t, "127.0.0.1:57416", fields[0])
+	assert.Equal(t, "www.example.com", fields[1])
+	assert.Equal(t, "text/plain;+charset=utf-8", fields[2])
+	assert.True(t, strings.HasPrefix(fields[3], "["))
+	assert.True(t, strings.HasSuffix(fields[4], "]"))
+	assert.Equal(t, "GET+http://www.example.com/path/to/1K.bin+HTTP/1.1", fields[5])
+	assert.Equal(t, "206", fields[6])
+	assert.Equal(t, "-", fields[8])
+	assert.Equal(t, "curl/8.5.0", fields[9])
+	assert.Equal(t, "5", fields[11])
+	assert.Equal(t, "bytes=0-1023", fields[13])
+	assert.Equal(t, "HIT", fields[15])
+}
+
+func TestFormatterJSON(t *testing.T) {
+	req, rec := newTestRequest()
+
+	f, err := mod.NewFormatter(`$remote_addr "$request_uri" ${status} $upstream_addr $chunks_hit/$chunks_total $http_user_agent $sent_http_content_type $bucket`, mod.EncoderJSON)
+	assert.NoError(t, err)
+
+	var out map[string]any
+	assert.NoError(t, json.Unmarshal(f.Format(req, rec), &out))
+	assert.Equal(t, map[string]any{
+		"remote_addr":            "127.0.0.1:57416",
+		"request_uri":            "/path/to/1K.bin",
+		"status":                 float64(206),
+		"upstream_addr":          "10.0.0.1:80",
+		"chunks_hit":             float64(1),
+		"chunks_total":           float64(2),
+		"http_user_agent":        "curl/8.5.0",
+		"sent_http_content_type": "text/plain; charset=utf-8",
+		"bucket":                 "",
+	}, out)
+}
+
+func TestFormatterInvalid(t *testing.T) {
+	for _, format := range []string{"$unknown", "${status", "$ $status"} {
+		_, err := mod.NewFormatter(format, mod.EncoderText)
+		assert.Error(t, err, format)
+	}
+
+	_, err := mod.NewFormatter("", "xml")
+	assert.Error(t, err)
+}
@@ -1,10 +1,18 @@
 package server
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"net"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
+
+	"github.com/omalloc/tavern/conf"
+	"github.com/omalloc/tavern/contrib/log"
+	"github.com/omalloc/tavern/server/tlscert"
 )
 
 var (
@@ -13,34 +21,89 @@ var (
 )
 
 func (s *HTTPServer) listen() error {
-	if s.listener == nil {
-		var (
-			ln  net.Listener
-			err error
-		)
-
-		// normal listen
-		listen := net.Listen
-		if s.flip != nil {
-			// graceful listen
-			listen = s.flip.Listen
+	for _, l := range s.listeners {
+		if l.ln != nil {
+			continue
 		}
 
-		// normal network
-		network := "tcp"
-		if strings.HasSuffix(s.Server.Addr, ".sock") {
-			// unix socket
-			network = "unix"
+		if err := s.listenOne(l); err != nil {
+			return fmt.Errorf("listener %s: %w", l.conf.Name, err)
 		}
+	}
+	return nil
+}
+
+func (s *HTTPServer) listenOne(l *listener) error {
+	// normal listen
+	listen := net.Listen
+	if s.flip != nil {
+		// graceful listen
+		listen = s.flip.Listen
+	}
 
-		ln, err = listen(network, s.Server.Addr)
+	// normal network
+	network := "tcp"
+	if strings.HasSuffix(l.Addr, ".sock") {
+		// unix socket
+		network = "unix"
+	}
+
+	ln, err := listen(network, l.Addr)
+	if err != nil {
+		return err
+	}
+
+	if l.conf.TLS != nil && l.conf.TLS.Enabled {
+		certs, config, err := newTLSConfig(l.conf.TLS)
 		if err != nil {
+			_ = ln.Close()
 			return err
 		}
 
-		s.listener = ln
-		return nil
+		// the raw listener is inherited by tableflip,
+		// the certificates are loaded again in the new process.
+		l.certs = certs
+		ln = tls.NewListener(ln, config)
 	}
 
+	l.ln = ln
 	return nil
 }
+
+func newTLSConfig(c *conf.ServerTLS) (*tlscert.Manager, *tls.Config, error) {
+	minVersion, err := tlscert.ParseVersion(c.MinVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certs, err := tlscert.New(c.CertDir,
+		tlscert.WithDefault(c.DefaultCert),
+		tlscert.WithOCSPStapling(c.OCSPStapling),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = certs.Watch(); err != nil {
+		log.Warnf("failed to watch certificates %s, hot reload disabled: %v", c.CertDir, err)
+	}
+
+	config := certs.TLSConfig(minVersion)
+	if c.ClientCA != "" {
+		pem, err := os.ReadFile(c.ClientCA)
+		if err != nil {
+			_ = certs.Close()
+			return nil, nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			_ = certs.Close()
+			return nil, nil, fmt.Errorf("no certificate found in client_ca %s", c.ClientCA)
+		}
+
+		// mTLS is optional, the admin listener accepts the bearer token as well.
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return certs, config, nil
+}
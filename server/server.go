@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"net/http"
 	"slices"
@@ -19,18 +20,24 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	middlewarev1 "github.com/omalloc/tavern/api/defined/v1/middleware"
 	pluginv1 "github.com/omalloc/tavern/api/defined/v1/plugin"
 	"github.com/omalloc/tavern/conf"
 	"github.com/omalloc/tavern/contrib/log"
 	"github.com/omalloc/tavern/contrib/transport"
+	"github.com/omalloc/tavern/pkg/health"
 	xhttp "github.com/omalloc/tavern/pkg/x/http"
 	"github.com/omalloc/tavern/pkg/x/runtime"
+	"github.com/omalloc/tavern/proxy"
+	"github.com/omalloc/tavern/server/admin"
 	"github.com/omalloc/tavern/server/middleware"
 	_ "github.com/omalloc/tavern/server/middleware/caching"
+	_ "github.com/omalloc/tavern/server/middleware/compress"
 	_ "github.com/omalloc/tavern/server/middleware/multirange"
 	_ "github.com/omalloc/tavern/server/middleware/recovery"
 	_ "github.com/omalloc/tavern/server/middleware/rewrite"
 	"github.com/omalloc/tavern/server/mod"
+	"github.com/omalloc/tavern/server/tlscert"
 	"github.com/omalloc/tavern/storage"
 )
 
@@ -47,37 +54,28 @@ var bufPool = sync.Pool{
 }
 
 type HTTPServer struct {
-	*http.Server
-
 	plugins []pluginv1.Plugin
 
 	flip         *tableflip.Upgrader
 	config       *conf.Bootstrap
 	serverConfig *conf.Server
-	listener     net.Listener
+	listeners    []*listener
 	cleanups     []func()
 }
 
+// listener serves one of `server.listeners` with its own middleware chain.
+type listener struct {
+	*http.Server
+
+	conf  *conf.ServerListener
+	ln    net.Listener
+	certs *tlscert.Manager
+}
+
 func NewServer(flip *tableflip.Upgrader, config *conf.Bootstrap, plugins []pluginv1.Plugin) transport.Server {
 	servConfig := config.Server
 
 	s := &HTTPServer{
-		Server: &http.Server{
-			Addr:              servConfig.Addr,
-			ReadTimeout:       servConfig.ReadTimeout,
-			WriteTimeout:      servConfig.WriteTimeout,
-			IdleTimeout:       servConfig.IdleTimeout,
-			ReadHeaderTimeout: servConfig.ReadHeaderTimeout,
-			MaxHeaderBytes:    servConfig.MaxHeaderBytes,
-			ConnState: func(_ net.Conn, state http.ConnState) {
-				switch state {
-				case http.StateNew:
-					connectionsActive.Inc()
-				case http.StateClosed, http.StateHijacked:
-					connectionsActive.Dec()
-				}
-			},
-		},
 		plugins:      plugins,
 		flip:         flip,
 		config:       config,
@@ -98,8 +96,8 @@ func NewServer(flip *tableflip.Upgrader, config *conf.Bootstrap, plugins []plugi
 	// - 用于注册插件的路由
 	mux := s.newServeMux()
 
-	// 初始化业务服务的路由监听
-	next, err := s.buildEndpoint()
+	// access-log 由所有监听共用
+	accessLog, err := mod.AccessLog(s.serverConfig.AccessLog)
 	if err != nil {
 		panic(err)
 	}
@@ -111,37 +109,106 @@ func NewServer(flip *tableflip.Upgrader, config *conf.Bootstrap, plugins []plugi
 		return addr
 	}
 
-	s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		host := fmtAddr(r.Host)
-		if _, ok := localMatcher[host]; ok {
-			// 内部接口处理流程
-			w.Header().Set("X-Server", "local-plugin")
-			mux.ServeHTTP(w, r)
-			return
+	// 内部接口鉴权, 未配置 `server.admin` 时仅依赖 Host 匹配
+	var local http.Handler = mux
+	if servConfig.Admin != nil {
+		auth, err := admin.New(servConfig.Admin)
+		if err != nil {
+			panic(err)
 		}
+		local = auth.Wrap(mux)
+	}
+
+	// 配置了独立的管理端口后, 业务端口不再进入内部路由
+	adminConf := servConfig.GetAdminListener()
+
+	for _, lc := range servConfig.GetListeners() {
+		// 初始化业务服务的路由监听
+		next, err := s.buildEndpoint(lc.Name, lc.Middleware)
+		if err != nil {
+			panic(err)
+		}
+		next = accessLog(next)
+
+		localAPI := lc.LocalAPI && adminConf == nil
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if localAPI {
+				host := fmtAddr(r.Host)
+				if _, ok := localMatcher[host]; ok {
+					// 内部接口处理流程
+					w.Header().Set("X-Server", "local-plugin")
+					local.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			// 主业务流程
+			next(w, r)
+		})
+
+		s.listeners = append(s.listeners, newListener(lc, handler))
+	}
 
-		// 主业务流程
-		next(w, r)
-	})
+	if adminConf != nil {
+		s.listeners = append(s.listeners, newListener(adminConf, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Server", "local-plugin")
+			local.ServeHTTP(w, r)
+		})))
+	}
 
 	return s
 }
 
-func (s *HTTPServer) Start(ctx context.Context) error {
-
-	s.BaseContext = func(ln net.Listener) context.Context {
-		return ctx
+func newListener(lc *conf.ServerListener, handler http.Handler) *listener {
+	return &listener{
+		Server: &http.Server{
+			Addr:              lc.Addr,
+			Handler:           handler,
+			ReadTimeout:       lc.ReadTimeout,
+			WriteTimeout:      lc.WriteTimeout,
+			IdleTimeout:       lc.IdleTimeout,
+			ReadHeaderTimeout: lc.ReadHeaderTimeout,
+			MaxHeaderBytes:    lc.MaxHeaderBytes,
+			ConnState: func(_ net.Conn, state http.ConnState) {
+				switch state {
+				case http.StateNew:
+					connectionsActive.Inc()
+				case http.StateClosed, http.StateHijacked:
+					connectionsActive.Dec()
+				}
+			},
+		},
+		conf: lc,
 	}
+}
 
+func (s *HTTPServer) Start(ctx context.Context) error {
 	if err := s.listen(); err != nil {
 		return err
 	}
 
-	log.Infof("HTTP Cache server listening on %s", s.listener.Addr().String())
+	errc := make(chan error, len(s.listeners))
+	for _, l := range s.listeners {
+		l.BaseContext = func(ln net.Listener) context.Context {
+			return ctx
+		}
 
-	if err := s.Serve(s.listener); err != nil &&
-		!errors.Is(err, http.ErrServerClosed) {
-		return err
+		log.Infof("HTTP Cache server %s listening on %s", l.conf.Name, l.ln.Addr().String())
+
+		go func() {
+			if err := l.Serve(l.ln); err != nil &&
+				!errors.Is(err, http.ErrServerClosed) {
+				errc <- fmt.Errorf("listener %s: %w", l.conf.Name, err)
+				return
+			}
+			errc <- nil
+		}()
+	}
+
+	for range s.listeners {
+		if err := <-errc; err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -150,8 +217,14 @@ func (s *HTTPServer) Start(ctx context.Context) error {
 func (s *HTTPServer) Stop(ctx context.Context) error {
 	var errs []error
 
-	if err := s.Shutdown(ctx); err != nil {
-		errs = append(errs, err)
+	for _, l := range s.listeners {
+		if err := l.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+
+		if l.certs != nil {
+			_ = l.certs.Close()
+		}
 	}
 
 	// Call all middleware cleanup.
@@ -191,6 +264,18 @@ func (s *HTTPServer) newServeMux() *http.ServeMux {
 	mux.Handle("/metrics", promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
 		EnableOpenMetrics: true,
 	}))
+	// 源站健康状态
+	mux.Handle("/upstream/health", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nodes := make([]proxy.NodeStatus, 0)
+		if p := proxy.GetProxy(); p != nil {
+			nodes = p.Health()
+		}
+		payload, _ := json.Marshal(nodes)
+		w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(payload)
+	}))
 	// 启动探针
 	mux.Handle("/healthz/startup-probe", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		payload := []byte("ok")
@@ -203,9 +288,19 @@ func (s *HTTPServer) newServeMux() *http.ServeMux {
 	mux.Handle("/healthz/liveness-probe", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}))
-	// 就绪探针
+	// 就绪探针, 任一子系统不健康时返回 503 以便 LB 摘除流量
 	mux.Handle("/healthz/readiness-probe", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
+		ready, unhealthy := health.Ready()
+		if ready {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		payload, _ := json.Marshal(unhealthy)
+		w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write(payload)
 	}))
 
 	// 初始化插件的路由监听(如果插件需要)
@@ -326,8 +421,8 @@ func (s *HTTPServer) buildHandler(tripper http.RoundTripper) http.HandlerFunc {
 	}
 }
 
-func (s *HTTPServer) buildEndpoint() (http.HandlerFunc, error) {
-	tripper, err := s.buildMiddlewareChain(nil)
+func (s *HTTPServer) buildEndpoint(listener string, middlewares []*middlewarev1.Middleware) (http.HandlerFunc, error) {
+	tripper, err := s.buildMiddlewareChain(listener, middlewares, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -343,13 +438,12 @@ func (s *HTTPServer) buildEndpoint() (http.HandlerFunc, error) {
 		}
 	}
 
-	// add access-log handler
-	return mod.HandleAccessLog(s.serverConfig.AccessLog, next), nil
+	return next, nil
 }
 
-func (s *HTTPServer) buildMiddlewareChain(tripper http.RoundTripper) (http.RoundTripper, error) {
-	middlewares := s.config.Server.Middleware
-
+// buildMiddlewareChain builds the middleware chain of the listener, the middleware configs may be shared
+// by the listeners inheriting `server.middleware`, so the options are copied before merged.
+func (s *HTTPServer) buildMiddlewareChain(listener string, middlewares []*middlewarev1.Middleware, tripper http.RoundTripper) (http.RoundTripper, error) {
 	// merge global options to each middleware options
 	global := s.globalOptions(make(map[string]any))
 
@@ -358,12 +452,21 @@ func (s *HTTPServer) buildMiddlewareChain(tripper http.RoundTripper) (http.Round
 			panic("middlewares name is empty, config file array index " + strconv.Itoa(i))
 		}
 
-		conf := middlewares[i]
-		if conf != nil && len(conf.Options) > 0 {
+		conf := &middlewarev1.Middleware{
+			Name:     middlewares[i].Name,
+			Required: middlewares[i].Required,
+			Options:  maps.Clone(middlewares[i].Options),
+		}
+		if len(conf.Options) > 0 {
 			if err := mergo.Map(&conf.Options, global, mergo.WithOverride); err != nil {
 				log.Warnf("failed to merge global options to middleware %s: %v", conf.Name, err)
 			}
 		}
+		// 同一中间件在每个 listener 各有一个实例, e.g. recovery 的 readiness 组件名
+		if conf.Options == nil {
+			conf.Options = make(map[string]any, 1)
+		}
+		conf.Options["listener"] = listener
 		next, cleanup, err := middleware.Create(conf)
 		if err != nil {
 			log.Warnf("failed to create middleware %s: %v", conf.Name, err)
@@ -0,0 +1,285 @@
+package tlscert
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/omalloc/tavern/contrib/log"
+)
+
+// certificate file extensions, the private key is `<name>.key` in the same directory
+// and the optional OCSP response (DER) is `<name>.ocsp`.
+var certExts = []string{".crt", ".pem", ".cer"}
+
+const (
+	keyExt  = ".key"
+	ocspExt = ".ocsp"
+
+	// reloadDelay merges the burst of events, e.g. cert and key are replaced one by one.
+	reloadDelay = time.Second
+)
+
+var ErrNoCertificate = errors.New("tlscert: no certificate found")
+
+type Option func(m *Manager)
+
+// WithDefault sets the certificate used when SNI is missing or not matched,
+// name is the file name without extension. Defaults to the first one in name order.
+func WithDefault(name string) Option {
+	return func(m *Manager) {
+		m.defaultName = name
+	}
+}
+
+// WithOCSPStapling staples `<name>.ocsp` into the handshake when it exists.
+func WithOCSPStapling(enabled bool) Option {
+	return func(m *Manager) {
+		m.ocsp = enabled
+	}
+}
+
+type certStore struct {
+	// lower-case DNS name, wildcard is kept as `*.example.com`
+	byName   map[string]*tls.Certificate
+	fallback *tls.Certificate
+	files    []string
+}
+
+// Manager loads the certificates from a directory and selects one by SNI.
+type Manager struct {
+	dir         string
+	defaultName string
+	ocsp        bool
+
+	store atomic.Pointer[certStore]
+
+	watcher *fsnotify.Watcher
+	once    sync.Once
+	stop    chan struct{}
+}
+
+// New loads every certificate in dir, it fails when nothing is loaded.
+func New(dir string, opts ...Option) (*Manager, error) {
+	m := &Manager{
+		dir:  dir,
+		stop: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload re-reads the directory, the loaded certificates are kept on error.
+func (m *Manager) Reload() error {
+	store, err := m.load()
+	if err != nil {
+		return err
+	}
+	m.store.Store(store)
+	return nil
+}
+
+func (m *Manager) load() (*certStore, error) {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &certStore{byName: make(map[string]*tls.Certificate)}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || !slices.Contains(certExts, ext) {
+			continue
+		}
+
+		name := strings.TrimSuffix(entry.Name(), ext)
+		// `<name>.key.pem` is the private key
+		if strings.HasSuffix(name, keyExt) {
+			continue
+		}
+		cert, err := m.loadPair(name, ext)
+		if err != nil {
+			return nil, err
+		}
+		store.files = append(store.files, name)
+
+		for _, host := range hostnames(cert) {
+			// the first one in name order wins
+			if _, ok := store.byName[host]; !ok {
+				store.byName[host] = cert
+			}
+		}
+
+		if store.fallback == nil || name == m.defaultName {
+			store.fallback = cert
+		}
+	}
+
+	if len(store.files) == 0 {
+		return nil, fmt.Errorf("%w in %s", ErrNoCertificate, m.dir)
+	}
+	if m.defaultName != "" && !slices.Contains(store.files, m.defaultName) {
+		return nil, fmt.Errorf("tlscert: default certificate %q not found in %s", m.defaultName, m.dir)
+	}
+	return store, nil
+}
+
+func (m *Manager) loadPair(name, ext string) (*tls.Certificate, error) {
+	base := filepath.Join(m.dir, name)
+
+	cert, err := tls.LoadX509KeyPair(base+ext, base+keyExt)
+	if err != nil {
+		return nil, fmt.Errorf("tlscert: load %s: %w", name, err)
+	}
+
+	if m.ocsp {
+		staple, err := os.ReadFile(base + ocspExt)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("tlscert: load %s ocsp: %w", name, err)
+		}
+		cert.OCSPStaple = staple
+	}
+	return &cert, nil
+}
+
+func hostnames(cert *tls.Certificate) []string {
+	if cert.Leaf == nil {
+		return nil
+	}
+
+	names := cert.Leaf.DNSNames
+	if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+		names = []string{cert.Leaf.Subject.CommonName}
+	}
+
+	hosts := make([]string, 0, len(names))
+	for _, name := range names {
+		hosts = append(hosts, strings.ToLower(name))
+	}
+	return hosts
+}
+
+// GetCertificate implements tls.Config.GetCertificate,
+// exact name is preferred over wildcard, then the default certificate.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	store := m.store.Load()
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name != "" {
+		if cert, ok := store.byName[name]; ok {
+			return cert, nil
+		}
+		if i := strings.IndexByte(name, '.'); i > 0 {
+			if cert, ok := store.byName["*"+name[i:]]; ok {
+				return cert, nil
+			}
+		}
+	}
+
+	if store.fallback == nil {
+		return nil, ErrNoCertificate
+	}
+	return store.fallback, nil
+}
+
+// TLSConfig returns the server side tls.Config backed by the manager.
+func (m *Manager) TLSConfig(minVersion uint16) *tls.Config {
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12
+	}
+	return &tls.Config{
+		MinVersion:     minVersion,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: m.GetCertificate,
+	}
+}
+
+// Watch reloads the certificates when the files of the directory changed.
+func (m *Manager) Watch() error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err = w.Add(m.dir); err != nil {
+		_ = w.Close()
+		return err
+	}
+	m.watcher = w
+
+	go m.watch()
+	return nil
+}
+
+func (m *Manager) watch() {
+	timer := time.NewTimer(reloadDelay)
+	timer.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			timer.Stop()
+			return
+		case event, ok := <-m.watcher.Events:
+			if !ok {
+				return
+			}
+			log.Debugf("tlscert: %s %s", event.Op, event.Name)
+			timer.Reset(reloadDelay)
+		case err, ok := <-m.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warnf("tlscert: watch %s failed: %v", m.dir, err)
+		case <-timer.C:
+			if err := m.Reload(); err != nil {
+				log.Errorf("tlscert: reload %s failed, keep the loaded certificates: %v", m.dir, err)
+				continue
+			}
+			log.Infof("tlscert: reloaded %d certificates from %s", len(m.store.Load().files), m.dir)
+		}
+	}
+}
+
+// Close stops watching the directory.
+func (m *Manager) Close() error {
+	var err error
+	m.once.Do(func() {
+		close(m.stop)
+		if m.watcher != nil {
+			err = m.watcher.Close()
+		}
+	})
+	return err
+}
+
+// ParseVersion parses the TLS version, e.g. `1.2`, `1.3`. Empty is 0.
+func ParseVersion(v string) (uint16, error) {
+	switch v {
+	case "":
+		return 0, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("tlscert: unknown tls version %q", v)
+}
@@ -0,0 +1,111 @@
+package tlscert_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/tavern/server/tlscert"
+)
+
+func writeCert(t *testing.T, dir, name string, hosts ...string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, name+".crt"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, name+".key"),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+}
+
+func servedName(t *testing.T, m *tlscert.Manager, sni string) string {
+	t.Helper()
+
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: sni})
+	assert.NoError(t, err)
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestGetCertificate(t *testing.T) {
+	dir := t.TempDir()
+	writeCert(t, dir, "a", "a.example.com")
+	writeCert(t, dir, "b", "*.example.com")
+	writeCert(t, dir, "c", "www.example.org", "example.org")
+
+	m, err := tlscert.New(dir, tlscert.WithDefault("c"))
+	assert.NoError(t, err)
+	defer m.Close()
+
+	assert.Equal(t, "a.example.com", servedName(t, m, "a.example.com"))
+	assert.Equal(t, "a.example.com", servedName(t, m, "A.Example.COM."))
+	// wildcard
+	assert.Equal(t, "*.example.com", servedName(t, m, "img.example.com"))
+	assert.Equal(t, "www.example.org", servedName(t, m, "example.org"))
+	// no SNI or not matched
+	assert.Equal(t, "www.example.org", servedName(t, m, ""))
+	assert.Equal(t, "www.example.org", servedName(t, m, "www.example.net"))
+
+	_, err = tlscert.New(dir, tlscert.WithDefault("none"))
+	assert.Error(t, err)
+	_, err = tlscert.New(t.TempDir())
+	assert.ErrorIs(t, err, tlscert.ErrNoCertificate)
+}
+
+func TestOCSPStapling(t *testing.T) {
+	dir := t.TempDir()
+	writeCert(t, dir, "a", "a.example.com")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.ocsp"), []byte("staple"), 0o600))
+
+	m, err := tlscert.New(dir, tlscert.WithOCSPStapling(true))
+	assert.NoError(t, err)
+
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("staple"), cert.OCSPStaple)
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	writeCert(t, dir, "a", "a.example.com")
+
+	m, err := tlscert.New(dir)
+	assert.NoError(t, err)
+	defer m.Close()
+	assert.NoError(t, m.Watch())
+
+	writeCert(t, dir, "b", "b.example.com")
+	assert.Eventually(t, func() bool {
+		cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "b.example.com"})
+		return err == nil && cert.Leaf.Subject.CommonName == "b.example.com"
+	}, 5*time.Second, 100*time.Millisecond)
+
+	// broken pair keeps the loaded certificates
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "c.crt"), []byte("broken"), 0o600))
+	assert.Error(t, m.Reload())
+	assert.Equal(t, "b.example.com", servedName(t, m, "b.example.com"))
+}
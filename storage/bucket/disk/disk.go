@@ -25,12 +25,22 @@ import (
 	"github.com/omalloc/tavern/api/defined/v1/storage/object"
 	"github.com/omalloc/tavern/contrib/log"
 	"github.com/omalloc/tavern/pkg/algorithm/heavykeeper"
-	"github.com/omalloc/tavern/pkg/algorithm/lru"
+	"github.com/omalloc/tavern/storage/eviction"
 	"github.com/omalloc/tavern/storage/indexdb"
+	"github.com/omalloc/tavern/storage/tagindex"
 )
 
 var _ storage.Bucket = (*diskBucket)(nil)
 
+const (
+	// reapInterval 过期对象清理周期
+	reapInterval = 10 * time.Minute
+	// reapGrace 过期对象在该时间窗口内仍被访问过则跳过清理, 留给 revalidate
+	reapGrace = time.Hour
+	// reapBatchSize 单次清理的最大对象数
+	reapBatchSize = 10_000
+)
+
 type diskBucket struct {
 	opt              *storage.BucketConfig
 	path             string
@@ -46,10 +56,14 @@ type diskBucket struct {
 	hkPromote        *heavykeeper.HeavyKeeper
 	lastPromoteReset time.Time
 	promMu           sync.Mutex
-	cache            *lru.Cache[object.IDHash, storage.Mark]
+	cache            eviction.Policy
+	evicted          chan eviction.Entry
 	fileFlag         int
 	fileMode         fs.FileMode
 	stop             chan struct{}
+	stopOnce         sync.Once
+	reaping          sync.WaitGroup
+	health           diskHealth
 }
 
 func New(opt *storage.BucketConfig, sharedkv storage.SharedKV) (storage.Bucket, error) {
@@ -63,12 +77,35 @@ func New(opt *storage.BucketConfig, sharedkv storage.SharedKV) (storage.Bucket,
 		hasMigration: opt.Migration != nil && opt.Migration.Enabled,
 		weight:       100, // default weight
 		sharedkv:     sharedkv,
-		cache:        lru.New[object.IDHash, storage.Mark](opt.MaxObjectLimit),
+		evicted:      make(chan eviction.Entry, 100),
 		fileFlag:     os.O_RDONLY,
 		fileMode:     fs.FileMode(0o755),
 		stop:         make(chan struct{}, 1),
 	}
 
+	cache, err := eviction.New(opt.EvictionPolicy, eviction.Option{
+		MaxObjects: opt.MaxObjectLimit,
+		MaxBytes:   opt.MaxCacheSize,
+		OnEvict:    bucket.onEvict,
+	})
+	if err != nil {
+		return nil, err
+	}
+	bucket.cache = cache
+
+	if opt.Weight > 0 {
+		bucket.weight = opt.Weight
+	}
+
+	bucket.health.maxUsage = opt.MaxUsagePercent
+	if bucket.health.maxUsage <= 0 || bucket.health.maxUsage > 100 {
+		bucket.health.maxUsage = defaultMaxUsagePercent
+	}
+	bucket.health.maxErrors = opt.MaxIOErrors
+	if bucket.health.maxErrors <= 0 {
+		bucket.health.maxErrors = defaultMaxIOErrors
+	}
+
 	if opt.Migration != nil && opt.Migration.Enabled {
 		// Default width 4096 if not set or small
 		width := opt.MaxObjectLimit
@@ -104,18 +141,34 @@ func New(opt *storage.BucketConfig, sharedkv storage.SharedKV) (storage.Bucket,
 	// load lru
 	bucket.loadLRU()
 
+	// reap expired objects
+	bucket.reaping.Add(1)
+	go bucket.reap()
+
+	// disk usage & bad disk detection
+	bucket.reaping.Add(1)
+	go bucket.healthCheck()
+
 	return bucket, nil
 }
 
+// onEvict hands over the victims to evict goroutine, file I/O is not done in the caller.
+func (d *diskBucket) onEvict(evicted []eviction.Entry) {
+	for _, e := range evicted {
+		select {
+		case <-d.stop:
+			return
+		case d.evicted <- e:
+		}
+	}
+}
+
 func (d *diskBucket) evict() {
 	clog := log.Context(context.Background())
 
-	ch := make(chan lru.Eviction[object.IDHash, storage.Mark], 100)
-	d.cache.EvictionChannel = ch
-
 	clog.Debugf("start evict goroutine for %s", d.ID())
 
-	demote := func(evicted lru.Eviction[object.IDHash, storage.Mark]) error {
+	demote := func(evicted eviction.Entry) error {
 		if d.migration != nil {
 			md, err := d.indexdb.Get(context.Background(), evicted.Key[:])
 			if err != nil {
@@ -130,10 +183,10 @@ func (d *diskBucket) evict() {
 		return nil
 	}
 
-	discard := func(evicted lru.Eviction[object.IDHash, storage.Mark]) {
+	discard := func(evicted eviction.Entry) {
 		fd := evicted.Key.WPath(d.path)
-		clog.Debugf("evict file %s, last-access %d", fd, evicted.Value.LastAccess())
-		cacheEvictionsTotal.WithLabelValues(d.ID(), "lru").Inc()
+		clog.Debugf("evict file %s, last-access %d", fd, evicted.Mark.LastAccess())
+		cacheEvictionsTotal.WithLabelValues(d.ID(), d.cache.Name()).Inc()
 		_ = d.DiscardWithHash(context.Background(), evicted.Key)
 	}
 
@@ -142,7 +195,7 @@ func (d *diskBucket) evict() {
 			select {
 			case <-d.stop:
 				return
-			case evicted := <-ch:
+			case evicted := <-d.evicted:
 				// expired cachefile Demote to other bucket
 				if d.migration != nil {
 
@@ -162,6 +215,70 @@ func (d *diskBucket) evict() {
 	}()
 }
 
+// reap 周期性清理已过期且长时间无人访问的对象, 回收磁盘空间.
+func (d *diskBucket) reap() {
+	defer d.reaping.Done()
+
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			n := d.reapExpired(context.Background())
+			if n > 0 {
+				log.Infof("bucket %s reaped %d expired objects", d.ID(), n)
+			}
+		}
+	}
+}
+
+func (d *diskBucket) reapExpired(ctx context.Context) int {
+	now := time.Now()
+	hashes := make([]object.IDHash, 0, 64)
+
+	err := d.indexdb.Expired(ctx, func(key []byte, meta *object.Metadata) bool {
+		select {
+		case <-d.stop:
+			return false
+		default:
+		}
+
+		if meta == nil || meta.ID == nil {
+			return true
+		}
+
+		hash := meta.ID.Hash()
+		// 最近仍有访问的对象留给 revalidate 处理
+		if mark, ok := d.cache.Peek(hash); ok && now.Sub(time.Unix(int64(mark.LastAccess()), 0)) < reapGrace {
+			return true
+		}
+
+		hashes = append(hashes, hash)
+		return len(hashes) < reapBatchSize
+	})
+	if err != nil {
+		log.Warnf("bucket %s iterate expired objects failed: %v", d.ID(), err)
+	}
+
+	reaped := 0
+	for _, hash := range hashes {
+		if err1 := d.DiscardWithHash(ctx, hash); err1 != nil {
+			continue
+		}
+		d.cache.Remove(hash)
+		cacheEvictionsTotal.WithLabelValues(d.ID(), "expired").Inc()
+		reaped++
+	}
+
+	if reaped > 0 {
+		cacheObjectsGauge.WithLabelValues(d.ID()).Set(float64(d.cache.Len()))
+	}
+	return reaped
+}
+
 func (d *diskBucket) loadLRU() {
 
 	load := func(async bool) {
@@ -191,7 +308,7 @@ func (d *diskBucket) loadLRU() {
 			if meta != nil {
 				mdCount++
 				chunkCount += meta.Chunks.Count()
-				d.cache.Set(meta.ID.Hash(), storage.NewMark(meta.LastRefUnix, meta.Refs))
+				d.cache.Set(meta.ID.Hash(), storage.NewMark(meta.LastRefUnix, meta.Refs), eviction.SizeOf(meta))
 
 				// store service domains
 				// TODO: add Debounce incr
@@ -201,6 +318,8 @@ func (d *diskBucket) loadLRU() {
 
 				// backfill inverted index for directory purge
 				_ = d.sharedkv.Set(context.Background(), []byte(fmt.Sprintf("ix/%s/%s", d.ID(), meta.ID.Key())), meta.ID.Bytes())
+				// backfill cache tag index
+				tagindex.Index(context.Background(), d.sharedkv, d.ID(), d.opt.TagHeader, meta)
 
 				counter.Incr(1)
 				blockCounter.Incr(int64(meta.Chunks.Count()))
@@ -293,8 +412,12 @@ func (d *diskBucket) discard(ctx context.Context, md *object.Metadata) error {
 		}
 	})
 
+	d.cache.Remove(md.ID.Hash())
+
 	// 删除目录倒排索引
 	_ = d.sharedkv.Delete(ctx, []byte(fmt.Sprintf("ix/%s/%s", d.ID(), md.ID.Key())))
+	// 删除缓存标签索引
+	tagindex.Unindex(ctx, d.sharedkv, d.ID(), d.opt.TagHeader, md)
 
 	if u, err1 := url.Parse(md.ID.Path()); err1 == nil {
 		_, _ = d.sharedkv.Decr(ctx, []byte(fmt.Sprintf("if/domain/%s", u.Host)), 1)
@@ -321,6 +444,13 @@ func (d *diskBucket) Iterate(ctx context.Context, fn func(*object.Metadata) erro
 	})
 }
 
+// Scan implements storage.Bucket.
+func (d *diskBucket) Scan(ctx context.Context, from []byte, fn func(*object.Metadata) bool) error {
+	return d.indexdb.Scan(ctx, from, func(key []byte, val *object.Metadata) bool {
+		return fn(val)
+	})
+}
+
 // Lookup implements storage.Bucket.
 func (d *diskBucket) Lookup(ctx context.Context, id *object.ID) (*object.Metadata, error) {
 	start := time.Now()
@@ -359,9 +489,12 @@ func (d *diskBucket) Store(ctx context.Context, meta *object.Metadata) error {
 	meta.Headers.Del("X-Protocol-Cache")
 	meta.Headers.Del("X-Protocol-Request-Id")
 
-	if !d.cache.Has(meta.ID.Hash()) {
-		d.cache.Set(meta.ID.Hash(), storage.NewMark(meta.LastRefUnix, meta.Refs))
+	// 更新对象占用空间, 已存在的对象保留访问标记
+	mark, ok := d.cache.Peek(meta.ID.Hash())
+	if !ok {
+		mark = storage.NewMark(meta.LastRefUnix, meta.Refs)
 	}
+	d.cache.Set(meta.ID.Hash(), mark, eviction.SizeOf(meta))
 
 	start := time.Now()
 	if err := d.indexdb.Set(ctx, meta.ID.Bytes(), meta); err != nil {
@@ -383,12 +516,14 @@ func (d *diskBucket) Store(ctx context.Context, meta *object.Metadata) error {
 		// ignore sharedkv error to not affect main storage
 		_ = err
 	}
+	// 写入缓存标签索引
+	tagindex.Index(ctx, d.sharedkv, d.ID(), d.opt.TagHeader, meta)
 	return nil
 }
 
 func (d *diskBucket) touch(_ context.Context, id *object.ID) {
-	mark := d.cache.Get(id.Hash())
-	if mark == nil {
+	mark, ok := d.cache.Get(id.Hash())
+	if !ok {
 		return
 	}
 	if mark.LastAccess() <= 0 {
@@ -398,7 +533,7 @@ func (d *diskBucket) touch(_ context.Context, id *object.ID) {
 	mark.SetLastAccess(time.Now().Unix())
 	mark.SetRefs(mark.Refs() + 1)
 
-	d.cache.Set(id.Hash(), *mark)
+	d.cache.Update(id.Hash(), mark)
 
 	// 如果迁移开启的，则进行计算窗口期是否满足迁移配置
 	if d.hasMigration {
@@ -428,8 +563,9 @@ func (d *diskBucket) touch(_ context.Context, id *object.ID) {
 }
 
 // HasBad implements storage.Bucket.
+// 短时间内 I/O 错误过多或磁盘不可访问时为 true, 由 storage 从 hash ring 中摘除.
 func (d *diskBucket) HasBad() bool {
-	return false
+	return d.health.bad.Load()
 }
 
 // ID implements storage.Bucket.
@@ -448,9 +584,9 @@ func (d *diskBucket) Type() string {
 }
 
 // UseAllow implements storage.Bucket.
+// 磁盘空间或 inode 使用率达到 Allow() 时返回 false.
 func (d *diskBucket) UseAllow() bool {
-	// TODO: check disk usage if the bucket is full, return false
-	return true
+	return !d.health.full.Load()
 }
 
 // Weight implements storage.Bucket.
@@ -458,9 +594,14 @@ func (d *diskBucket) Weight() int {
 	return d.weight
 }
 
+// Available returns the free bytes of disk, used by freespace selector.
+func (d *diskBucket) Available() uint64 {
+	return d.health.avail.Load()
+}
+
 // Allow implements storage.Bucket.
 func (d *diskBucket) Allow() int {
-	panic("unimplemented")
+	return d.health.maxUsage
 }
 
 // Objects implements storage.Bucket.
@@ -476,7 +617,7 @@ func (d *diskBucket) TopK(k int) []string {
 	arr := d.cache.TopK(k)
 	ret := make([]string, 0, len(arr))
 	for i := range arr {
-		mark := d.cache.Peek(arr[i])
+		mark, _ := d.cache.Peek(arr[i])
 		md, _ := d.indexdb.Get(context.Background(), arr[i][:])
 		if md != nil {
 			ret = append(ret, fmt.Sprintf("%s@@%s@@%d", md.ID.Path(), time.Unix(int64(mark.LastAccess()), 0).Format(time.DateTime), mark.Refs()))
@@ -492,18 +633,26 @@ func (d *diskBucket) WriteChunkFile(ctx context.Context, id *object.ID, index ui
 	tmpPath := wpath + time.Now().Format(".tmp20060102150405")
 	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_RDWR, d.fileMode)
 	if err != nil {
+		d.reportIOError("open", err)
 		return nil, wpath, fmt.Errorf("bucket open-file chunk[%d] failed err %w", index, err)
 	}
 
-	return iobuf.ChunkWriterCloser(f, func() error {
-		return os.Rename(tmpPath, wpath)
+	return iobuf.ChunkWriterCloser(&ioErrWriter{ReadWriteCloser: f, report: d.reportIOError}, func() error {
+		err := os.Rename(tmpPath, wpath)
+		d.reportIOError("rename", err)
+		return err
 	}), wpath, nil
 }
 
 func (d *diskBucket) ReadChunkFile(ctx context.Context, id *object.ID, index uint32) (storage.File, string, error) {
 	wpath := id.WPathSlice(d.path, index)
 	f, err := os.OpenFile(wpath, d.fileFlag, d.fileMode)
-	return f, wpath, err
+	if err != nil {
+		d.reportIOError("read", err)
+		// avoid typed-nil interface
+		return nil, wpath, err
+	}
+	return f, wpath, nil
 }
 
 // Migrate implements [storage.Bucket].
@@ -568,6 +717,10 @@ func (d *diskBucket) SetMigration(migration storage.Migration) error {
 
 // Close implements storage.Bucket.
 func (d *diskBucket) Close() error {
+	d.stopOnce.Do(func() {
+		close(d.stop)
+	})
+	d.reaping.Wait()
 	return d.indexdb.Close()
 }
 
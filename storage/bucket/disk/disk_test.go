@@ -3,7 +3,9 @@ package disk_test
 import (
 	"context"
 	"net/http"
+	"os"
 	"path"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -81,3 +83,84 @@ func TestHitKey(t *testing.T) {
 
 	t.Logf("filepath=%s", cackeKey.WPath("/"))
 }
+
+func TestBadDiskDetection(t *testing.T) {
+	basepath := t.TempDir()
+	bucket, err := disk.New(&storagev1.BucketConfig{
+		Path:        basepath,
+		Driver:      "native",
+		Type:        storagev1.TypeWarm,
+		DBType:      "pebble",
+		DBPath:      path.Join(basepath, ".indexdb"),
+		MaxIOErrors: 2,
+	}, sharedkv.NewEmpty())
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = bucket.Close() })
+
+	assert.False(t, bucket.HasBad())
+	assert.True(t, bucket.UseAllow())
+	assert.Equal(t, 95, bucket.Allow())
+
+	id := object.NewID("http://www.example.com/path/to/bad.bin")
+
+	// missing chunk is not an I/O error
+	_, _, err = bucket.ReadChunkFile(context.Background(), id, 0)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, _, _ = bucket.ReadChunkFile(context.Background(), id, 0)
+	assert.False(t, bucket.HasBad())
+
+	// a regular file in place of the chunk directory, open returns ENOTDIR
+	wpath := id.WPathSlice(basepath, 0)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Dir(wpath)), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Dir(wpath), []byte("x"), 0o644))
+
+	for i := 0; i < 2; i++ {
+		_, _, err = bucket.ReadChunkFile(context.Background(), id, 0)
+		assert.Error(t, err)
+	}
+	assert.True(t, bucket.HasBad())
+}
+
+func TestEvictionByBytes(t *testing.T) {
+	basepath := t.TempDir()
+	bucket, err := disk.New(&storagev1.BucketConfig{
+		Path:           basepath,
+		Driver:         "native",
+		Type:           storagev1.TypeWarm,
+		DBType:         "pebble",
+		DBPath:         path.Join(basepath, ".indexdb"),
+		EvictionPolicy: "lru",
+		MaxCacheSize:   2 << 20,
+	}, sharedkv.NewEmpty())
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = bucket.Close() })
+
+	ctx := context.Background()
+	store := func(rawurl string) *object.ID {
+		id := object.NewID(rawurl)
+		md := &object.Metadata{
+			ID:        id,
+			Code:      http.StatusOK,
+			Size:      1 << 20,
+			BlockSize: 1 << 20,
+			Headers:   make(http.Header),
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		}
+		md.Chunks.Set(0)
+		assert.NoError(t, bucket.Store(ctx, md))
+		return id
+	}
+
+	first := store("http://www.example.com/1M-1.bin")
+	second := store("http://www.example.com/1M-2.bin")
+	bucket.Touch(ctx, first)
+
+	// 3M over 2M capacity, the least recently used object is evicted
+	third := store("http://www.example.com/1M-3.bin")
+	assert.Eventually(t, func() bool {
+		return !bucket.Exist(ctx, second.Bytes())
+	}, time.Second, 10*time.Millisecond)
+	assert.True(t, bucket.Exist(ctx, first.Bytes()))
+	assert.True(t, bucket.Exist(ctx, third.Bytes()))
+	assert.Equal(t, uint64(2), bucket.Objects())
+}
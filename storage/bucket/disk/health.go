@@ -0,0 +1,213 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/omalloc/tavern/contrib/log"
+)
+
+const (
+	// defaultMaxUsagePercent 磁盘空间或 inode 使用率超过该值时不再写入新对象
+	defaultMaxUsagePercent = 95
+	// defaultMaxIOErrors ioErrorWindow 内 I/O 错误达到该值时标记为坏盘
+	defaultMaxIOErrors = 10
+	ioErrorWindow      = time.Minute
+	// healthCheckInterval 磁盘使用率与坏盘恢复检测周期
+	healthCheckInterval = 10 * time.Second
+)
+
+type diskUsage struct {
+	total      uint64
+	avail      uint64
+	inodes     uint64
+	freeInodes uint64
+}
+
+func (u diskUsage) usedPercent() int {
+	if u.total == 0 {
+		return 0
+	}
+	return int((u.total - u.avail) * 100 / u.total)
+}
+
+func (u diskUsage) inodeUsedPercent() int {
+	if u.inodes == 0 {
+		return 0
+	}
+	return int((u.inodes - u.freeInodes) * 100 / u.inodes)
+}
+
+// diskHealth tracks I/O errors and disk usage of a bucket.
+type diskHealth struct {
+	mu        sync.Mutex
+	ioErrors  int
+	firstErr  time.Time
+	lastErr   time.Time
+	reason    string
+	bad       atomic.Bool
+	full      atomic.Bool
+	avail     atomic.Uint64
+	maxUsage  int
+	maxErrors int
+}
+
+// reportIOError records an I/O error of chunk file, missing file is not an I/O error.
+func (d *diskBucket) reportIOError(op string, err error) {
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+
+	diskIOErrorsTotal.WithLabelValues(d.ID(), op).Inc()
+
+	h := &d.health
+	now := time.Now()
+
+	h.mu.Lock()
+	if h.ioErrors == 0 || now.Sub(h.firstErr) > ioErrorWindow {
+		h.ioErrors = 0
+		h.firstErr = now
+	}
+	h.ioErrors++
+	h.lastErr = now
+
+	if h.bad.Load() || h.ioErrors < h.maxErrors {
+		h.mu.Unlock()
+		return
+	}
+	h.reason = fmt.Sprintf("%d I/O errors within %s, last %s: %v", h.ioErrors, ioErrorWindow, op, err)
+	h.bad.Store(true)
+	h.mu.Unlock()
+
+	log.Errorf("bucket %s marked bad: %s", d.ID(), h.reason)
+	bucketBadGauge.WithLabelValues(d.ID()).Set(1)
+}
+
+// healthCheck periodically refreshes the disk usage and tries to recover a bad bucket.
+func (d *diskBucket) healthCheck() {
+	defer d.reaping.Done()
+
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	d.checkHealth()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			d.checkHealth()
+		}
+	}
+}
+
+func (d *diskBucket) checkHealth() {
+	h := &d.health
+
+	usage, err := statfs(d.path)
+	if err != nil {
+		d.markBad(fmt.Sprintf("statfs: %v", err))
+		return
+	}
+
+	h.avail.Store(usage.avail)
+
+	space, inode := usage.usedPercent(), usage.inodeUsedPercent()
+	diskUsagePercent.WithLabelValues(d.ID(), "space").Set(float64(space))
+	diskUsagePercent.WithLabelValues(d.ID(), "inode").Set(float64(inode))
+
+	full := space >= h.maxUsage || inode >= h.maxUsage
+	if h.full.Swap(full) != full {
+		if full {
+			log.Warnf("bucket %s usage space %d%% inode %d%% reached %d%%, stop writing new objects", d.ID(), space, inode, h.maxUsage)
+		} else {
+			log.Infof("bucket %s usage space %d%% inode %d%% below %d%%, writable again", d.ID(), space, inode, h.maxUsage)
+		}
+	}
+
+	if !h.bad.Load() {
+		return
+	}
+
+	// 错误窗口内仍有新的 I/O 错误, 继续保持坏盘状态
+	h.mu.Lock()
+	quiet := time.Since(h.lastErr) > ioErrorWindow
+	h.mu.Unlock()
+	if !quiet {
+		return
+	}
+
+	if err := d.probe(); err != nil {
+		d.markBad(fmt.Sprintf("probe: %v", err))
+		return
+	}
+
+	h.mu.Lock()
+	h.ioErrors = 0
+	h.reason = ""
+	h.bad.Store(false)
+	h.mu.Unlock()
+
+	log.Infof("bucket %s recovered", d.ID())
+	bucketBadGauge.WithLabelValues(d.ID()).Set(0)
+}
+
+func (d *diskBucket) markBad(reason string) {
+	h := &d.health
+
+	h.mu.Lock()
+	h.reason = reason
+	h.lastErr = time.Now()
+	changed := !h.bad.Swap(true)
+	h.mu.Unlock()
+
+	if changed {
+		log.Errorf("bucket %s marked bad: %s", d.ID(), reason)
+		bucketBadGauge.WithLabelValues(d.ID()).Set(1)
+	}
+}
+
+// probe writes, reads and removes a small file in the bucket path.
+func (d *diskBucket) probe() error {
+	name := filepath.Join(d.path, ".probe")
+	payload := []byte(time.Now().Format(time.RFC3339Nano))
+
+	if err := os.WriteFile(name, payload, 0o644); err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(name) }()
+
+	got, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if string(got) != string(payload) {
+		return fmt.Errorf("probe file content mismatch")
+	}
+	return nil
+}
+
+// ioErrWriter reports the write errors of chunk file.
+type ioErrWriter struct {
+	io.ReadWriteCloser
+	report func(op string, err error)
+}
+
+func (w *ioErrWriter) Write(p []byte) (int, error) {
+	n, err := w.ReadWriteCloser.Write(p)
+	w.report("write", err)
+	return n, err
+}
+
+func (w *ioErrWriter) Close() error {
+	err := w.ReadWriteCloser.Close()
+	w.report("write", err)
+	return err
+}
@@ -24,7 +24,7 @@ var (
 	}, []string{"bucket", "direction"})
 
 	// cacheEvictionsTotal counts cache eviction events by bucket and reason.
-	// Labels: bucket, reason (lru/demote/discard)
+	// Labels: bucket, reason (fifo/lru/lfu/size/demote/expired)
 	cacheEvictionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Namespace: pkgmetrics.Namespace,
 		Name:      "cache_evictions_total",
@@ -46,6 +46,30 @@ var (
 		Name:      "cache_objects",
 		Help:      "The current number of cached objects per bucket",
 	}, []string{"bucket"})
+
+	// diskIOErrorsTotal counts chunk file I/O errors by bucket and operation.
+	// Labels: bucket, op (open/read/write/rename)
+	diskIOErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: pkgmetrics.Namespace,
+		Name:      "disk_io_errors_total",
+		Help:      "The total number of chunk file I/O errors by bucket and operation",
+	}, []string{"bucket", "op"})
+
+	// bucketBadGauge is 1 while the bucket is marked bad and ejected from the hash ring.
+	// Labels: bucket
+	bucketBadGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: pkgmetrics.Namespace,
+		Name:      "bucket_bad",
+		Help:      "Whether the bucket is marked bad (1) or healthy (0)",
+	}, []string{"bucket"})
+
+	// diskUsagePercent tracks disk space and inode usage of bucket path.
+	// Labels: bucket, kind (space/inode)
+	diskUsagePercent = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: pkgmetrics.Namespace,
+		Name:      "disk_usage_percent",
+		Help:      "The disk space and inode usage percent of bucket path",
+	}, []string{"bucket", "kind"})
 )
 
 func init() {
@@ -55,5 +79,8 @@ func init() {
 		cacheEvictionsTotal,
 		cacheMigrationTotal,
 		cacheObjectsGauge,
+		diskIOErrorsTotal,
+		bucketBadGauge,
+		diskUsagePercent,
 	)
 }
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package disk
+
+// statfs is not supported, usage check is skipped.
+func statfs(path string) (diskUsage, error) {
+	return diskUsage{}, nil
+}
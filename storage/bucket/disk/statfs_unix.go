@@ -0,0 +1,20 @@
+//go:build linux || darwin
+
+package disk
+
+import "syscall"
+
+func statfs(path string) (diskUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return diskUsage{}, err
+	}
+
+	bsize := uint64(st.Bsize)
+	return diskUsage{
+		total:      uint64(st.Blocks) * bsize,
+		avail:      uint64(st.Bavail) * bsize,
+		inodes:     uint64(st.Files),
+		freeInodes: uint64(st.Ffree),
+	}, nil
+}
@@ -74,6 +74,11 @@ func (e *emptyBucket) Iterate(ctx context.Context, fn func(*object.Metadata) err
 	return nil
 }
 
+// Scan implements storage.Bucket.
+func (e *emptyBucket) Scan(ctx context.Context, from []byte, fn func(*object.Metadata) bool) error {
+	return nil
+}
+
 // Lookup implements storage.Bucket.
 func (e *emptyBucket) Lookup(ctx context.Context, id *object.ID) (*object.Metadata, error) {
 	return nil, storage.ErrKeyNotFound
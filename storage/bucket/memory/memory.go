@@ -17,9 +17,10 @@ import (
 	"github.com/omalloc/tavern/api/defined/v1/storage"
 	"github.com/omalloc/tavern/api/defined/v1/storage/object"
 	"github.com/omalloc/tavern/contrib/log"
-	"github.com/omalloc/tavern/pkg/algorithm/lru"
 	"github.com/omalloc/tavern/pkg/iobuf"
+	"github.com/omalloc/tavern/storage/eviction"
 	"github.com/omalloc/tavern/storage/indexdb"
+	"github.com/omalloc/tavern/storage/tagindex"
 )
 
 var _ storage.Bucket = (*memoryBucket)(nil)
@@ -32,9 +33,10 @@ type memoryBucket struct {
 	storeType string
 	weight    int
 	sharedkv  storage.SharedKV
+	tagHeader string
 	indexdb   storage.IndexDB
 	migration storage.Migration
-	cache     *lru.Cache[object.IDHash, storage.Mark]
+	cache     eviction.Policy
 	fileFlag  int
 	fileMode  fs.FileMode
 	maxSize   uint64
@@ -51,13 +53,30 @@ func New(opt *storage.BucketConfig, sharedkv storage.SharedKV) (storage.Bucket,
 		storeType: opt.Type,
 		weight:    100, // default weight
 		sharedkv:  sharedkv,
-		cache:     lru.New[object.IDHash, storage.Mark](opt.MaxObjectLimit), // in-memory object size
+		tagHeader: opt.TagHeader,
 		fileFlag:  os.O_RDONLY,
 		fileMode:  fs.FileMode(0o755),
 		maxSize:   1024 * 1024 * 100, // e.g. 100 MB
 		stop:      make(chan struct{}, 1),
 	}
 
+	if opt.MaxCacheSize > 0 {
+		mb.maxSize = opt.MaxCacheSize
+	}
+	cache, err := eviction.New(opt.EvictionPolicy, eviction.Option{
+		MaxObjects: opt.MaxObjectLimit,
+		MaxBytes:   mb.maxSize, // in-memory object size
+		OnEvict:    mb.onEvict,
+	})
+	if err != nil {
+		return nil, err
+	}
+	mb.cache = cache
+
+	if opt.Weight > 0 {
+		mb.weight = opt.Weight
+	}
+
 	// create indexdb only in-memory
 	db, err := indexdb.Create("pebble", indexdb.NewOption(mb.dbPath, indexdb.WithType("pebble")))
 	if err != nil {
@@ -68,6 +87,20 @@ func New(opt *storage.BucketConfig, sharedkv storage.SharedKV) (storage.Bucket,
 	return mb, nil
 }
 
+// Available returns the free bytes of in-memory capacity, used by freespace selector.
+func (m *memoryBucket) Available() uint64 {
+	used := m.cache.Bytes()
+	if used >= m.maxSize {
+		return 0
+	}
+	return m.maxSize - used
+}
+
+// Capacity returns the max bytes of in-memory objects, the eviction makes room for the new ones.
+func (m *memoryBucket) Capacity() uint64 {
+	return m.maxSize
+}
+
 // Allow implements [storage.Bucket].
 func (m *memoryBucket) Allow() int {
 	return int(m.maxSize)
@@ -127,8 +160,12 @@ func (m *memoryBucket) discard(ctx context.Context, md *object.Metadata) error {
 		}
 	})
 
+	m.cache.Remove(md.ID.Hash())
+
 	// 删除目录倒排索引
 	_ = m.sharedkv.Delete(ctx, []byte(fmt.Sprintf("ix/%s/%s", m.ID(), md.ID.Key())))
+	// 删除缓存标签索引
+	tagindex.Unindex(ctx, m.sharedkv, m.ID(), m.tagHeader, md)
 
 	if u, err1 := url.Parse(md.ID.Path()); err1 == nil {
 		_, _ = m.sharedkv.Decr(ctx, []byte(fmt.Sprintf("if/domain/%s", u.Host)), 1)
@@ -196,6 +233,13 @@ func (m *memoryBucket) Iterate(ctx context.Context, fn func(*object.Metadata) er
 	})
 }
 
+// Scan implements [storage.Bucket].
+func (m *memoryBucket) Scan(ctx context.Context, from []byte, fn func(*object.Metadata) bool) error {
+	return m.indexdb.Scan(ctx, from, func(key []byte, val *object.Metadata) bool {
+		return fn(val)
+	})
+}
+
 // Lookup implements [storage.Bucket].
 func (m *memoryBucket) Lookup(ctx context.Context, id *object.ID) (*object.Metadata, error) {
 	md, err := m.indexdb.Get(ctx, id.Bytes())
@@ -204,8 +248,8 @@ func (m *memoryBucket) Lookup(ctx context.Context, id *object.ID) (*object.Metad
 
 // Touch implements [storage.Bucket].
 func (m *memoryBucket) Touch(ctx context.Context, id *object.ID) {
-	mark := m.cache.Get(id.Hash())
-	if mark == nil {
+	mark, ok := m.cache.Get(id.Hash())
+	if !ok {
 		return
 	}
 	if mark.LastAccess() <= 0 {
@@ -215,7 +259,14 @@ func (m *memoryBucket) Touch(ctx context.Context, id *object.ID) {
 	mark.SetLastAccess(time.Now().Unix())
 	mark.SetRefs(mark.Refs() + 1)
 
-	m.cache.Set(id.Hash(), *mark)
+	m.cache.Update(id.Hash(), mark)
+}
+
+// onEvict discards the victims, in-memory discard is cheap enough to run in the caller.
+func (m *memoryBucket) onEvict(evicted []eviction.Entry) {
+	for _, e := range evicted {
+		_ = m.DiscardWithHash(context.Background(), e.Key)
+	}
 }
 
 // Path implements [storage.Bucket].
@@ -228,7 +279,7 @@ func (m *memoryBucket) TopK(k int) []string {
 	arr := m.cache.TopK(k)
 	ret := make([]string, 0, len(arr))
 	for i := range arr {
-		mark := m.cache.Peek(arr[i])
+		mark, _ := m.cache.Peek(arr[i])
 		md, _ := m.indexdb.Get(context.Background(), arr[i][:])
 		if md != nil {
 			ret = append(ret, fmt.Sprintf("%s@@%s@@%d", md.ID.Path(), time.Unix(int64(mark.LastAccess()), 0).Format(time.DateTime), mark.Refs()))
@@ -260,8 +311,12 @@ func (m *memoryBucket) Store(ctx context.Context, meta *object.Metadata) error {
 		return err
 	}
 
-	// update lru
-	m.cache.Set(meta.ID.Hash(), storage.NewMark(meta.LastRefUnix, meta.Refs))
+	// update eviction policy, keep the access mark of existing object
+	mark, ok := m.cache.Peek(meta.ID.Hash())
+	if !ok {
+		mark = storage.NewMark(meta.LastRefUnix, meta.Refs)
+	}
+	m.cache.Set(meta.ID.Hash(), mark, eviction.SizeOf(meta))
 
 	// save domains counter
 	if u, err1 := url.Parse(meta.ID.Path()); err1 == nil {
@@ -274,6 +329,8 @@ func (m *memoryBucket) Store(ctx context.Context, meta *object.Metadata) error {
 		// ignore sharedkv error to not affect main storage
 		_ = err
 	}
+	// save cache tag index
+	tagindex.Index(ctx, m.sharedkv, m.ID(), m.tagHeader, meta)
 
 	return nil
 }
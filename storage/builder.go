@@ -20,6 +20,7 @@ type globalBucketOption struct {
 	Driver          string
 	DBType          string
 	DBPath          string
+	TagHeader       string
 	Migration       *storage.MigrationConfig
 }
 
@@ -44,11 +45,18 @@ func mergeConfig(global *globalBucketOption, bucket *conf.Bucket) *storage.Bucke
 		Path:           bucket.Path,
 		Driver:         bucket.Driver,
 		Type:           bucket.Type,
+		Weight:         bucket.Weight,
 		DBType:         bucket.DBType,
 		DBPath:         bucket.DBPath,
 		MaxObjectLimit: bucket.MaxObjectLimit,
+		MaxCacheSize:   bucket.MaxCacheSize,
+		EvictionPolicy: bucket.EvictionPolicy,
 		Migration:      global.Migration, // migration config
 		DBConfig:       bucket.DBConfig,  // custom db config
+
+		MaxUsagePercent: bucket.MaxUsagePercent,
+		MaxIOErrors:     bucket.MaxIOErrors,
+		TagHeader:       global.TagHeader,
 	}
 
 	if copied.Driver == "" {
@@ -61,6 +69,9 @@ func mergeConfig(global *globalBucketOption, bucket *conf.Bucket) *storage.Bucke
 	if copied.Type == storage.TypeNormal {
 		copied.Type = storage.TypeWarm
 	}
+	if copied.EvictionPolicy == "" {
+		copied.EvictionPolicy = global.EvictionPolicy
+	}
 	if copied.DBType == "" {
 		copied.DBType = global.DBType
 	}
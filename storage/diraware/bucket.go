@@ -17,12 +17,17 @@ type wrappedBucket struct {
 }
 
 func wrapBucket(base storagev1.Bucket, checker Checker) storagev1.Bucket {
-	if checker == nil {
+	if base == nil || checker == nil {
 		return base
 	}
 	return &wrappedBucket{base: base, checker: checker}
 }
 
+// Unwrap returns the base bucket.
+func (b *wrappedBucket) Unwrap() storagev1.Bucket {
+	return b.base
+}
+
 func (b *wrappedBucket) Lookup(ctx context.Context, id *object.ID) (*object.Metadata, error) {
 	md, err := b.base.Lookup(ctx, id)
 	if err != nil || md == nil {
@@ -82,6 +87,10 @@ func (b *wrappedBucket) Iterate(ctx context.Context, fn func(*object.Metadata) e
 	return b.base.Iterate(ctx, fn)
 }
 
+func (b *wrappedBucket) Scan(ctx context.Context, from []byte, fn func(*object.Metadata) bool) error {
+	return b.base.Scan(ctx, from, fn)
+}
+
 func (b *wrappedBucket) Expired(ctx context.Context, id *object.ID, md *object.Metadata) bool {
 	return b.base.Expired(ctx, id, md)
 }
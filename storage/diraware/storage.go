@@ -35,6 +35,14 @@ func (w *wrappedStorage) Select(ctx context.Context, id *object.ID) storagev1.Bu
 	return wrapBucket(w.base.Select(ctx, id), w.checker)
 }
 
+// SelectLayer implements [storagev1.LayerSelector], nil if the base storage has no layers.
+func (w *wrappedStorage) SelectLayer(ctx context.Context, id *object.ID, layer string) storagev1.Bucket {
+	if ls, ok := w.base.(storagev1.LayerSelector); ok {
+		return wrapBucket(ls.SelectLayer(ctx, id, layer), w.checker)
+	}
+	return nil
+}
+
 func (w *wrappedStorage) Rebuild(ctx context.Context, buckets []storagev1.Bucket) error {
 	return w.base.Rebuild(ctx, buckets)
 }
@@ -0,0 +1,115 @@
+// Package eviction provides the cache eviction policies of buckets.
+//
+// A policy tracks every cached object of a bucket with its access mark and
+// size on disk, and evicts the victims once the bucket exceeds its object
+// count or byte capacity.
+package eviction
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+)
+
+const (
+	FIFO = "fifo"
+	LRU  = "lru"
+	LFU  = "lfu"
+	// Size is the size-aware GDSF (Greedy-Dual-Size-Frequency) policy,
+	// large and rarely used objects are evicted first.
+	Size = "size"
+
+	// DefaultPolicy is used when the policy is not configured.
+	DefaultPolicy = LRU
+)
+
+// Entry is a tracked object.
+type Entry struct {
+	Key  object.IDHash
+	Mark storage.Mark
+	Size uint64 // bytes on disk
+}
+
+// Policy tracks the cached objects and picks the victims to evict.
+type Policy interface {
+	// Name returns the policy name.
+	Name() string
+	// Set inserts the entry or updates the mark and size of an existing entry,
+	// updating is not counted as an access.
+	Set(key object.IDHash, mark storage.Mark, size uint64)
+	// Update updates the mark of an existing entry without counting as an access.
+	Update(key object.IDHash, mark storage.Mark) bool
+	// Get returns the mark of entry and records an access.
+	Get(key object.IDHash) (storage.Mark, bool)
+	// Peek returns the mark of entry without recording an access.
+	Peek(key object.IDHash) (storage.Mark, bool)
+	// Has reports whether the entry exists.
+	Has(key object.IDHash) bool
+	// Remove removes the entry, returns false if not exists.
+	Remove(key object.IDHash) bool
+	// Len returns the number of entries.
+	Len() int
+	// Bytes returns the total size of entries.
+	Bytes() uint64
+	// TopK returns the k most frequently accessed keys.
+	TopK(k int) []object.IDHash
+}
+
+// Option is the capacity of a policy, zero disables the bound.
+type Option struct {
+	MaxObjects int
+	MaxBytes   uint64
+	// OnEvict is called with the evicted entries outside the policy lock,
+	// the entries are already removed from policy.
+	OnEvict func(evicted []Entry)
+}
+
+type Factory func(opt Option) Policy
+
+var registry = map[string]Factory{
+	FIFO: func(opt Option) Policy { return newHeapPolicy(FIFO, opt, scoreFIFO, false) },
+	LRU:  func(opt Option) Policy { return newHeapPolicy(LRU, opt, scoreLRU, false) },
+	LFU:  func(opt Option) Policy { return newHeapPolicy(LFU, opt, scoreLFU, false) },
+	Size: func(opt Option) Policy { return newHeapPolicy(Size, opt, scoreGDSF, true) },
+}
+
+// Register registers a custom policy.
+func Register(name string, factory Factory) {
+	registry[name] = factory
+}
+
+// New creates the policy by name, empty name uses DefaultPolicy.
+func New(name string, opt Option) (Policy, error) {
+	if name == "" {
+		name = DefaultPolicy
+	}
+	factory, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown eviction policy %q", name)
+	}
+	return factory(opt), nil
+}
+
+// Names returns the registered policy names.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SizeOf returns the bytes of the cached chunks of object.
+func SizeOf(md *object.Metadata) uint64 {
+	if md == nil || md.BlockSize == 0 {
+		return 0
+	}
+	size := uint64(md.Chunks.Count()) * md.BlockSize
+	if md.Size > 0 && size > md.Size {
+		size = md.Size
+	}
+	return size
+}
@@ -0,0 +1,128 @@
+package eviction_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+	"github.com/omalloc/tavern/storage/eviction"
+)
+
+func key(s string) object.IDHash {
+	return object.NewID(s).Hash()
+}
+
+func newPolicy(t *testing.T, name string, opt eviction.Option) (eviction.Policy, *[]object.IDHash) {
+	evicted := make([]object.IDHash, 0)
+	opt.OnEvict = func(entries []eviction.Entry) {
+		for _, e := range entries {
+			evicted = append(evicted, e.Key)
+		}
+	}
+	p, err := eviction.New(name, opt)
+	assert.NoError(t, err)
+	return p, &evicted
+}
+
+func TestPolicyOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		victim string
+	}{
+		// a is inserted first
+		{name: eviction.FIFO, victim: "a"},
+		// b is the least recently accessed
+		{name: eviction.LRU, victim: "b"},
+		// c is the least frequently accessed
+		{name: eviction.LFU, victim: "c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, evicted := newPolicy(t, tt.name, eviction.Option{MaxObjects: 3})
+
+			p.Set(key("a"), 0, 1)
+			p.Set(key("b"), 0, 1)
+			p.Set(key("c"), 0, 1)
+
+			p.Get(key("b"))
+			p.Get(key("a"))
+			p.Get(key("a"))
+			p.Get(key("b"))
+			p.Get(key("c"))
+			p.Get(key("a"))
+
+			// peek and update do not count as access
+			p.Peek(key("b"))
+			p.Update(key("b"), 1)
+
+			p.Set(key("d"), 0, 1)
+			assert.Equal(t, []object.IDHash{key(tt.victim)}, *evicted)
+			assert.Equal(t, 3, p.Len())
+			assert.False(t, p.Has(key(tt.victim)))
+			assert.True(t, p.Has(key("d")))
+		})
+	}
+}
+
+func TestPolicyBytes(t *testing.T) {
+	p, evicted := newPolicy(t, eviction.LRU, eviction.Option{MaxBytes: 10 << 20})
+
+	p.Set(key("a"), 0, 4<<20)
+	p.Set(key("b"), 0, 4<<20)
+	assert.Equal(t, uint64(8<<20), p.Bytes())
+
+	// resize is not an access and does not evict
+	p.Set(key("b"), 0, 5<<20)
+	assert.Equal(t, uint64(9<<20), p.Bytes())
+	assert.Empty(t, *evicted)
+
+	// a and b are evicted to make room for 8M
+	p.Set(key("c"), 0, 8<<20)
+	assert.Equal(t, []object.IDHash{key("a"), key("b")}, *evicted)
+	assert.Equal(t, uint64(8<<20), p.Bytes())
+
+	// the entry being set is never evicted by itself
+	p.Set(key("c"), 0, 12<<20)
+	assert.True(t, p.Has(key("c")))
+
+	assert.True(t, p.Remove(key("c")))
+	assert.False(t, p.Remove(key("c")))
+	assert.Equal(t, uint64(0), p.Bytes())
+}
+
+func TestPolicySize(t *testing.T) {
+	p, evicted := newPolicy(t, eviction.Size, eviction.Option{MaxBytes: 100 << 20})
+
+	p.Set(key("small"), 0, 1<<10)
+	p.Set(key("large"), 0, 64<<20)
+	p.Set(key("medium"), 0, 1<<20)
+	p.Get(key("large"))
+
+	// the large object is evicted first though it is accessed more recently
+	p.Set(key("new"), 0, 40<<20)
+	assert.Equal(t, []object.IDHash{key("large")}, *evicted)
+}
+
+func TestTopK(t *testing.T) {
+	p, _ := newPolicy(t, eviction.FIFO, eviction.Option{})
+	p.Set(key("a"), 0, 1)
+	p.Set(key("b"), 0, 1)
+	p.Set(key("c"), 0, 1)
+	p.Get(key("c"))
+	p.Get(key("c"))
+	p.Get(key("b"))
+
+	assert.Equal(t, []object.IDHash{key("c"), key("b")}, p.TopK(2))
+	assert.Len(t, p.TopK(10), 3)
+}
+
+func TestNew(t *testing.T) {
+	p, err := eviction.New("", eviction.Option{})
+	assert.NoError(t, err)
+	assert.Equal(t, eviction.DefaultPolicy, p.Name())
+
+	_, err = eviction.New("arc", eviction.Option{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,283 @@
+package eviction
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+
+	"github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+)
+
+type entry struct {
+	Entry
+	freq  uint64
+	seq   uint64  // insertion or last access sequence
+	prio  float64 // lower priority is evicted first, ties are broken by seq
+	index int     // index in heap
+}
+
+// scoreFunc recomputes the priority and sequence of entry, access is false on insertion.
+type scoreFunc func(p *heapPolicy, e *entry, access bool)
+
+// scoreFIFO orders by insertion.
+func scoreFIFO(p *heapPolicy, e *entry, access bool) {
+	if !access {
+		e.seq = p.nextSeq()
+	}
+}
+
+// scoreLRU orders by last access.
+func scoreLRU(p *heapPolicy, e *entry, _ bool) {
+	e.seq = p.nextSeq()
+}
+
+// scoreLFU orders by access frequency, then by last access.
+func scoreLFU(p *heapPolicy, e *entry, _ bool) {
+	e.seq = p.nextSeq()
+	e.prio = float64(e.freq)
+}
+
+// scoreGDSF computes H = L + freq / size(KiB), L is raised to the priority of
+// the last victim so that entries not accessed for long age out.
+func scoreGDSF(p *heapPolicy, e *entry, _ bool) {
+	e.seq = p.nextSeq()
+	kib := float64(e.Size)/1024 + 1
+	e.prio = p.clock + float64(e.freq)/kib
+}
+
+// heapPolicy is a min-heap of entries ordered by priority.
+type heapPolicy struct {
+	mu    sync.Mutex
+	name  string
+	opt   Option
+	score scoreFunc
+	// sizeAware policy rescores the entry when its size changes.
+	sizeAware bool
+	entries   map[object.IDHash]*entry
+	heap      entryHeap
+	bytes     uint64
+	seq       uint64
+	clock     float64
+}
+
+func newHeapPolicy(name string, opt Option, score scoreFunc, sizeAware bool) *heapPolicy {
+	return &heapPolicy{
+		name:      name,
+		opt:       opt,
+		score:     score,
+		sizeAware: sizeAware,
+		entries:   make(map[object.IDHash]*entry),
+	}
+}
+
+func (p *heapPolicy) nextSeq() uint64 {
+	p.seq++
+	return p.seq
+}
+
+// Name implements Policy.
+func (p *heapPolicy) Name() string {
+	return p.name
+}
+
+// Set implements Policy.
+func (p *heapPolicy) Set(key object.IDHash, mark storage.Mark, size uint64) {
+	p.mu.Lock()
+
+	if e, ok := p.entries[key]; ok {
+		p.bytes = p.bytes - e.Size + size
+		e.Mark = mark
+		if e.Size != size {
+			e.Size = size
+			if p.sizeAware {
+				p.score(p, e, false)
+				heap.Fix(&p.heap, e.index)
+			}
+		}
+	} else {
+		e = &entry{Entry: Entry{Key: key, Mark: mark, Size: size}, freq: 1}
+		p.score(p, e, false)
+		p.entries[key] = e
+		heap.Push(&p.heap, e)
+		p.bytes += size
+	}
+
+	evicted := p.evict(key)
+	p.mu.Unlock()
+
+	if len(evicted) > 0 && p.opt.OnEvict != nil {
+		p.opt.OnEvict(evicted)
+	}
+}
+
+// evict pops the victims until the policy is within its capacity,
+// the entry of keep is never evicted. must be called with mu held.
+func (p *heapPolicy) evict(keep object.IDHash) []Entry {
+	var evicted []Entry
+	var kept *entry
+	for p.overflow() && p.heap.Len() > 0 {
+		e := heap.Pop(&p.heap).(*entry)
+		if e.Key == keep {
+			kept = e
+			continue
+		}
+		delete(p.entries, e.Key)
+		p.bytes -= e.Size
+		if e.prio > p.clock {
+			p.clock = e.prio
+		}
+		evicted = append(evicted, e.Entry)
+	}
+	if kept != nil {
+		heap.Push(&p.heap, kept)
+	}
+	return evicted
+}
+
+func (p *heapPolicy) overflow() bool {
+	return (p.opt.MaxObjects > 0 && len(p.entries) > p.opt.MaxObjects) ||
+		(p.opt.MaxBytes > 0 && p.bytes > p.opt.MaxBytes)
+}
+
+// Update implements Policy.
+func (p *heapPolicy) Update(key object.IDHash, mark storage.Mark) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, ok := p.entries[key]
+	if ok {
+		e.Mark = mark
+	}
+	return ok
+}
+
+// Get implements Policy.
+func (p *heapPolicy) Get(key object.IDHash) (storage.Mark, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, ok := p.entries[key]
+	if !ok {
+		return 0, false
+	}
+	e.freq++
+	p.score(p, e, true)
+	heap.Fix(&p.heap, e.index)
+	return e.Mark, true
+}
+
+// Peek implements Policy.
+func (p *heapPolicy) Peek(key object.IDHash) (storage.Mark, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, ok := p.entries[key]
+	if !ok {
+		return 0, false
+	}
+	return e.Mark, true
+}
+
+// Has implements Policy.
+func (p *heapPolicy) Has(key object.IDHash) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, ok := p.entries[key]
+	return ok
+}
+
+// Remove implements Policy.
+func (p *heapPolicy) Remove(key object.IDHash) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e, ok := p.entries[key]
+	if !ok {
+		return false
+	}
+	heap.Remove(&p.heap, e.index)
+	delete(p.entries, key)
+	p.bytes -= e.Size
+	return true
+}
+
+// Len implements Policy.
+func (p *heapPolicy) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.entries)
+}
+
+// Bytes implements Policy.
+func (p *heapPolicy) Bytes() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.bytes
+}
+
+// TopK implements Policy.
+func (p *heapPolicy) TopK(k int) []object.IDHash {
+	p.mu.Lock()
+	// copy the order keys, entries may be mutated after unlock.
+	type ranked struct {
+		key  object.IDHash
+		freq uint64
+		seq  uint64
+	}
+	ranks := make([]ranked, 0, len(p.entries))
+	for _, e := range p.entries {
+		ranks = append(ranks, ranked{key: e.Key, freq: e.freq, seq: e.seq})
+	}
+	p.mu.Unlock()
+
+	sort.Slice(ranks, func(i, j int) bool {
+		if ranks[i].freq != ranks[j].freq {
+			return ranks[i].freq > ranks[j].freq
+		}
+		return ranks[i].seq > ranks[j].seq
+	})
+
+	if k > len(ranks) {
+		k = len(ranks)
+	}
+	keys := make([]object.IDHash, k)
+	for i := 0; i < k; i++ {
+		keys[i] = ranks[i].key
+	}
+	return keys
+}
+
+type entryHeap []*entry
+
+func (h entryHeap) Len() int { return len(h) }
+
+func (h entryHeap) Less(i, j int) bool {
+	if h[i].prio != h[j].prio {
+		return h[i].prio < h[j].prio
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h entryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *entryHeap) Push(x any) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *entryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.index = -1
+	*h = old[:n-1]
+	return e
+}
@@ -38,5 +38,10 @@ func Close() error {
 	mu.Lock()
 	defer mu.Unlock()
 
+	// already closed by Handoff
+	if bypass.Load() {
+		return nil
+	}
+
 	return defaultStorage.Close()
 }
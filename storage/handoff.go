@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/contrib/log"
+)
+
+// 热升级 (SIGUSR2) 交接流程:
+//
+//  1. 旧进程切换到 bypass 模式, 新请求不再访问 bucket, 直接回源;
+//  2. 等待已持有 bucket 的请求处理完成 (或超时);
+//  3. 关闭 indexdb 释放 pebble LOCK, 新进程才能打开同一份索引.
+//
+// 升级失败时通过 Resume 重新挂载 storage 并退出 bypass 模式.
+
+const handoffPollInterval = 50 * time.Millisecond
+
+var (
+	bypass   atomic.Bool
+	inflight atomic.Int64
+)
+
+// Acquire marks the storage in use by the request, the returned release
+// must be called once the request no longer touches any bucket.
+// It returns false in bypass mode, the caller should go to origin directly.
+func Acquire() (release func(), ok bool) {
+	inflight.Add(1)
+	if bypass.Load() {
+		inflight.Add(-1)
+		return nil, false
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { inflight.Add(-1) })
+	}, true
+}
+
+// Bypassed reports whether the storage has been handed off.
+func Bypassed() bool {
+	return bypass.Load()
+}
+
+// Inflight returns the number of requests still holding the storage.
+func Inflight() int64 {
+	return inflight.Load()
+}
+
+// Handoff switches to bypass mode, waits for in-flight requests to drain
+// and closes the current storage. When ctx is done before drained, the
+// storage is closed anyway so that the new process is not blocked.
+func Handoff(ctx context.Context) error {
+	// already handed off, e.g. upgrade retried after the storage failed to reopen.
+	if bypass.Swap(true) {
+		return nil
+	}
+
+	ticker := time.NewTicker(handoffPollInterval)
+	defer ticker.Stop()
+
+wait:
+	for inflight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			log.Warnf("storage handoff: %d requests still in-flight: %v", inflight.Load(), ctx.Err())
+			break wait
+		case <-ticker.C:
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	return defaultStorage.Close()
+}
+
+// Resume mounts the reopened storage and leaves bypass mode, it is used
+// when the upgrade failed after Handoff.
+func Resume(s storage.Storage) {
+	SetDefault(s)
+	bypass.Store(false)
+}
@@ -2,6 +2,7 @@ package nutsdb
 
 import (
 	"context"
+	"time"
 
 	"github.com/nutsdb/nutsdb"
 	"github.com/omalloc/tavern/api/defined/v1/storage"
@@ -88,8 +89,17 @@ func (n *NutsDB) Exist(ctx context.Context, key []byte) bool {
 }
 
 // Expired implements [storage.IndexDB].
+//
+// nutsdb 没有过期索引, 全量扫描并回调 ExpiresAt <= now 的对象 (不按 ExpiresAt 排序);
+// f 返回 false 时停止扫描.
 func (n *NutsDB) Expired(ctx context.Context, f storage.IterateFunc) error {
-	return nil
+	now := time.Now().Unix()
+	return n.Scan(ctx, nil, func(key []byte, meta *object.Metadata) bool {
+		if meta.ExpiresAt <= 0 || meta.ExpiresAt > now {
+			return true
+		}
+		return f(key, meta)
+	})
 }
 
 // GC implements [storage.IndexDB].
@@ -167,6 +177,43 @@ func (n *NutsDB) Iterate(ctx context.Context, prefix []byte, f storage.IterateFu
 	return tx.Commit()
 }
 
+// Scan implements [storage.IndexDB].
+func (n *NutsDB) Scan(ctx context.Context, from []byte, f storage.IterateFunc) error {
+	return n.db.View(func(tx *nutsdb.Tx) error {
+		iterator := nutsdb.NewIterator(tx, n.bucket, nutsdb.IteratorOptions{Reverse: false})
+		if iterator == nil {
+			return nil
+		}
+		defer iterator.Release()
+
+		valid := iterator.Rewind()
+		if len(from) > 0 {
+			valid = iterator.Seek(from)
+		}
+
+		for ; valid; valid = iterator.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			buf, err := iterator.Value()
+			if err != nil {
+				continue
+			}
+
+			meta := &object.Metadata{}
+			if err = n.codec.Unmarshal(buf, meta); err != nil {
+				continue
+			}
+
+			if !f(iterator.Key(), meta) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
 // Set implements [storage.IndexDB].
 func (n *NutsDB) Set(ctx context.Context, key []byte, val *object.Metadata) error {
 	return n.db.Update(func(tx *nutsdb.Tx) error {
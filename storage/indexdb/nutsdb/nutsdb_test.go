@@ -0,0 +1,44 @@
+package nutsdb_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+	"github.com/omalloc/tavern/pkg/encoding/cobr"
+	"github.com/omalloc/tavern/storage/indexdb"
+	"github.com/omalloc/tavern/storage/indexdb/nutsdb"
+)
+
+func TestExpired(t *testing.T) {
+	opt := indexdb.NewOption(t.TempDir(),
+		indexdb.WithType("nutsdb"),
+		indexdb.WithCodec(&cobr.CborCodec{}),
+	)
+	db, err := nutsdb.NewNutsDB(opt.DBPath(), opt)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+	for i, ttl := range []time.Duration{-time.Hour, time.Hour, -time.Minute, 0} {
+		id := object.NewID(fmt.Sprintf("http://www.example.com/%d", i))
+		md := &object.Metadata{ID: id, Code: 200}
+		if ttl != 0 {
+			md.ExpiresAt = now.Add(ttl).Unix()
+		}
+		assert.NoError(t, db.Set(ctx, id.Bytes(), md))
+	}
+
+	// never expires & not yet expired objects are skipped.
+	keys := make([]string, 0)
+	assert.NoError(t, db.Expired(ctx, func(key []byte, val *object.Metadata) bool {
+		keys = append(keys, val.ID.Key())
+		return true
+	}))
+	assert.ElementsMatch(t, []string{"http://www.example.com/0", "http://www.example.com/2"}, keys)
+}
@@ -1,9 +1,12 @@
 package pebble
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cockroachdb/pebble/v2"
@@ -18,20 +21,81 @@ import (
 
 var _ storage.IndexDB = (*PebbleDB)(nil)
 
+// expiryPrefix 过期索引 keyspace 前缀, 与对象 key 共存于同一个 pebble 实例.
+//
+// layout: expiryPrefix | big-endian uint64(ExpiresAt) | object key
+//
+// 对象 key 为 20 字节的 sha1, 索引 key 按 ExpiresAt 升序排列,
+// Expired 只需扫描 [expiryPrefix, expiryPrefix|now] 区间.
+var expiryPrefix = []byte("\xff\xfe.expiry/")
+
+// expiryKey returns the expiry index key of the object key.
+func expiryKey(expiresAt int64, key []byte) []byte {
+	buf := make([]byte, len(expiryPrefix)+8+len(key))
+	n := copy(buf, expiryPrefix)
+	binary.BigEndian.PutUint64(buf[n:], uint64(expiresAt))
+	copy(buf[n+8:], key)
+	return buf
+}
+
+// expiryBackfilled 标记升级前存储的对象已补建过期索引, 排在 expiryPrefix 之前, 不在 Expired 的扫描区间内.
+var expiryBackfilled = []byte("\xff\xfe.expiry")
+
+// expiryBackfillBatch 补建过期索引时单个 batch 的最大写入数
+const expiryBackfillBatch = 10_000
+
+// isExpiryKey reports whether the key belongs to the expiry keyspace.
+func isExpiryKey(key []byte) bool {
+	return len(key) > len(expiryPrefix)+8 && bytes.HasPrefix(key, expiryPrefix)
+}
+
+// isInternalKey reports whether the key is the expiry index or its backfill marker, not an object.
+func isInternalKey(key []byte) bool {
+	return isExpiryKey(key) || bytes.Equal(key, expiryBackfilled)
+}
+
 type PebbleDB struct {
 	codec         encoding.Codec
 	db            *pebble.DB
 	writeMode     *pebble.WriteOptions
 	skipErrRecord bool
 	closed        sync.Once
+
+	// pebble 对已关闭的 DB 直接 panic, 热升级交接时后台 goroutine 可能仍在访问 bucket,
+	// Close 之后的操作统一返回 ErrIndexDBClosed.
+	closing atomic.Bool
+	refs    atomic.Int64
 }
 
 func init() {
 	indexdb.Register("pebble", New)
 }
 
+// acquire holds a reference of db, it never blocks and returns false after Close.
+func (p *PebbleDB) acquire() bool {
+	p.refs.Add(1)
+	if p.closing.Load() {
+		p.refs.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (p *PebbleDB) release() {
+	p.refs.Add(-1)
+}
+
 // Get implements storage.IndexDB.
 func (p *PebbleDB) Get(ctx context.Context, key []byte) (*object.Metadata, error) {
+	if !p.acquire() {
+		return nil, storage.ErrIndexDBClosed
+	}
+	defer p.release()
+
+	return p.get(key)
+}
+
+func (p *PebbleDB) get(key []byte) (*object.Metadata, error) {
 	buf, closer, err := p.db.Get(key)
 	if err != nil {
 		if errors.Is(err, pebble.ErrNotFound) {
@@ -48,16 +112,46 @@ func (p *PebbleDB) Get(ctx context.Context, key []byte) (*object.Metadata, error
 
 // Set implements storage.IndexDB.
 func (p *PebbleDB) Set(ctx context.Context, key []byte, val *object.Metadata) error {
+	if !p.acquire() {
+		return storage.ErrIndexDBClosed
+	}
+	defer p.release()
+
 	buf, err := p.codec.Marshal(val)
 	if err != nil {
 		return err
 	}
 
-	return p.db.Set(key, buf, p.writeMode)
+	batch := p.db.NewBatch()
+	defer batch.Close()
+
+	// 清理旧的过期索引
+	if old, err1 := p.get(key); err1 == nil && old.ExpiresAt > 0 && old.ExpiresAt != val.ExpiresAt {
+		if err = batch.Delete(expiryKey(old.ExpiresAt, key), nil); err != nil {
+			return err
+		}
+	}
+
+	if val.ExpiresAt > 0 {
+		if err = batch.Set(expiryKey(val.ExpiresAt, key), nil, nil); err != nil {
+			return err
+		}
+	}
+
+	if err = batch.Set(key, buf, nil); err != nil {
+		return err
+	}
+
+	return batch.Commit(p.writeMode)
 }
 
 // Iterate implements storage.IndexDB.
 func (p *PebbleDB) Iterate(ctx context.Context, prefix []byte, f storage.IterateFunc) error {
+	if !p.acquire() {
+		return storage.ErrIndexDBClosed
+	}
+	defer p.release()
+
 	iter, err := p.db.NewIter(&pebble.IterOptions{})
 	if err != nil {
 		return err
@@ -66,6 +160,13 @@ func (p *PebbleDB) Iterate(ctx context.Context, prefix []byte, f storage.Iterate
 
 	if p.skipErrRecord {
 		for iter.First(); iter.Valid(); iter.Next() {
+			if p.closing.Load() {
+				return storage.ErrIndexDBClosed
+			}
+			if isInternalKey(iter.Key()) {
+				continue
+			}
+
 			buf, err1 := iter.ValueAndErr()
 			if err1 != nil {
 				continue
@@ -81,9 +182,16 @@ func (p *PebbleDB) Iterate(ctx context.Context, prefix []byte, f storage.Iterate
 	}
 
 	for iter.First(); iter.Valid(); iter.Next() {
+		if p.closing.Load() {
+			return storage.ErrIndexDBClosed
+		}
+		if isInternalKey(iter.Key()) {
+			continue
+		}
+
 		buf, err1 := iter.ValueAndErr()
 		if err1 != nil {
-			return err
+			return err1
 		}
 
 		meta := &object.Metadata{}
@@ -96,26 +204,164 @@ func (p *PebbleDB) Iterate(ctx context.Context, prefix []byte, f storage.Iterate
 	return nil
 }
 
+// Scan implements storage.IndexDB.
+func (p *PebbleDB) Scan(ctx context.Context, from []byte, f storage.IterateFunc) error {
+	if !p.acquire() {
+		return storage.ErrIndexDBClosed
+	}
+	defer p.release()
+
+	// the sha1 object keys may sort after the expiry keyspace, e.g. `ff ff ...`
+	iter, err := p.db.NewIter(&pebble.IterOptions{})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for iter.SeekGE(from); iter.Valid(); iter.Next() {
+		if p.closing.Load() {
+			return storage.ErrIndexDBClosed
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if isInternalKey(iter.Key()) {
+			continue
+		}
+
+		buf, err1 := iter.ValueAndErr()
+		if err1 != nil {
+			if p.skipErrRecord {
+				continue
+			}
+			return err1
+		}
+
+		meta := &object.Metadata{}
+		if err = p.codec.Unmarshal(buf, meta); err != nil {
+			if p.skipErrRecord {
+				continue
+			}
+			return err
+		}
+
+		if !f(iter.Key(), meta) {
+			return nil
+		}
+	}
+	return iter.Error()
+}
+
 // Delete implements storage.IndexDB.
 func (p *PebbleDB) Delete(ctx context.Context, key []byte) error {
-	return p.db.Delete(key, p.writeMode)
+	if !p.acquire() {
+		return storage.ErrIndexDBClosed
+	}
+	defer p.release()
+
+	old, err := p.get(key)
+	if err != nil || old.ExpiresAt <= 0 {
+		return p.db.Delete(key, p.writeMode)
+	}
+
+	batch := p.db.NewBatch()
+	defer batch.Close()
+
+	if err = batch.Delete(expiryKey(old.ExpiresAt, key), nil); err != nil {
+		return err
+	}
+	if err = batch.Delete(key, nil); err != nil {
+		return err
+	}
+	return batch.Commit(p.writeMode)
 }
 
 // Exist implements storage.IndexDB.
 func (p *PebbleDB) Exist(ctx context.Context, key []byte) bool {
-	_, _, err := p.db.Get(key)
-	return err == nil
+	if !p.acquire() {
+		return false
+	}
+	defer p.release()
+
+	_, closer, err := p.db.Get(key)
+	if err != nil {
+		return false
+	}
+	_ = closer.Close()
+	return true
 }
 
 // Expired implements storage.IndexDB.
+//
+// 按 ExpiresAt 升序扫描过期索引, 仅回调 ExpiresAt <= now 的对象;
+// f 返回 false 时停止扫描.
 func (p *PebbleDB) Expired(ctx context.Context, f storage.IterateFunc) error {
-	panic("unimplemented")
+	if !p.acquire() {
+		return storage.ErrIndexDBClosed
+	}
+	defer p.release()
+
+	iter, err := p.db.NewIter(&pebble.IterOptions{
+		LowerBound: expiryPrefix,
+		UpperBound: expiryKey(time.Now().Unix()+1, nil),
+	})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for iter.First(); iter.Valid(); iter.Next() {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if p.closing.Load() {
+			return storage.ErrIndexDBClosed
+		}
+
+		ikey := iter.Key()
+		if !isExpiryKey(ikey) {
+			continue
+		}
+
+		expiresAt := int64(binary.BigEndian.Uint64(ikey[len(expiryPrefix):]))
+		key := bytes.Clone(ikey[len(expiryPrefix)+8:])
+
+		meta, err1 := p.get(key)
+		if err1 != nil {
+			// 残留的索引(对象已被删除), 直接清理
+			if errors.Is(err1, storage.ErrKeyNotFound) {
+				_ = p.db.Delete(ikey, pebble.NoSync)
+				continue
+			}
+			if p.skipErrRecord {
+				continue
+			}
+			return err1
+		}
+
+		// 索引已过时, 以元数据中的 ExpiresAt 为准
+		if meta.ExpiresAt != expiresAt {
+			_ = p.db.Delete(ikey, pebble.NoSync)
+			continue
+		}
+
+		if !f(key, meta) {
+			return nil
+		}
+	}
+	return nil
 }
 
 // Close implements storage.IndexDB.
 func (p *PebbleDB) Close() error {
 	var err error
 	p.closed.Do(func() {
+		// wait for the in-flight operations, the iterations stop on closing.
+		p.closing.Store(true)
+		for p.refs.Load() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+
 		_ = p.db.Flush()
 		// force flush data to disk
 		err = p.db.Close()
@@ -179,11 +425,83 @@ func New(path string, option storage.Option) (storage.IndexDB, error) {
 		writeMode = pebble.NoSync
 	}
 
-	return &PebbleDB{
+	p := &PebbleDB{
 		codec:         option.Codec(),
 		db:            pdb,
 		writeMode:     writeMode, // 是否异步写操作
 		skipErrRecord: true,
 		closed:        sync.Once{},
-	}, nil
+	}
+
+	if err = p.backfillExpiry(); err != nil {
+		_ = pdb.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// backfillExpiry 为升级前存储 (没有过期索引) 的对象补建过期索引, 完成后写入标记, 只执行一次.
+func (p *PebbleDB) backfillExpiry() error {
+	_, closer, err := p.db.Get(expiryBackfilled)
+	if err == nil {
+		return closer.Close()
+	}
+	if !errors.Is(err, pebble.ErrNotFound) {
+		return err
+	}
+
+	iter, err := p.db.NewIter(&pebble.IterOptions{})
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	batch := p.db.NewBatch()
+	defer func() {
+		_ = batch.Close()
+	}()
+
+	count := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		if isInternalKey(iter.Key()) {
+			continue
+		}
+
+		buf, err1 := iter.ValueAndErr()
+		if err1 != nil {
+			continue
+		}
+		meta := &object.Metadata{}
+		if err1 = p.codec.Unmarshal(buf, meta); err1 != nil || meta.ExpiresAt <= 0 {
+			continue
+		}
+
+		if err = batch.Set(expiryKey(meta.ExpiresAt, iter.Key()), nil, nil); err != nil {
+			return err
+		}
+		count++
+
+		if batch.Count() >= expiryBackfillBatch {
+			if err = batch.Commit(pebble.NoSync); err != nil {
+				return err
+			}
+			_ = batch.Close()
+			batch = p.db.NewBatch()
+		}
+	}
+	if err = iter.Error(); err != nil {
+		return err
+	}
+
+	if err = batch.Set(expiryBackfilled, nil, nil); err != nil {
+		return err
+	}
+	if err = batch.Commit(pebble.Sync); err != nil {
+		return err
+	}
+
+	if count > 0 {
+		log.Infof("pebble backfilled the expiry index of %d objects", count)
+	}
+	return nil
 }
@@ -0,0 +1,202 @@
+package pebble_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	cpebble "github.com/cockroachdb/pebble/v2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+	"github.com/omalloc/tavern/pkg/encoding/cobr"
+	"github.com/omalloc/tavern/storage/indexdb"
+	"github.com/omalloc/tavern/storage/indexdb/pebble"
+)
+
+func openTestDB(t *testing.T) storage.IndexDB {
+	t.Helper()
+
+	opt := indexdb.NewOption(t.TempDir(),
+		indexdb.WithType("pebble"),
+		indexdb.WithCodec(&cobr.CborCodec{}),
+	)
+	db, err := pebble.New(opt.DBPath(), opt)
+	assert.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	return db
+}
+
+func collectExpired(t *testing.T, db storage.IndexDB) []string {
+	t.Helper()
+
+	keys := make([]string, 0)
+	err := db.Expired(context.Background(), func(key []byte, val *object.Metadata) bool {
+		keys = append(keys, val.ID.Key())
+		return true
+	})
+	assert.NoError(t, err)
+	return keys
+}
+
+func TestExpired(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	for i, ttl := range []time.Duration{-time.Hour, time.Hour, -time.Minute, 0} {
+		id := object.NewID(fmt.Sprintf("http://www.example.com/%d", i))
+		md := &object.Metadata{ID: id, Code: 200}
+		if ttl != 0 {
+			md.ExpiresAt = now.Add(ttl).Unix()
+		}
+		assert.NoError(t, db.Set(ctx, id.Bytes(), md))
+	}
+
+	// ordered by ExpiresAt, never expires & not yet expired objects are skipped.
+	assert.Equal(t, []string{"http://www.example.com/0", "http://www.example.com/2"}, collectExpired(t, db))
+
+	// Iterate must not see the expiry keyspace.
+	count := 0
+	assert.NoError(t, db.Iterate(ctx, nil, func(key []byte, val *object.Metadata) bool {
+		count++
+		return true
+	}))
+	assert.Equal(t, 4, count)
+}
+
+func TestExpiredAfterUpdate(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	id := object.NewID("http://www.example.com/update")
+	md := &object.Metadata{ID: id, Code: 200, ExpiresAt: time.Now().Add(-time.Hour).Unix()}
+	assert.NoError(t, db.Set(ctx, id.Bytes(), md))
+	assert.Len(t, collectExpired(t, db), 1)
+
+	// revalidated, moved to the future.
+	md.ExpiresAt = time.Now().Add(time.Hour).Unix()
+	assert.NoError(t, db.Set(ctx, id.Bytes(), md))
+	assert.Empty(t, collectExpired(t, db))
+
+	// expired again, then deleted.
+	md.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	assert.NoError(t, db.Set(ctx, id.Bytes(), md))
+	assert.Len(t, collectExpired(t, db), 1)
+
+	assert.NoError(t, db.Delete(ctx, id.Bytes()))
+	assert.Empty(t, collectExpired(t, db))
+}
+
+func TestExpiredBackfill(t *testing.T) {
+	dir := t.TempDir()
+	opt := indexdb.NewOption(dir,
+		indexdb.WithType("pebble"),
+		indexdb.WithCodec(&cobr.CborCodec{}),
+	)
+
+	// the objects stored before the expiry index exists
+	raw, err := cpebble.Open(opt.DBPath(), &cpebble.Options{})
+	assert.NoError(t, err)
+	for i, ttl := range []time.Duration{-time.Hour, time.Hour} {
+		id := object.NewID(fmt.Sprintf("http://www.example.com/legacy/%d", i))
+		buf, err := opt.Codec().Marshal(&object.Metadata{ID: id, Code: 200, ExpiresAt: time.Now().Add(ttl).Unix()})
+		assert.NoError(t, err)
+		assert.NoError(t, raw.Set(id.Bytes(), buf, cpebble.Sync))
+	}
+	assert.NoError(t, raw.Close())
+
+	for range 2 {
+		db, err := pebble.New(opt.DBPath(), opt)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"http://www.example.com/legacy/0"}, collectExpired(t, db))
+
+		// the backfill marker is not an object
+		count := 0
+		assert.NoError(t, db.Iterate(context.Background(), nil, func(key []byte, val *object.Metadata) bool {
+			count++
+			return true
+		}))
+		assert.Equal(t, 2, count)
+		assert.NoError(t, db.Close())
+	}
+}
+
+func TestScan(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	keys := make([][]byte, 0, 5)
+	for i := 0; i < 5; i++ {
+		id := object.NewID(fmt.Sprintf("http://www.example.com/scan/%d", i))
+		// the expiry keys must not be scanned
+		md := &object.Metadata{ID: id, Code: 200, ExpiresAt: time.Now().Add(time.Hour).Unix()}
+		assert.NoError(t, db.Set(ctx, id.Bytes(), md))
+		keys = append(keys, id.Bytes())
+	}
+	slices.SortFunc(keys, bytes.Compare)
+
+	scan := func(from []byte, limit int) [][]byte {
+		got := make([][]byte, 0)
+		assert.NoError(t, db.Scan(ctx, from, func(key []byte, val *object.Metadata) bool {
+			assert.Equal(t, key, val.ID.Bytes())
+			got = append(got, bytes.Clone(key))
+			return len(got) < limit
+		}))
+		return got
+	}
+
+	assert.Equal(t, keys, scan(nil, 10))
+	assert.Equal(t, keys[:2], scan(nil, 2))
+	assert.Equal(t, keys[2:], scan(keys[2], 10))
+	assert.Empty(t, scan([]byte{0xff, 0xff}, 10))
+}
+
+func TestScanHighKeys(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	// the sha1 keys sorting around the expiry keyspace `ff fe .expiry/`
+	keys := [][]byte{
+		append([]byte{0xff, 0xfe, 'z'}, bytes.Repeat([]byte{0x01}, 17)...),
+		append([]byte{0xff, 0xff}, bytes.Repeat([]byte{0x01}, 18)...),
+	}
+	for i, key := range keys {
+		id := object.NewID(fmt.Sprintf("http://www.example.com/high/%d", i))
+		md := &object.Metadata{ID: id, Code: 200, ExpiresAt: time.Now().Add(time.Hour).Unix()}
+		assert.NoError(t, db.Set(ctx, key, md))
+	}
+
+	got := make([][]byte, 0)
+	assert.NoError(t, db.Scan(ctx, nil, func(key []byte, val *object.Metadata) bool {
+		got = append(got, bytes.Clone(key))
+		return true
+	}))
+	assert.Equal(t, keys, got)
+}
+
+func TestClosed(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	id := object.NewID("http://www.example.com/closed")
+	assert.NoError(t, db.Set(ctx, id.Bytes(), &object.Metadata{ID: id, Code: 200}))
+	assert.True(t, db.Exist(ctx, id.Bytes()))
+	assert.NoError(t, db.Close())
+
+	// no panic after close
+	_, err := db.Get(ctx, id.Bytes())
+	assert.ErrorIs(t, err, storage.ErrIndexDBClosed)
+	assert.ErrorIs(t, db.Set(ctx, id.Bytes(), &object.Metadata{ID: id}), storage.ErrIndexDBClosed)
+	assert.ErrorIs(t, db.Delete(ctx, id.Bytes()), storage.ErrIndexDBClosed)
+	assert.False(t, db.Exist(ctx, id.Bytes()))
+	assert.ErrorIs(t, db.Iterate(ctx, nil, func([]byte, *object.Metadata) bool { return true }), storage.ErrIndexDBClosed)
+	assert.ErrorIs(t, db.Scan(ctx, nil, func([]byte, *object.Metadata) bool { return true }), storage.ErrIndexDBClosed)
+}
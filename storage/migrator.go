@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -34,6 +35,7 @@ type migratorStorage struct {
 	hotBucket    []storage.Bucket
 	warmBucket   []storage.Bucket
 	coldBucket   []storage.Bucket
+	stop         chan struct{}
 }
 
 func NewMigrator(config *conf.Storage, logger log.Logger) (storage.Migrator, error) {
@@ -53,21 +55,39 @@ func NewMigrator(config *conf.Storage, logger log.Logger) (storage.Migrator, err
 		hotBucket:    make([]storage.Bucket, 0, len(config.Buckets)),
 		warmBucket:   make([]storage.Bucket, 0, len(config.Buckets)),
 		coldBucket:   make([]storage.Bucket, 0, len(config.Buckets)),
+		stop:         make(chan struct{}),
+	}
+
+	// replace memkv with storekv before buckets are created,
+	// buckets write the inverted index into the same sharedkv.
+	if config.DirAware != nil && config.DirAware.Enabled && config.DirAware.StorePath != "" {
+		_ = os.MkdirAll(config.DirAware.StorePath, 0755)
+		kv, err := sharedkv.OpenStoreSharedKV(config.DirAware.StorePath)
+		if err != nil {
+			return nil, err
+		}
+		m.sharedkv = kv
 	}
 
 	if err := m.reinit(config); err != nil {
+		// release the indexdb of buckets already opened, so that the caller can retry.
+		_ = m.Close()
 		return nil, err
 	}
 
+	// eject bad buckets from hash ring of each layer
+	watchers := []*bucketWatcher{newBucketWatcher(storage.TypeWarm, m.warmBucket, m.warmSelector)}
+	if m.hotSelector != nil {
+		watchers = append(watchers, newBucketWatcher(storage.TypeHot, m.hotBucket, m.hotSelector))
+	}
+	if m.coldSelector != nil {
+		watchers = append(watchers, newBucketWatcher(storage.TypeCold, m.coldBucket, m.coldSelector))
+	}
+	go watchBuckets(m.stop, watchers...)
+
 	// diraware adapter
 	// 关闭可以提升性能，但是目录推送只能使用硬删除模式，无法使用过期标记
 	if config.DirAware != nil && config.DirAware.Enabled {
-		if config.DirAware.StorePath != "" {
-			_ = os.MkdirAll(config.DirAware.StorePath, 0755)
-			// replace memkv with storekv
-			m.sharedkv = sharedkv.NewStoreSharedKV(config.DirAware.StorePath)
-		}
-
 		// sharedkv used no-mem typ.
 		// return diraware.New(m, diraware.NewChecker(m.sharedkv,
 		// 	diraware.WithAutoClear(config.DirAware.AutoClear),
@@ -92,6 +112,7 @@ func (m *migratorStorage) reinit(config *conf.Storage) error {
 		Driver:          config.Driver,
 		DBType:          config.DBType,
 		DBPath:          config.DBPath,
+		TagHeader:       config.TagHeader,
 		Migration: &storage.MigrationConfig{
 			Enabled: config.Migration.Enabled,
 			Promote: storage.PromoteConfig{
@@ -132,9 +153,12 @@ func (m *migratorStorage) reinit(config *conf.Storage) error {
 	// load purge queue
 
 	// storage layer init.
+	var err error
 	// hot
 	if len(m.hotBucket) > 0 {
-		m.hotSelector = selector.New(m.hotBucket, config.SelectionPolicy)
+		if m.hotSelector, err = selector.New(m.hotBucket, config.SelectionPolicy); err != nil {
+			return err
+		}
 	} else {
 		m.log.Infof("no hot bucket configured")
 	}
@@ -147,11 +171,15 @@ func (m *migratorStorage) reinit(config *conf.Storage) error {
 			m.warmBucket = append(m.warmBucket, m.memoryBucket)
 		}
 	}
-	m.warmSelector = selector.New(m.warmBucket, config.SelectionPolicy)
+	if m.warmSelector, err = selector.New(m.warmBucket, config.SelectionPolicy); err != nil {
+		return err
+	}
 
 	// cold
 	if len(m.coldBucket) > 0 {
-		m.coldSelector = selector.New(m.coldBucket, config.SelectionPolicy)
+		if m.coldSelector, err = selector.New(m.coldBucket, config.SelectionPolicy); err != nil {
+			return err
+		}
 	} else {
 		m.log.Infof("no cold bucket configured")
 	}
@@ -167,6 +195,11 @@ func (m *migratorStorage) reinit(config *conf.Storage) error {
 
 // Select implements [storage.Migrator].
 func (m *migratorStorage) Select(ctx context.Context, id *object.ID) storage.Bucket {
+	// objects forced into memory (X-FS-Mem) are not on the hash ring
+	if m.memoryBucket != nil && m.memoryBucket.Exist(ctx, id.Bytes()) {
+		return m.memoryBucket
+	}
+
 	// find bucket: Hot → Warm → Cold
 	return m.chainSelector(ctx, id,
 		m.hotSelector,
@@ -253,15 +286,23 @@ func (m *migratorStorage) chainSelector(ctx context.Context, id *object.ID, sele
 
 // Buckets implements [storage.Migrator].
 func (m *migratorStorage) Buckets() []storage.Bucket {
-	buckets := make([]storage.Bucket, 0, len(m.warmBucket)+len(m.hotBucket)+len(m.coldBucket))
+	buckets := make([]storage.Bucket, 0, len(m.warmBucket)+len(m.hotBucket)+len(m.coldBucket)+1)
 	buckets = append(buckets, m.warmBucket...)
 	buckets = append(buckets, m.hotBucket...)
 	buckets = append(buckets, m.coldBucket...)
+	if m.memoryBucket != nil && !slices.Contains(m.warmBucket, m.memoryBucket) {
+		buckets = append(buckets, m.memoryBucket)
+	}
 	return buckets
 }
 
 // PURGE implements [storage.Migrator].
 func (m *migratorStorage) PURGE(storeUrl string, typ storage.PurgeControl) error {
+	// Cache tag purge, storeUrl is the tag
+	if typ.Tag {
+		return purgeTag(m.sharedkv, m.Buckets(), storeUrl, typ)
+	}
+
 	// Directory prefix purge
 	if typ.Dir {
 		// For mark-expired on dir, skip sharedkv hits and fallback to full scan below.
@@ -327,29 +368,7 @@ func (m *migratorStorage) PURGE(storeUrl string, typ storage.PurgeControl) error
 	}
 
 	// Single object purge
-	cacheKey := object.NewID(storeUrl)
-
-	bucket := m.Select(context.Background(), cacheKey)
-	if bucket == nil {
-		return fmt.Errorf("bucket not found")
-	}
-
-	// hard delete cache file mode.
-	if typ.Hard {
-		return bucket.Discard(context.Background(), cacheKey)
-	}
-
-	// MarkExpired to revalidate.
-	// soft delete cache file mode.
-	md, err := bucket.Lookup(context.Background(), cacheKey)
-	if err != nil {
-		return err
-	}
-
-	// set expire time to past time. and then store it back.
-	md.ExpiresAt = time.Now().Add(-1).Unix()
-	// TODO: we should acquire a globalResourceLock before updating.
-	return bucket.Store(context.Background(), md)
+	return purgeObject(m, storeUrl, typ)
 }
 
 // Rebuild implements [storage.Migrator].
@@ -364,6 +383,13 @@ func (m *migratorStorage) SharedKV() storage.SharedKV {
 
 // Close implements [storage.Migrator].
 func (m *migratorStorage) Close() error {
+	m.mu.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.stop)
+	}
+	m.mu.Unlock()
+
 	var errs []error
 	// close all buckets
 	for _, bucket := range m.warmBucket {
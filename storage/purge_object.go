@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+	"github.com/omalloc/tavern/pkg/cachekey"
+)
+
+// objectHolder selects the bucket of the object and lists all the buckets.
+type objectHolder interface {
+	storage.Selector
+	Buckets() []storage.Bucket
+}
+
+// purgeObject purges the single object of storeUrl, the object is looked up by the
+// cache keys the caching middlewares build for it (`cache_key` rules).
+func purgeObject(s objectHolder, storeUrl string, typ storage.PurgeControl) error {
+	var err error
+	purged := false
+	for _, key := range cachekey.Keys(storeUrl) {
+		if err1 := purgeID(s, object.NewID(key), typ); err1 != nil {
+			err = err1
+			continue
+		}
+		purged = true
+	}
+
+	if purged {
+		return nil
+	}
+	return err
+}
+
+// purgeID purges the object in the selected bucket and every other healthy bucket holding it,
+// the selectors not keyed by object ID (roundrobin / freespace) may leave copies in several
+// buckets, e.g. two concurrent misses or a bucket recovered from bad; a copy left behind
+// would be served again after the PURGE.
+func purgeID(s objectHolder, cacheKey *object.ID, typ storage.PurgeControl) error {
+	ctx := context.Background()
+	selected := s.Select(ctx, cacheKey)
+	if selected == nil {
+		return fmt.Errorf("bucket not found")
+	}
+
+	buckets := []storage.Bucket{selected}
+	for _, b := range s.Buckets() {
+		if b != selected && !b.HasBad() && b.Exist(ctx, cacheKey.Bytes()) {
+			buckets = append(buckets, b)
+		}
+	}
+
+	var err error
+	purged := false
+	for _, bucket := range buckets {
+		if err1 := purgeBucket(bucket, cacheKey, typ); err1 != nil {
+			err = err1
+			continue
+		}
+		purged = true
+	}
+
+	if purged {
+		return nil
+	}
+	return err
+}
+
+func purgeBucket(bucket storage.Bucket, cacheKey *object.ID, typ storage.PurgeControl) error {
+	// hard delete cache file mode.
+	if typ.Hard {
+		return bucket.Discard(context.Background(), cacheKey)
+	}
+
+	// MarkExpired to revalidate.
+	// soft delete cache file mode.
+	md, err := bucket.Lookup(context.Background(), cacheKey)
+	if err != nil {
+		return err
+	}
+
+	// set expire time to past time. and then store it back.
+	md.ExpiresAt = time.Now().Add(-1).Unix()
+	// TODO: we should acquire a globalResourceLock before updating.
+	return bucket.Store(context.Background(), md)
+}
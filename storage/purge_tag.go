@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/storage/tagindex"
+)
+
+// purgeTag purges all objects carrying the tag via SharedKV tag index.
+// key schema: tag/<bucketID>/<tag>/<object-hash>
+// value: json encoded object.ID
+func purgeTag(kv storage.SharedKV, buckets []storage.Bucket, tag string, typ storage.PurgeControl) error {
+	ctx := context.Background()
+	processed := 0
+
+	for _, b := range buckets {
+		_ = kv.IteratePrefix(ctx, tagindex.Prefix(b.ID(), tag), func(key, val []byte) error {
+			id, err := tagindex.Decode(val)
+			if err != nil {
+				// skip invalid record
+				_ = kv.Delete(ctx, key)
+				return nil
+			}
+
+			if typ.Hard {
+				// discard removes the tag index itself.
+				if err := b.Discard(ctx, id); err == nil {
+					processed++
+				}
+				_ = kv.Delete(ctx, key)
+				return nil
+			}
+
+			// MarkExpired to revalidate, the index is kept until the object is discarded.
+			md, err := b.Lookup(ctx, id)
+			if err != nil || md == nil {
+				_ = kv.Delete(ctx, key)
+				return nil
+			}
+			md.ExpiresAt = time.Now().Add(-1).Unix()
+			if err := b.Store(ctx, md); err == nil {
+				processed++
+			}
+			return nil
+		})
+	}
+
+	if processed == 0 {
+		return storage.ErrKeyNotFound
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Package freespace picks the bucket of new objects randomly weighted by its free space.
+//
+// The object is not keyed to a bucket, cached objects are located by asking
+// every bucket before a new one is picked.
+package freespace
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+
+	"github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+	"github.com/omalloc/tavern/storage/selector/internal/pick"
+)
+
+const Name = "freespace"
+
+var _ storage.Selector = (*FreeSpace)(nil)
+
+// Availabler is implemented by the buckets reporting their free bytes.
+type Availabler interface {
+	Available() uint64
+}
+
+type FreeSpace struct {
+	mu      sync.RWMutex
+	buckets []storage.Bucket
+}
+
+func New(buckets []storage.Bucket) (storage.Selector, error) {
+	f := &FreeSpace{}
+	_ = f.Rebuild(context.Background(), buckets)
+	return f, nil
+}
+
+// Select implements storage.Selector.
+func (f *FreeSpace) Select(ctx context.Context, id *object.ID) storage.Bucket {
+	f.mu.RLock()
+	buckets := f.buckets
+	f.mu.RUnlock()
+
+	if b := pick.Existing(ctx, buckets, id); b != nil {
+		return b
+	}
+
+	usable := make([]storage.Bucket, 0, len(buckets))
+	for _, b := range buckets {
+		if pick.Usable(b) {
+			usable = append(usable, b)
+		}
+	}
+	if len(usable) == 0 {
+		return nil
+	}
+
+	weights, total := weightsOf(usable)
+	n := rand.Uint64N(total)
+	for i, w := range weights {
+		if n < w {
+			return usable[i]
+		}
+		n -= w
+	}
+	return usable[len(usable)-1]
+}
+
+// weightsOf returns the free bytes scaled by the configured weight,
+// falls back to the configured weight when any bucket does not report free space.
+func weightsOf(buckets []storage.Bucket) ([]uint64, uint64) {
+	weights := make([]uint64, len(buckets))
+	var total uint64
+	for i, b := range buckets {
+		a, ok := b.(Availabler)
+		if !ok {
+			return configured(buckets)
+		}
+		// MiB granularity avoids overflow when scaling by weight.
+		weights[i] = (a.Available() >> 20) * uint64(pick.Weight(b))
+		total += weights[i]
+	}
+	if total == 0 {
+		return configured(buckets)
+	}
+	return weights, total
+}
+
+func configured(buckets []storage.Bucket) ([]uint64, uint64) {
+	weights := make([]uint64, len(buckets))
+	var total uint64
+	for i, b := range buckets {
+		weights[i] = uint64(pick.Weight(b))
+		total += weights[i]
+	}
+	return weights, total
+}
+
+// Rebuild implements storage.Selector.
+func (f *FreeSpace) Rebuild(_ context.Context, buckets []storage.Bucket) error {
+	f.mu.Lock()
+	f.buckets = buckets
+	f.mu.Unlock()
+	return nil
+}
@@ -2,9 +2,11 @@ package hashring
 
 import (
 	"context"
+	"sync"
 
 	"github.com/omalloc/tavern/api/defined/v1/storage"
 	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+	"github.com/omalloc/tavern/storage/selector/internal/pick"
 )
 
 const (
@@ -17,6 +19,7 @@ var _ storage.Selector = (*Balancer)(nil)
 type Option func(*Balancer)
 
 type Balancer struct {
+	mu       sync.RWMutex
 	buckets  []storage.Bucket
 	replicas int
 	hashring *Consistent
@@ -41,18 +44,19 @@ func New(buckets []storage.Bucket, opts ...Option) (storage.Selector, error) {
 
 // Select implements storage.Selector.
 func (b *Balancer) Select(ctx context.Context, id *object.ID) storage.Bucket {
-	for i := 1; i <= len(b.buckets); i++ {
-		groups, err := b.hashring.GetN(string(id.Bytes()), i)
+	b.mu.RLock()
+	buckets, ring := b.buckets, b.hashring
+	b.mu.RUnlock()
+
+	for i := 1; i <= len(buckets); i++ {
+		groups, err := ring.GetN(string(id.Bytes()), i)
 		if err != nil {
 			return nil
 		}
 
 		bucket := groups[i-1].(storage.Bucket)
-		// use percent below HighPercent.
-		if bucket.UseAllow() {
-			if bucket.HasBad() {
-				continue
-			}
+		// use percent below HighPercent, 满盘只影响新对象的放置, 已缓存的对象继续从原 bucket 读取.
+		if pick.Serves(ctx, bucket, id) {
 			return bucket
 		}
 	}
@@ -66,8 +70,12 @@ func (b *Balancer) Rebuild(ctx context.Context, buckets []storage.Bucket) error
 		newBuckets = append(newBuckets, z)
 	}
 
+	ring := NewConsistent(newBuckets, b.replicas)
+
+	b.mu.Lock()
 	b.buckets = buckets
-	b.hashring = NewConsistent(newBuckets, b.replicas)
+	b.hashring = ring
+	b.mu.Unlock()
 	return nil
 }
 
@@ -0,0 +1,45 @@
+// Package pick provides the helpers shared by bucket selectors.
+package pick
+
+import (
+	"context"
+
+	"github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+)
+
+// Usable reports whether the bucket accepts new objects.
+func Usable(b storage.Bucket) bool {
+	return b.UseAllow() && !b.HasBad()
+}
+
+// Serves reports whether the bucket serves the object, a full bucket keeps serving
+// the objects it already holds, only the placement of new objects is skipped.
+func Serves(ctx context.Context, b storage.Bucket, id *object.ID) bool {
+	if b.HasBad() {
+		return false
+	}
+	return b.UseAllow() || b.Exist(ctx, id.Bytes())
+}
+
+// Existing returns the healthy bucket already holding the object, used by the
+// selectors that are not keyed by object ID.
+func Existing(ctx context.Context, buckets []storage.Bucket, id *object.ID) storage.Bucket {
+	for _, b := range buckets {
+		if b.HasBad() {
+			continue
+		}
+		if b.Exist(ctx, id.Bytes()) {
+			return b
+		}
+	}
+	return nil
+}
+
+// Weight returns the positive weight of bucket.
+func Weight(b storage.Bucket) int {
+	if w := b.Weight(); w > 0 {
+		return w
+	}
+	return 1
+}
@@ -0,0 +1,94 @@
+// Package rendezvous implements the weighted rendezvous (highest random weight) hashing.
+//
+// Every bucket scores each object independently, adding or removing a bucket
+// only moves the objects won or lost by that bucket.
+package rendezvous
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+
+	"github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+	"github.com/omalloc/tavern/storage/selector/internal/pick"
+)
+
+const Name = "rendezvous"
+
+var _ storage.Selector = (*Rendezvous)(nil)
+
+type node struct {
+	bucket storage.Bucket
+	seed   uint64
+	weight float64
+}
+
+type Rendezvous struct {
+	mu    sync.RWMutex
+	nodes []node
+}
+
+func New(buckets []storage.Bucket) (storage.Selector, error) {
+	r := &Rendezvous{}
+	_ = r.Rebuild(context.Background(), buckets)
+	return r, nil
+}
+
+// Select implements storage.Selector.
+//
+// buckets are tried in descending score order, a full bucket is passed over
+// unless it already holds the object.
+func (r *Rendezvous) Select(ctx context.Context, id *object.ID) storage.Bucket {
+	r.mu.RLock()
+	nodes := r.nodes
+	r.mu.RUnlock()
+
+	key := xxhash.Sum64(id.Bytes())
+
+	scores := make([]float64, len(nodes))
+	order := make([]int, len(nodes))
+	for i, n := range nodes {
+		scores[i], order[i] = n.score(key), i
+	}
+	sort.Slice(order, func(i, j int) bool { return scores[order[i]] > scores[order[j]] })
+
+	for _, i := range order {
+		if pick.Serves(ctx, nodes[i].bucket, id) {
+			return nodes[i].bucket
+		}
+	}
+	return nil
+}
+
+// score = -weight / ln(u), u is the uniform hash of (bucket, key) in (0, 1).
+func (n node) score(key uint64) float64 {
+	var buf [16]byte
+	binary.LittleEndian.PutUint64(buf[:8], n.seed)
+	binary.LittleEndian.PutUint64(buf[8:], key)
+	h := xxhash.Sum64(buf[:])
+
+	u := (float64(h>>11) + 0.5) / (1 << 53)
+	return -n.weight / math.Log(u)
+}
+
+// Rebuild implements storage.Selector.
+func (r *Rendezvous) Rebuild(_ context.Context, buckets []storage.Bucket) error {
+	nodes := make([]node, 0, len(buckets))
+	for _, b := range buckets {
+		nodes = append(nodes, node{
+			bucket: b,
+			seed:   xxhash.Sum64String(b.ID()),
+			weight: float64(pick.Weight(b)),
+		})
+	}
+
+	r.mu.Lock()
+	r.nodes = nodes
+	r.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,62 @@
+// Package roundrobin spreads new objects over the buckets in turn.
+//
+// The object is not keyed to a bucket, cached objects are located by asking
+// every bucket before a new one is picked.
+package roundrobin
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+	"github.com/omalloc/tavern/storage/selector/internal/pick"
+)
+
+const Name = "roundrobin"
+
+var _ storage.Selector = (*RoundRobin)(nil)
+
+type RoundRobin struct {
+	mu      sync.RWMutex
+	buckets []storage.Bucket
+	next    atomic.Uint64
+}
+
+func New(buckets []storage.Bucket) (storage.Selector, error) {
+	r := &RoundRobin{}
+	_ = r.Rebuild(context.Background(), buckets)
+	return r, nil
+}
+
+// Select implements storage.Selector.
+func (r *RoundRobin) Select(ctx context.Context, id *object.ID) storage.Bucket {
+	r.mu.RLock()
+	buckets := r.buckets
+	r.mu.RUnlock()
+
+	if b := pick.Existing(ctx, buckets, id); b != nil {
+		return b
+	}
+
+	n := uint64(len(buckets))
+	if n == 0 {
+		return nil
+	}
+	start := r.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		if b := buckets[(start+i)%n]; pick.Usable(b) {
+			return b
+		}
+	}
+	return nil
+}
+
+// Rebuild implements storage.Selector.
+func (r *RoundRobin) Rebuild(_ context.Context, buckets []storage.Bucket) error {
+	r.mu.Lock()
+	r.buckets = buckets
+	r.mu.Unlock()
+	return nil
+}
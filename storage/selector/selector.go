@@ -1,18 +1,53 @@
 package selector
 
 import (
+	"fmt"
+	"sort"
+
 	"github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/storage/selector/freespace"
 	"github.com/omalloc/tavern/storage/selector/hashring"
+	"github.com/omalloc/tavern/storage/selector/rendezvous"
+	"github.com/omalloc/tavern/storage/selector/roundrobin"
 )
 
-//var registrySelector = map[string]storage.Selector{
-//	"hashring": nil,
-//}
+// DefaultPolicy is used when `selection_policy` is not configured.
+const DefaultPolicy = hashring.Name
+
+type Factory func(buckets []storage.Bucket) (storage.Selector, error)
+
+var registrySelector = map[string]Factory{
+	hashring.Name: func(buckets []storage.Bucket) (storage.Selector, error) {
+		return hashring.New(buckets, hashring.WithReplicas(hashring.DefaultReplicas))
+	},
+	rendezvous.Name: rendezvous.New,
+	roundrobin.Name: roundrobin.New,
+	freespace.Name:  freespace.New,
+}
+
+// Register registers a custom selector.
+func Register(name string, factory Factory) {
+	registrySelector[name] = factory
+}
+
+// New creates the selector by `selection_policy`.
+func New(buckets []storage.Bucket, typ string) (storage.Selector, error) {
+	if typ == "" {
+		typ = DefaultPolicy
+	}
+	factory, ok := registrySelector[typ]
+	if !ok {
+		return nil, fmt.Errorf("unknown selection policy %q, available %v", typ, Names())
+	}
+	return factory(buckets)
+}
 
-func New(buckets []storage.Bucket, typ string) storage.Selector {
-	curr, err := hashring.New(buckets, hashring.WithReplicas(20))
-	if err != nil {
-		panic(err)
+// Names returns the registered selector names.
+func Names() []string {
+	names := make([]string, 0, len(registrySelector))
+	for name := range registrySelector {
+		names = append(names, name)
 	}
-	return curr
+	sort.Strings(names)
+	return names
 }
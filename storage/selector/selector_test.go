@@ -0,0 +1,165 @@
+package selector_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	storagev1 "github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+	"github.com/omalloc/tavern/storage/selector"
+)
+
+type stubBucket struct {
+	storagev1.Bucket
+	id      string
+	weight  int
+	avail   uint64
+	bad     bool
+	full    bool
+	objects map[string]bool
+}
+
+func newStub(id string, weight int) *stubBucket {
+	return &stubBucket{id: id, weight: weight, objects: make(map[string]bool)}
+}
+
+func (b *stubBucket) ID() string        { return b.id }
+func (b *stubBucket) Weight() int       { return b.weight }
+func (b *stubBucket) HasBad() bool      { return b.bad }
+func (b *stubBucket) UseAllow() bool    { return !b.full }
+func (b *stubBucket) Available() uint64 { return b.avail }
+func (b *stubBucket) Exist(_ context.Context, id []byte) bool {
+	return b.objects[string(id)]
+}
+
+func ids(n int) []*object.ID {
+	ret := make([]*object.ID, 0, n)
+	for i := range n {
+		ret = append(ret, object.NewID(fmt.Sprintf("http://www.example.com/%d.bin", i)))
+	}
+	return ret
+}
+
+func count(sel storagev1.Selector, objects []*object.ID) map[string]int {
+	ret := make(map[string]int)
+	for _, id := range objects {
+		ret[sel.Select(context.Background(), id).ID()]++
+	}
+	return ret
+}
+
+func TestNew(t *testing.T) {
+	for _, name := range append(selector.Names(), "") {
+		sel, err := selector.New([]storagev1.Bucket{newStub("/cache1", 100)}, name)
+		assert.NoError(t, err, name)
+		assert.NotNil(t, sel, name)
+	}
+
+	_, err := selector.New(nil, "random")
+	assert.Error(t, err)
+}
+
+// adding a bucket moves only the objects won by the new bucket.
+func TestConsistentMovement(t *testing.T) {
+	objects := ids(10000)
+
+	for _, name := range []string{"hashring", "rendezvous"} {
+		t.Run(name, func(t *testing.T) {
+			buckets := []storagev1.Bucket{newStub("/cache1", 100), newStub("/cache2", 100), newStub("/cache3", 100)}
+			sel, err := selector.New(buckets, name)
+			assert.NoError(t, err)
+
+			before := make(map[*object.ID]string, len(objects))
+			for _, id := range objects {
+				before[id] = sel.Select(context.Background(), id).ID()
+			}
+
+			assert.NoError(t, sel.Rebuild(context.Background(), append(buckets, newStub("/cache4", 100))))
+
+			moved := 0
+			for _, id := range objects {
+				now := sel.Select(context.Background(), id).ID()
+				if now != before[id] {
+					assert.Equal(t, "/cache4", now)
+					moved++
+				}
+			}
+			// ideal is 1/4
+			assert.InDelta(t, 0.25, float64(moved)/float64(len(objects)), 0.05)
+		})
+	}
+}
+
+func TestRendezvousWeight(t *testing.T) {
+	buckets := []storagev1.Bucket{newStub("/cache1", 100), newStub("/cache2", 300)}
+	sel, err := selector.New(buckets, "rendezvous")
+	assert.NoError(t, err)
+
+	got := count(sel, ids(10000))
+	assert.InDelta(t, 0.75, float64(got["/cache2"])/10000, 0.03)
+
+	// bad bucket is skipped
+	buckets[1].(*stubBucket).bad = true
+	assert.Equal(t, map[string]int{"/cache1": 100}, count(sel, ids(100)))
+}
+
+func TestRoundRobin(t *testing.T) {
+	b1, b2 := newStub("/cache1", 100), newStub("/cache2", 100)
+	sel, err := selector.New([]storagev1.Bucket{b1, b2}, "roundrobin")
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string]int{"/cache1": 50, "/cache2": 50}, count(sel, ids(100)))
+
+	// cached object is located in its bucket
+	id := object.NewID("http://www.example.com/cached.bin")
+	b2.objects[string(id.Bytes())] = true
+	for range 4 {
+		assert.Equal(t, "/cache2", sel.Select(context.Background(), id).ID())
+	}
+}
+
+func TestFreeSpace(t *testing.T) {
+	b1, b2 := newStub("/cache1", 100), newStub("/cache2", 100)
+	b1.avail, b2.avail = 100<<30, 300<<30
+	sel, err := selector.New([]storagev1.Bucket{b1, b2}, "freespace")
+	assert.NoError(t, err)
+
+	got := count(sel, ids(10000))
+	assert.InDelta(t, 0.75, float64(got["/cache2"])/10000, 0.03)
+
+	// full disk is not selected
+	b2.avail = 0
+	b1.avail = 1 << 30
+	assert.Equal(t, map[string]int{"/cache1": 100}, count(sel, ids(100)))
+}
+
+// full bucket keeps serving the cached objects, only new objects are placed elsewhere.
+func TestFullBucket(t *testing.T) {
+	for _, name := range selector.Names() {
+		t.Run(name, func(t *testing.T) {
+			b1, b2 := newStub("/cache1", 100), newStub("/cache2", 100)
+			b1.avail, b2.avail = 100<<30, 100<<30
+			sel, err := selector.New([]storagev1.Bucket{b1, b2}, name)
+			assert.NoError(t, err)
+
+			objects := ids(100)
+			for _, id := range objects {
+				if sel.Select(context.Background(), id).ID() == "/cache1" {
+					b1.objects[string(id.Bytes())] = true
+				}
+			}
+
+			b1.full = true
+			for _, id := range objects {
+				want := "/cache2"
+				if b1.objects[string(id.Bytes())] {
+					want = "/cache1"
+				}
+				assert.Equal(t, want, sel.Select(context.Background(), id).ID())
+			}
+		})
+	}
+}
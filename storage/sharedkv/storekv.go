@@ -8,13 +8,19 @@ import (
 
 // NewStoreSharedKV create a new store kv store
 func NewStoreSharedKV(storePath string) storage.SharedKV {
-	db, err := newNoneKV(storePath, &pebble.Options{
-		DisableWAL: true,
-		Logger:     log.NewHelper(log.NewFilter(log.GetLogger(), log.FilterLevel(log.LevelWarn))),
-	})
+	db, err := OpenStoreSharedKV(storePath)
 	if err != nil {
 		panic(err)
 	}
 
 	return db
 }
+
+// OpenStoreSharedKV is like NewStoreSharedKV but returns the error,
+// e.g. the pebble LOCK is still held by the parent process during hot upgrade.
+func OpenStoreSharedKV(storePath string) (storage.SharedKV, error) {
+	return newNoneKV(storePath, &pebble.Options{
+		DisableWAL: true,
+		Logger:     log.NewHelper(log.NewFilter(log.GetLogger(), log.FilterLevel(log.LevelWarn))),
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -20,6 +21,7 @@ import (
 )
 
 var _ storage.Storage = (*nativeStorage)(nil)
+var _ storage.LayerSelector = (*nativeStorage)(nil)
 
 type nativeStorage struct {
 	closed bool
@@ -32,6 +34,7 @@ type nativeStorage struct {
 	memoryBucket storage.Bucket
 	hotBucket    []storage.Bucket
 	warmlBucket  []storage.Bucket
+	stop         chan struct{}
 }
 
 func New(config *conf.Storage, logger log.Logger) (storage.Storage, error) {
@@ -49,26 +52,41 @@ func New(config *conf.Storage, logger log.Logger) (storage.Storage, error) {
 		mu:     sync.Mutex{},
 		log:    log.NewHelper(logger),
 
-		selector:     selector.New([]storage.Bucket{}, config.SelectionPolicy),
 		sharedkv:     sharedkv.NewMemSharedKV(),
 		nopBucket:    nopBucket,
 		memoryBucket: nil,
 		hotBucket:    make([]storage.Bucket, 0, len(config.Buckets)),
 		warmlBucket:  make([]storage.Bucket, 0, len(config.Buckets)),
+		stop:         make(chan struct{}),
+	}
+
+	// replace memkv with storekv before buckets are created,
+	// buckets write the inverted index into the same sharedkv.
+	if config.DirAware != nil && config.DirAware.Enabled && config.DirAware.StorePath != "" {
+		_ = os.MkdirAll(config.DirAware.StorePath, 0755)
+		kv, err := sharedkv.OpenStoreSharedKV(config.DirAware.StorePath)
+		if err != nil {
+			return nil, err
+		}
+		n.sharedkv = kv
 	}
 
 	if err := n.reinit(config); err != nil {
+		// release the indexdb of buckets already opened, so that the caller can retry.
+		_ = n.Close()
 		return nil, err
 	}
 
+	// eject bad buckets from hash ring
+	watchers := []*bucketWatcher{newBucketWatcher(storage.TypeWarm, n.warmlBucket, n.selector)}
+	if len(n.hotBucket) > 0 {
+		// hot buckets are not on the hash ring, only the bad state is watched.
+		watchers = append(watchers, newBucketWatcher(storage.TypeHot, n.hotBucket, nil))
+	}
+	go watchBuckets(n.stop, watchers...)
+
 	// diraware adapter
 	if config.DirAware != nil && config.DirAware.Enabled {
-		if config.DirAware.StorePath != "" {
-			_ = os.MkdirAll(config.DirAware.StorePath, 0755)
-			// replace memkv with storekv
-			n.sharedkv = sharedkv.NewStoreSharedKV(config.DirAware.StorePath)
-		}
-
 		// sharedkv used no-mem typ.
 		return diraware.New(n, diraware.NewChecker(n.sharedkv,
 			diraware.WithAutoClear(config.DirAware.AutoClear),
@@ -93,6 +111,7 @@ func (n *nativeStorage) reinit(config *conf.Storage) error {
 		Driver:          config.Driver,
 		DBType:          config.DBType,
 		DBPath:          config.DBPath,
+		TagHeader:       config.TagHeader,
 	}
 
 	for _, c := range config.Buckets {
@@ -127,29 +146,62 @@ func (n *nativeStorage) reinit(config *conf.Storage) error {
 		}
 	}
 
-	n.selector = selector.New(n.warmlBucket, config.SelectionPolicy)
+	sel, err := selector.New(n.warmlBucket, config.SelectionPolicy)
+	if err != nil {
+		return err
+	}
+	n.selector = sel
 
 	return nil
 }
 
 // Select implements storage.Selector.
 func (n *nativeStorage) Select(ctx context.Context, id *object.ID) storage.Bucket {
+	// objects forced into memory (X-FS-Mem) are not on the hash ring
+	if n.memoryBucket != nil && n.memoryBucket.Exist(ctx, id.Bytes()) {
+		return n.memoryBucket
+	}
+
 	bucket := n.selector.Select(ctx, id)
 	return bucket
 }
 
+// SelectLayer implements storage.LayerSelector.
+func (n *nativeStorage) SelectLayer(ctx context.Context, id *object.ID, layer string) storage.Bucket {
+	switch layer {
+	case storage.TypeInMemory:
+		return n.memoryBucket
+	case storage.TypeNormal, storage.TypeWarm:
+		return n.selector.Select(ctx, id)
+	}
+	return nil
+}
+
 // Rebuild implements storage.Selector.
 func (n *nativeStorage) Rebuild(ctx context.Context, buckets []storage.Bucket) error {
-	return nil
+	return n.selector.Rebuild(ctx, buckets)
 }
 
 // Buckets implements storage.Storage.
 func (n *nativeStorage) Buckets() []storage.Bucket {
-	return append(n.warmlBucket, n.hotBucket...)
+	buckets := make([]storage.Bucket, 0, len(n.warmlBucket)+len(n.hotBucket)+1)
+	buckets = append(buckets, n.warmlBucket...)
+	buckets = append(buckets, n.hotBucket...)
+
+	// the memory bucket is the warm bucket when no disk configured
+	if n.memoryBucket != nil && !slices.Contains(n.warmlBucket, n.memoryBucket) {
+		buckets = append(buckets, n.memoryBucket)
+	}
+	return buckets
 }
 
 // PURGE implements storage.Storage.
 func (n *nativeStorage) PURGE(storeUrl string, typ storage.PurgeControl) error {
+	// Cache tag purge, storeUrl is the tag
+	if typ.Tag {
+		return purgeTag(n.sharedkv, n.Buckets(), storeUrl, typ)
+	}
+
 	// Directory prefix purge
 	if typ.Dir {
 		// For mark-expired on dir, skip sharedkv hits and fallback to full scan below.
@@ -215,29 +267,7 @@ func (n *nativeStorage) PURGE(storeUrl string, typ storage.PurgeControl) error {
 	}
 
 	// Single object purge
-	cacheKey := object.NewID(storeUrl)
-
-	bucket := n.Select(context.Background(), cacheKey)
-	if bucket == nil {
-		return fmt.Errorf("bucket not found")
-	}
-
-	// hard delete cache file mode.
-	if typ.Hard {
-		return bucket.Discard(context.Background(), cacheKey)
-	}
-
-	// MarkExpired to revalidate.
-	// soft delete cache file mode.
-	md, err := bucket.Lookup(context.Background(), cacheKey)
-	if err != nil {
-		return err
-	}
-
-	// set expire time to past time. and then store it back.
-	md.ExpiresAt = time.Now().Add(-1).Unix()
-	// TODO: we should acquire a globalResourceLock before updating.
-	return bucket.Store(context.Background(), md)
+	return purgeObject(n, storeUrl, typ)
 }
 
 func (n *nativeStorage) SharedKV() storage.SharedKV {
@@ -246,6 +276,13 @@ func (n *nativeStorage) SharedKV() storage.SharedKV {
 
 // Close implements storage.Storage.
 func (n *nativeStorage) Close() error {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.stop)
+	}
+	n.mu.Unlock()
+
 	var errs []error
 	// close all buckets
 	for _, bucket := range n.warmlBucket {
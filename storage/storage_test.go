@@ -5,11 +5,15 @@ import (
 	"net/http"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 
 	storagev1 "github.com/omalloc/tavern/api/defined/v1/storage"
 	"github.com/omalloc/tavern/api/defined/v1/storage/object"
 	"github.com/omalloc/tavern/conf"
 	"github.com/omalloc/tavern/contrib/log"
+	"github.com/omalloc/tavern/pkg/cachekey"
 	"github.com/omalloc/tavern/storage"
 	_ "github.com/omalloc/tavern/storage/bucket/disk"
 	_ "github.com/omalloc/tavern/storage/indexdb/pebble"
@@ -58,3 +62,275 @@ func TestSelect(t *testing.T) {
 
 	t.Logf("object metadata: %+v", md)
 }
+
+func TestPurgeTag(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := storage.New(&conf.Storage{
+		DBType:          "pebble",
+		Driver:          "native",
+		EvictionPolicy:  "lru",
+		SelectionPolicy: "hashring",
+		TagHeader:       "Surrogate-Key",
+		DirAware:        &conf.DirAware{Enabled: true, StorePath: filepath.Join(dir, ".diraware")},
+		Buckets: []*conf.Bucket{
+			{Path: filepath.Join(dir, "/cache1"), Type: storagev1.TypeWarm},
+			{Path: filepath.Join(dir, "/cache2"), Type: storagev1.TypeWarm},
+		},
+	}, log.DefaultLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	store := func(rawurl, tags string) *object.ID {
+		id := object.NewID(rawurl)
+		h := make(http.Header)
+		h.Set("Surrogate-Key", tags)
+		if err := s.Select(ctx, id).Store(ctx, &object.Metadata{
+			ID:        id,
+			Size:      1024,
+			Code:      http.StatusOK,
+			Headers:   h,
+			Flags:     object.FlagCache,
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	shoe1 := store("http://www.example.com/shoes/1.jpg", "product-1 shoes")
+	shoe2 := store("http://www.example.com/shoes/2.jpg", "product-2 shoes")
+	hat1 := store("http://www.example.com/hats/1.jpg", "product-3 hats")
+
+	// soft purge marks the objects expired.
+	assert.NoError(t, s.PURGE("shoes", storagev1.PurgeControl{Tag: true, MarkExpired: true}))
+	for _, id := range []*object.ID{shoe1, shoe2} {
+		md, err := s.Select(ctx, id).Lookup(ctx, id)
+		assert.NoError(t, err)
+		assert.True(t, md.ExpiresAt <= time.Now().Unix())
+	}
+	md, err := s.Select(ctx, hat1).Lookup(ctx, hat1)
+	assert.NoError(t, err)
+	assert.True(t, md.ExpiresAt > time.Now().Unix())
+
+	// hard purge removes the objects and their tag index.
+	assert.NoError(t, s.PURGE("product-1", storagev1.PurgeControl{Tag: true, Hard: true}))
+	_, err = s.Select(ctx, shoe1).Lookup(ctx, shoe1)
+	assert.Error(t, err)
+	_, err = s.Select(ctx, shoe2).Lookup(ctx, shoe2)
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, s.PURGE("product-1", storagev1.PurgeControl{Tag: true, Hard: true}), storagev1.ErrKeyNotFound)
+	assert.ErrorIs(t, s.PURGE("unknown", storagev1.PurgeControl{Tag: true}), storagev1.ErrKeyNotFound)
+}
+
+func TestHandoff(t *testing.T) {
+	dir := t.TempDir()
+	config := &conf.Storage{
+		DBType:          "pebble",
+		Driver:          "native",
+		EvictionPolicy:  "lru",
+		SelectionPolicy: "hashring",
+		DirAware:        &conf.DirAware{Enabled: true, StorePath: filepath.Join(dir, ".diraware")},
+		Buckets: []*conf.Bucket{
+			{Path: filepath.Join(dir, "/cache1"), Type: storagev1.TypeWarm},
+		},
+	}
+
+	s, err := storage.New(config, log.DefaultLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.SetDefault(s)
+
+	ctx := context.Background()
+	id := object.NewID("http://www.example.com/handoff.bin")
+	release, ok := storage.Acquire()
+	assert.True(t, ok)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- storage.Handoff(ctx)
+	}()
+
+	// new requests BYPASS, the in-flight request still uses the bucket
+	assert.Eventually(t, storage.Bypassed, time.Second, 10*time.Millisecond)
+	_, ok = storage.Acquire()
+	assert.False(t, ok)
+	assert.NoError(t, s.Select(ctx, id).Store(ctx, &object.Metadata{
+		ID:      id,
+		Size:    1024,
+		Code:    http.StatusOK,
+		Headers: make(http.Header),
+		Flags:   object.FlagCache,
+	}))
+
+	select {
+	case <-done:
+		t.Fatal("handoff returned before in-flight request released")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	release()
+	select {
+	case err = <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("handoff not finished after drained")
+	}
+	assert.Equal(t, int64(0), storage.Inflight())
+
+	// indexdb released, reopen and take it back
+	s2, err := storage.New(config, log.DefaultLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.Resume(s2)
+	defer storage.Close()
+
+	release, ok = storage.Acquire()
+	assert.True(t, ok)
+	defer release()
+
+	md, err := s2.Select(ctx, id).Lookup(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1024), md.Size)
+}
+
+func TestSelectMemoryLayer(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := storage.New(&conf.Storage{
+		DBType:          "pebble",
+		Driver:          "native",
+		EvictionPolicy:  "lru",
+		SelectionPolicy: "hashring",
+		DirAware:        &conf.DirAware{Enabled: false},
+		Buckets: []*conf.Bucket{
+			{Path: filepath.Join(dir, "/cache1"), Type: storagev1.TypeWarm},
+			{Driver: "memory", Type: storagev1.TypeInMemory, MaxCacheSize: 1 << 20},
+		},
+	}, log.DefaultLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	cacheKey := object.NewID("http://www.example.com/live/index.m3u8")
+
+	// not forced, the object is on disk
+	assert.Equal(t, storagev1.TypeWarm, s.Select(ctx, cacheKey).StoreType())
+	assert.Len(t, s.Buckets(), 2)
+
+	ls, ok := s.(storagev1.LayerSelector)
+	assert.True(t, ok)
+
+	mem := ls.SelectLayer(ctx, cacheKey, storagev1.TypeInMemory)
+	assert.NotNil(t, mem)
+	assert.Nil(t, ls.SelectLayer(ctx, cacheKey, storagev1.TypeHot))
+
+	assert.NoError(t, mem.Store(ctx, &object.Metadata{
+		ID:      cacheKey,
+		Size:    1024,
+		Code:    http.StatusOK,
+		Headers: make(http.Header),
+	}))
+
+	// the object forced into memory is selected without the header
+	bucket := s.Select(ctx, cacheKey)
+	assert.Equal(t, storagev1.TypeInMemory, bucket.StoreType())
+
+	assert.NoError(t, s.PURGE(cacheKey.Path(), storagev1.PurgeControl{Hard: true}))
+	assert.False(t, mem.Exist(ctx, cacheKey.Bytes()))
+	assert.Equal(t, storagev1.TypeWarm, s.Select(ctx, cacheKey).StoreType())
+}
+
+func TestPurgeCacheKey(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := storage.New(&conf.Storage{
+		DBType:          "pebble",
+		Driver:          "native",
+		EvictionPolicy:  "lru",
+		SelectionPolicy: "hashring",
+		DirAware:        &conf.DirAware{Enabled: false},
+		Buckets: []*conf.Bucket{
+			{Path: filepath.Join(dir, "/cache1"), Type: storagev1.TypeWarm},
+		},
+	}, log.DefaultLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// the caching middleware drops the `utm_*` params from the key
+	b, err := cachekey.New([]cachekey.Rule{{Query: cachekey.QueryExclude, QueryParams: []string{"utm_*"}}}, true)
+	assert.NoError(t, err)
+	defer cachekey.Register("default", b)()
+
+	ctx := context.Background()
+	cacheKey := object.NewID("http://www.example.com/a.js?v=1")
+	assert.NoError(t, s.Select(ctx, cacheKey).Store(ctx, &object.Metadata{
+		ID:      cacheKey,
+		Size:    1024,
+		Code:    http.StatusOK,
+		Headers: make(http.Header),
+	}))
+
+	assert.NoError(t, s.PURGE("http://www.example.com/a.js?v=1&utm_source=x", storagev1.PurgeControl{Hard: true}))
+	assert.False(t, s.Select(ctx, cacheKey).Exist(ctx, cacheKey.Bytes()))
+}
+
+func TestPurgeCopies(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := storage.New(&conf.Storage{
+		DBType:          "pebble",
+		Driver:          "native",
+		EvictionPolicy:  "lru",
+		SelectionPolicy: "roundrobin",
+		DirAware:        &conf.DirAware{Enabled: false},
+		Buckets: []*conf.Bucket{
+			{Path: filepath.Join(dir, "/cache1"), Type: storagev1.TypeWarm},
+			{Path: filepath.Join(dir, "/cache2"), Type: storagev1.TypeWarm},
+		},
+	}, log.DefaultLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	id := object.NewID("http://www.example.com/copies.js")
+	store := func() {
+		for _, b := range s.Buckets() {
+			assert.NoError(t, b.Store(ctx, &object.Metadata{
+				ID:        id,
+				Size:      1024,
+				Code:      http.StatusOK,
+				Headers:   make(http.Header),
+				ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			}))
+		}
+	}
+
+	// the copies of both buckets are marked expired
+	store()
+	assert.NoError(t, s.PURGE(id.Path(), storagev1.PurgeControl{MarkExpired: true}))
+	for _, b := range s.Buckets() {
+		md, err := b.Lookup(ctx, id)
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, md.ExpiresAt, time.Now().Unix(), b.ID())
+	}
+
+	// the copies of both buckets are discarded
+	store()
+	assert.NoError(t, s.PURGE(id.Path(), storagev1.PurgeControl{Hard: true}))
+	for _, b := range s.Buckets() {
+		assert.False(t, b.Exist(ctx, id.Bytes()), b.ID())
+	}
+}
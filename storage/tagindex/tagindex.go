@@ -0,0 +1,128 @@
+// Package tagindex maintains the cache tag (surrogate key) inverted index in SharedKV.
+//
+// key schema: tag/<bucketID>/<escaped-tag>/<object-hash>
+// value: json encoded object.ID, used to lookup or discard the object.
+//
+// key schema: tags/<bucketID>/<object-hash>
+// value: the indexed tags of the object separated by space, used to drop the stale tags when it is stored again.
+package tagindex
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+)
+
+// MaxTags limits the number of tags indexed per object.
+const MaxTags = 64
+
+// Tags parses the tags from header values, separated by space or comma.
+func Tags(h http.Header, name string) []string {
+	if name == "" {
+		return nil
+	}
+
+	values := h.Values(name)
+	if len(values) == 0 {
+		return nil
+	}
+
+	tags := make([]string, 0, len(values))
+	seen := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		for _, tag := range strings.FieldsFunc(v, func(r rune) bool { return r == ' ' || r == ',' || r == '\t' }) {
+			if _, ok := seen[tag]; ok {
+				continue
+			}
+			seen[tag] = struct{}{}
+			tags = append(tags, tag)
+			if len(tags) >= MaxTags {
+				return tags
+			}
+		}
+	}
+	return tags
+}
+
+// Prefix returns the key prefix of all objects carrying tag in bucket.
+func Prefix(bucketID, tag string) []byte {
+	return []byte(fmt.Sprintf("tag/%s/%s/", bucketID, url.PathEscape(tag)))
+}
+
+// Key returns the index key of object.
+func Key(bucketID, tag string, id *object.ID) []byte {
+	return append(Prefix(bucketID, tag), id.HashStr()...)
+}
+
+// TagsKey returns the key of the indexed tags of object.
+func TagsKey(bucketID string, id *object.ID) []byte {
+	return []byte(fmt.Sprintf("tags/%s/%s", bucketID, id.HashStr()))
+}
+
+// indexed returns the tags of object written by the last Index.
+func indexed(ctx context.Context, kv storage.SharedKV, bucketID string, id *object.ID) []string {
+	val, err := kv.Get(ctx, TagsKey(bucketID, id))
+	if err != nil || len(val) == 0 {
+		return nil
+	}
+	return strings.Fields(string(val))
+}
+
+// Index writes the tags of md into kv, the tags of the previous stored object that md no longer
+// carries are removed. errors are ignored to not affect main storage.
+func Index(ctx context.Context, kv storage.SharedKV, bucketID, header string, md *object.Metadata) {
+	if header == "" {
+		return
+	}
+
+	tags := Tags(md.Headers, header)
+	for _, tag := range indexed(ctx, kv, bucketID, md.ID) {
+		if !slices.Contains(tags, tag) {
+			_ = kv.Delete(ctx, Key(bucketID, tag, md.ID))
+		}
+	}
+
+	if len(tags) == 0 {
+		_ = kv.Delete(ctx, TagsKey(bucketID, md.ID))
+		return
+	}
+
+	val, err := md.ID.MarshalJSON()
+	if err != nil {
+		return
+	}
+	for _, tag := range tags {
+		_ = kv.Set(ctx, Key(bucketID, tag, md.ID), val)
+	}
+	_ = kv.Set(ctx, TagsKey(bucketID, md.ID), []byte(strings.Join(tags, " ")))
+}
+
+// Unindex removes the tags of md from kv.
+func Unindex(ctx context.Context, kv storage.SharedKV, bucketID, header string, md *object.Metadata) {
+	tags := Tags(md.Headers, header)
+	for _, tag := range indexed(ctx, kv, bucketID, md.ID) {
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	for _, tag := range tags {
+		_ = kv.Delete(ctx, Key(bucketID, tag, md.ID))
+	}
+	_ = kv.Delete(ctx, TagsKey(bucketID, md.ID))
+}
+
+// Decode decodes the index value to object.ID.
+func Decode(val []byte) (*object.ID, error) {
+	id := &object.ID{}
+	if err := id.UnmarshalJSON(val); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
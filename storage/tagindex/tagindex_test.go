@@ -0,0 +1,86 @@
+package tagindex_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+	"github.com/omalloc/tavern/storage/sharedkv"
+	"github.com/omalloc/tavern/storage/tagindex"
+)
+
+func TestTags(t *testing.T) {
+	h := make(http.Header)
+	h.Add("Surrogate-Key", "product-1 category/shoes")
+	h.Add("Surrogate-Key", "product-1,homepage")
+
+	assert.Equal(t, []string{"product-1", "category/shoes", "homepage"}, tagindex.Tags(h, "Surrogate-Key"))
+	assert.Nil(t, tagindex.Tags(h, ""))
+	assert.Nil(t, tagindex.Tags(h, "Cache-Tag"))
+}
+
+func TestIndex(t *testing.T) {
+	ctx := context.Background()
+	kv := sharedkv.NewMemSharedKV()
+
+	md := &object.Metadata{
+		ID:      object.NewID("http://www.example.com/shoes/1.jpg"),
+		Headers: http.Header{"Cache-Tag": {"category/shoes category"}},
+	}
+	tagindex.Index(ctx, kv, "/cache1", "Cache-Tag", md)
+
+	collect := func(tag string) []string {
+		keys := make([]string, 0)
+		_ = kv.IteratePrefix(ctx, tagindex.Prefix("/cache1", tag), func(key, val []byte) error {
+			id, err := tagindex.Decode(val)
+			assert.NoError(t, err)
+			keys = append(keys, id.Key())
+			return nil
+		})
+		return keys
+	}
+
+	// `category` must not match `category/shoes`
+	assert.Equal(t, []string{"http://www.example.com/shoes/1.jpg"}, collect("category"))
+	assert.Equal(t, []string{"http://www.example.com/shoes/1.jpg"}, collect("category/shoes"))
+
+	tagindex.Unindex(ctx, kv, "/cache1", "Cache-Tag", md)
+	assert.Empty(t, collect("category"))
+	assert.Empty(t, collect("category/shoes"))
+}
+
+func TestReindex(t *testing.T) {
+	ctx := context.Background()
+	kv := sharedkv.NewMemSharedKV()
+
+	count := func(tag string) int {
+		n := 0
+		_ = kv.IteratePrefix(ctx, tagindex.Prefix("/cache1", tag), func(key, val []byte) error {
+			n++
+			return nil
+		})
+		return n
+	}
+
+	id := object.NewID("http://www.example.com/shoes/1.jpg")
+	tagindex.Index(ctx, kv, "/cache1", "Cache-Tag", &object.Metadata{ID: id, Headers: http.Header{"Cache-Tag": {"product-1 sale"}}})
+	assert.Equal(t, 1, count("product-1"))
+	assert.Equal(t, 1, count("sale"))
+
+	// stored again with different tags, `sale` is stale
+	tagindex.Index(ctx, kv, "/cache1", "Cache-Tag", &object.Metadata{ID: id, Headers: http.Header{"Cache-Tag": {"product-1 new"}}})
+	assert.Equal(t, 1, count("product-1"))
+	assert.Equal(t, 0, count("sale"))
+	assert.Equal(t, 1, count("new"))
+
+	// stored again without tags
+	tagindex.Index(ctx, kv, "/cache1", "Cache-Tag", &object.Metadata{ID: id, Headers: http.Header{}})
+	assert.Equal(t, 0, count("product-1"))
+	assert.Equal(t, 0, count("new"))
+
+	_, err := kv.Get(ctx, tagindex.TagsKey("/cache1", id))
+	assert.Error(t, err)
+}
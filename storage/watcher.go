@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/contrib/log"
+	"github.com/omalloc/tavern/pkg/health"
+)
+
+const (
+	// bucketWatchInterval 坏盘检测周期
+	bucketWatchInterval = 5 * time.Second
+	// healthComponent is the component name in readiness probe.
+	healthComponent = "storage"
+)
+
+// bucketWatcher takes bad buckets out of the selector hash ring,
+// and puts them back once recovered. nil selector only watches the bad state.
+type bucketWatcher struct {
+	layer    string
+	buckets  []storage.Bucket
+	selector storage.Selector
+	ejected  map[string]bool
+}
+
+func newBucketWatcher(layer string, buckets []storage.Bucket, selector storage.Selector) *bucketWatcher {
+	return &bucketWatcher{
+		layer:    layer,
+		buckets:  buckets,
+		selector: selector,
+		ejected:  make(map[string]bool, len(buckets)),
+	}
+}
+
+// check rebuilds the selector when the bad state of any bucket changed,
+// and reports whether the layer has an available bucket.
+func (w *bucketWatcher) check(ctx context.Context) bool {
+	healthy := make([]storage.Bucket, 0, len(w.buckets))
+	changed := false
+	for _, b := range w.buckets {
+		bad := b.HasBad()
+		if bad != w.ejected[b.ID()] {
+			changed = true
+			if bad {
+				log.Warnf("%s bucket %s is bad, eject from hash ring", w.layer, b.ID())
+			} else {
+				log.Infof("%s bucket %s recovered, put back to hash ring", w.layer, b.ID())
+			}
+		}
+		w.ejected[b.ID()] = bad
+		if !bad {
+			healthy = append(healthy, b)
+		}
+	}
+
+	if changed && w.selector != nil {
+		if err := w.selector.Rebuild(ctx, healthy); err != nil {
+			log.Errorf("rebuild %s selector failed: %v", w.layer, err)
+		}
+	}
+	return len(healthy) > 0 || len(w.buckets) == 0
+}
+
+// watchBuckets runs the watchers until stop closed.
+// 某一层的 bucket 全部不可用时 readiness-probe 失败.
+func watchBuckets(stop <-chan struct{}, watchers ...*bucketWatcher) {
+	ticker := time.NewTicker(bucketWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			health.MarkHealthy(healthComponent)
+			return
+		case <-ticker.C:
+			down := make([]string, 0)
+			for _, w := range watchers {
+				if !w.check(context.Background()) {
+					down = append(down, w.layer)
+				}
+			}
+
+			if len(down) == 0 {
+				health.MarkHealthy(healthComponent)
+				continue
+			}
+			health.MarkUnhealthy(healthComponent, fmt.Sprintf("all %s buckets are bad", strings.Join(down, ",")), 0)
+		}
+	}
+}
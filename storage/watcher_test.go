@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	storagev1 "github.com/omalloc/tavern/api/defined/v1/storage"
+	"github.com/omalloc/tavern/api/defined/v1/storage/object"
+	"github.com/omalloc/tavern/storage/selector"
+)
+
+type stubBucket struct {
+	storagev1.Bucket
+	id  string
+	bad atomic.Bool
+}
+
+func (b *stubBucket) ID() string     { return b.id }
+func (b *stubBucket) HasBad() bool   { return b.bad.Load() }
+func (b *stubBucket) UseAllow() bool { return true }
+func (b *stubBucket) Weight() int    { return 100 }
+
+func TestBucketWatcher(t *testing.T) {
+	b1, b2 := &stubBucket{id: "/cache1"}, &stubBucket{id: "/cache2"}
+	buckets := []storagev1.Bucket{b1, b2}
+	sel, err := selector.New(buckets, "hashring")
+	assert.NoError(t, err)
+	w := newBucketWatcher(storagev1.TypeWarm, buckets, sel)
+
+	ids := make([]*object.ID, 0, 100)
+	for i := range 100 {
+		ids = append(ids, object.NewID("http://www.example.com/"+string(rune('a'+i%26))+string(rune('a'+i/26))))
+	}
+
+	assert.True(t, w.check(context.Background()))
+
+	// eject /cache1, all objects go to /cache2
+	b1.bad.Store(true)
+	assert.True(t, w.check(context.Background()))
+	for _, id := range ids {
+		assert.Equal(t, "/cache2", sel.Select(context.Background(), id).ID())
+	}
+
+	// all bad
+	b2.bad.Store(true)
+	assert.False(t, w.check(context.Background()))
+
+	// recovered, both buckets are selected again
+	b1.bad.Store(false)
+	b2.bad.Store(false)
+	assert.True(t, w.check(context.Background()))
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		seen[sel.Select(context.Background(), id).ID()] = true
+	}
+	assert.Len(t, seen, 2)
+}